@@ -0,0 +1,406 @@
+// Command phi-replay plays back a relay recording.
+//
+// By default, the recording is fed into a fresh in-process relay lobby: every recorded peer is
+// recreated as a WebRTC client connected to its own relay, and sends the packets it sent during
+// the recording at the time it sent them. Every packet the clients receive from the relay is
+// printed to stdout as a JSON line.
+//
+// With -spectate, the recording is instead streamed to websocket clients as JSON lines, paced
+// by the recorded timestamps, so that matches can be watched back.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
+	srv "github.com/MikeDev101/cloudlink-phi/server/pkg/signaling"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pion/webrtc/v4"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// The game and lobby that recordings are replayed into.
+const (
+	replayGame  = "replay"
+	replayLobby = "replay"
+)
+
+// Output is a packet received by a replayed peer, printed as a JSON line.
+type Output struct {
+	Time    time.Duration `json:"time"`
+	Peer    string        `json:"peer"`
+	Channel string        `json:"channel"`
+	Packet  any           `json:"packet"`
+}
+
+// Player is a recorded peer that has been recreated in the replay lobby.
+type Player struct {
+	Client   *structs.Client
+	Relay    *structs.Relay
+	Conn     *webrtc.PeerConnection
+	Channels map[string]*webrtc.DataChannel
+	Output   func(channel string, packet any) // called with every packet the player receives
+	Mux      sync.Mutex
+}
+
+func main() {
+	speed := flag.Float64("speed", 1, "playback speed. Lobby rate limits still apply when replaying faster than recorded.")
+	linger := flag.Duration("linger", 2*time.Second, "how long to keep the lobby running after the last recorded event")
+	spectate := flag.String("spectate", "", "stream the recording to websocket clients on this address instead of replaying it, e.g. :3001")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <recording.jsonl>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *speed <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	entries, err := read(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to read recording: %s", err.Error())
+	}
+
+	if *spectate != "" {
+		serve(*spectate, entries, *speed)
+		return
+	}
+	replay(entries, *speed, *linger)
+}
+
+// read loads every entry of a recording.
+func read(path string) ([]*structs.RecordEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []*structs.RecordEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 2*peer.MaximumMessageSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := &structs.RecordEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// wait sleeps until an entry is due, relative to the start of playback.
+func wait(started time.Time, entry *structs.RecordEntry, speed float64) {
+	time.Sleep(time.Until(started.Add(time.Duration(float64(entry.Time) / speed))))
+}
+
+// serve streams the recording to every websocket client that connects to the address.
+// Each client gets its own playback, starting from the beginning of the recording.
+func serve(addr string, entries []*structs.RecordEntry, speed float64) {
+	app := fiber.New()
+	app.Use("/", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	})
+	app.Get("/", websocket.New(func(conn *websocket.Conn) {
+		log.Printf("Spectator %s connected", conn.RemoteAddr())
+		started := time.Now()
+		for _, entry := range entries {
+			wait(started, entry, speed)
+			bytes, err := json.Marshal(entry)
+			if err != nil {
+				log.Printf("Failed to marshal entry: %s", err.Error())
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, bytes); err != nil {
+				log.Printf("Spectator %s disconnected: %s", conn.RemoteAddr(), err.Error())
+				return
+			}
+		}
+		log.Printf("Spectator %s finished watching the recording", conn.RemoteAddr())
+	}))
+
+	log.Printf("Streaming %d recorded events to spectators on %s", len(entries), addr)
+	log.Fatal(app.Listen(addr))
+}
+
+// replay feeds the recording into a fresh in-process relay lobby.
+func replay(entries []*structs.RecordEntry, speed float64, linger time.Duration) {
+	s := (*structs.Server)(srv.Initialize([]string{"*"}, false, ""))
+
+	// Replayed peers connect over loopback, so no STUN or TURN servers are needed
+	loopback := *s.Settings.Load()
+	loopback.ICEServers = []webrtc.ICEServer{}
+	s.Settings.Store(&loopback)
+
+	settings := &structs.LobbySettings{LobbyID: replayLobby}
+	players := make(map[string]*Player)
+	out := json.NewEncoder(os.Stdout)
+	outMux := &sync.Mutex{}
+	started := time.Now()
+
+	for _, entry := range entries {
+		wait(started, entry, speed)
+		switch entry.Event {
+
+		case "start":
+			if entry.Settings != nil {
+				settings = entry.Settings
+			}
+
+		case "join":
+			if entry.Origin == nil {
+				continue
+			}
+			player, err := join(s, settings, entry.Origin, func(channel string, packet any) {
+				outMux.Lock()
+				defer outMux.Unlock()
+				out.Encode(&Output{
+					Time:    time.Since(started),
+					Peer:    entry.Origin.ID,
+					Channel: channel,
+					Packet:  packet,
+				})
+			})
+			if err != nil {
+				log.Printf("Failed to replay %s joining the lobby: %s", entry.Origin.ID, err.Error())
+				continue
+			}
+			players[entry.Origin.ID] = player
+
+		case "leave":
+			if entry.Origin == nil || players[entry.Origin.ID] == nil {
+				continue
+			}
+			leave(s, players[entry.Origin.ID])
+			delete(players, entry.Origin.ID)
+
+		case "open":
+			if entry.Origin == nil || players[entry.Origin.ID] == nil || entry.Info == nil {
+				continue
+			}
+			if _, err := open(players[entry.Origin.ID], entry.Info); err != nil {
+				log.Printf("Failed to replay %s opening channel %s: %s", entry.Origin.ID, entry.Channel, err.Error())
+			}
+
+		case "packet":
+			if entry.Origin == nil || players[entry.Origin.ID] == nil || entry.Packet == nil {
+				continue
+			}
+			if err := send(players[entry.Origin.ID], entry.Channel, entry.Packet); err != nil {
+				log.Printf("Failed to replay %s from %s: %s", entry.Opcode, entry.Origin.ID, err.Error())
+			}
+		}
+	}
+
+	// Give the relay time to deliver the last packets before closing the lobby
+	time.Sleep(linger)
+	for id, player := range players {
+		leave(s, player)
+		delete(players, id)
+	}
+	manager.DestroyLobby(s, replayGame, replayLobby)
+	close(s.Stopped)
+}
+
+// join recreates a recorded peer: it creates the peer's client, adds it to the replay lobby,
+// spawns its relay and connects a WebRTC client to it. The first peer to join becomes the host.
+// Every packet the peer receives is passed to the output function.
+func join(s *structs.Server, settings *structs.LobbySettings, info *structs.PeerInfo, output func(channel string, packet any)) (*Player, error) {
+	client := &structs.Client{
+		ID:             info.ID,
+		Username:       info.User,
+		UGI:            replayGame,
+		Mux:            &sync.RWMutex{},
+		Metadata:       make(map[string]any),
+		TransitionDone: make(chan bool),
+	}
+	if err := manager.CreateSession(s, client); err != nil {
+		return nil, err
+	}
+	manager.AddClientToGame(s, replayGame, client)
+
+	// Open the lobby if this is the first peer, otherwise join it
+	if !manager.DoesLobbyExist(s, replayLobby, replayGame) {
+		replayed := *settings
+		replayed.LobbyID = replayLobby
+		replayed.UseServerRelay = true
+		replayed.Record = false
+		replayed.Password = ""
+		manager.AddClientToLobby(s, replayLobby, replayGame, client)
+		manager.SetLobbySettings(s, replayLobby, replayGame, &replayed)
+		manager.SetLobbyHost(s, replayLobby, replayGame, client)
+		client.SetHostMode()
+		if replayed.Tick != nil {
+			peer.StartTicks(s, replayGame, replayLobby, replayed.Tick)
+		}
+	} else {
+		manager.AddClientToLobby(s, replayLobby, replayGame, client)
+		client.SetPeerMode()
+	}
+	client.SetLobby(replayLobby)
+
+	relay, err := peer.Spawn(s, replayGame, replayLobby, client)
+	if err != nil {
+		return nil, err
+	}
+	manager.SetRelay(s, client, relay)
+
+	conn, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, err
+	}
+	player := &Player{
+		Client:   client,
+		Relay:    relay,
+		Conn:     conn,
+		Channels: make(map[string]*webrtc.DataChannel),
+		Output:   output,
+	}
+
+	// Create the default data channel, matching the relay's
+	yes := true
+	zero := uint16(0)
+	protocol := "clomega"
+	d, err := conn.CreateDataChannel("default", &webrtc.DataChannelInit{
+		Negotiated: &yes,
+		ID:         &zero,
+		Ordered:    &yes,
+		Protocol:   &protocol,
+	})
+	if err != nil {
+		return nil, err
+	}
+	opened := make(chan bool)
+	d.OnOpen(func() { close(opened) })
+	attach(player, d)
+
+	// Channels created by the relay
+	conn.OnDataChannel(func(d *webrtc.DataChannel) {
+		attach(player, d)
+	})
+
+	// Connect to the relay, exchanging complete descriptions instead of trickling candidates
+	offer, err := conn.CreateOffer(nil)
+	if err != nil {
+		return nil, err
+	}
+	gathered := webrtc.GatheringCompletePromise(conn)
+	if err := conn.SetLocalDescription(offer); err != nil {
+		return nil, err
+	}
+	<-gathered
+	if _, err := peer.MakeAnswerFromOffer(relay, conn.LocalDescription()); err != nil {
+		return nil, err
+	}
+	<-webrtc.GatheringCompletePromise(relay.Conn)
+	if err := conn.SetRemoteDescription(*relay.Conn.LocalDescription()); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		return player, fmt.Errorf("timed out connecting to the relay")
+	}
+	return player, nil
+}
+
+// attach registers a data channel with a player and outputs the packets it receives.
+func attach(player *Player, d *webrtc.DataChannel) {
+	player.Mux.Lock()
+	player.Channels[d.Label()] = d
+	player.Mux.Unlock()
+
+	d.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var packet any
+		var err error
+		if msg.IsString {
+			err = json.Unmarshal(msg.Data, &packet)
+		} else {
+			err = msgpack.Unmarshal(msg.Data, &packet)
+		}
+		if err != nil {
+			log.Printf("Failed to parse message on channel %s: %s", d.Label(), err.Error())
+			return
+		}
+		player.Output(d.Label(), packet)
+	})
+}
+
+// open opens a data channel to the relay with the settings it was recorded with, unless the
+// player already has it.
+func open(player *Player, info *structs.RelayChannelInfo) (*webrtc.DataChannel, error) {
+	player.Mux.Lock()
+	d, exists := player.Channels[info.Label]
+	player.Mux.Unlock()
+	if exists {
+		return d, nil
+	}
+
+	d, err := player.Conn.CreateDataChannel(info.Label, &webrtc.DataChannelInit{
+		Ordered:           &info.Ordered,
+		MaxRetransmits:    info.MaxRetransmits,
+		MaxPacketLifeTime: info.MaxPacketLifeTime,
+		Protocol:          &info.Protocol,
+	})
+	if err != nil {
+		return nil, err
+	}
+	opened := make(chan bool)
+	d.OnOpen(func() { close(opened) })
+	attach(player, d)
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		return nil, fmt.Errorf("timed out opening channel %s", info.Label)
+	}
+	return d, nil
+}
+
+// send sends a recorded packet to the relay over the channel it was recorded on, in the
+// channel's encoding. Recordings made before channel settings were recorded don't open their
+// channels first, so a missing channel is opened as an ordered channel with no protocol.
+func send(player *Player, channel string, packet *structs.RelayPacket) error {
+	d, err := open(player, &structs.RelayChannelInfo{Label: channel, Ordered: true})
+	if err != nil {
+		return err
+	}
+
+	encoding := peer.ChannelEncoding(d)
+	bytes, err := peer.Encode(encoding, packet)
+	if err != nil {
+		return err
+	}
+	if encoding == peer.EncodingMsgpack {
+		return d.Send(bytes)
+	}
+	return d.SendText(string(bytes))
+}
+
+// leave disconnects a player from the relay and removes it from the replay lobby.
+func leave(s *structs.Server, player *Player) {
+	player.Conn.Close()
+	if manager.GetRelay(s, player.Client) != nil {
+		manager.DeleteRelay(s, player.Client)
+	}
+	manager.RemoveClientFromLobby(s, replayLobby, replayGame, player.Client)
+	manager.RemoveClientFromGame(s, replayGame, player.Client)
+	manager.DeleteSession(s, player.Client)
+}
@@ -1,31 +1,305 @@
 package main
 
 import (
-	"github.com/gofiber/fiber/v2/middleware/logger"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
 	"github.com/gofiber/fiber/v2/middleware/recover"
 
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/admin"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/certificate"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/config"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/health"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/metrics"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
 	srv "github.com/MikeDev101/cloudlink-phi/server/pkg/signaling"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/address"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/origin"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/session"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/stun"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 )
 
 func main() {
+	format := flag.String("log-format", "text", "log output format, text or json")
+	level := flag.String("log-level", "info", "log level of every subsystem: debug, info, warn or error")
+	levels := flag.String("log-levels", "", "log levels of individual subsystems, e.g. signaling=debug,relay=warn")
+	redact := flag.Bool("log-redact", false, "replace usernames and passwords in logs with "+logging.Redacted)
+	timeout := flag.Duration("shutdown-timeout", session.DefaultDrainTimeout, "how long clients are given to leave before the server exits on SIGTERM, or when an admin drains it")
+	reconnect := flag.String("reconnect-url", "", "URL that clients are told to reconnect to when the server drains, empty to reconnect to the same address")
+	maxSessions := flag.Int("max-sessions", 0, "sessions the server accepts at once, unlimited if 0")
+	maxPerIP := flag.Int("max-sessions-per-ip", 16, "sessions an IP address may have at once, unlimited if 0")
+	connectionRate := flag.Float64("connection-rate", 2, "new connections per second an IP address may make, unlimited if 0")
+	connectionBurst := flag.Int("connection-burst", 10, "new connections an IP address may make at once")
+	allow := flag.String("allow-ips", "", "comma separated networks in CIDR notation that may connect, all if empty")
+	deny := flag.String("deny-ips", "", "comma separated networks in CIDR notation that may never connect")
+	proxies := flag.String("trusted-proxies", "", "comma separated networks in CIDR notation whose X-Forwarded-For headers are trusted")
+	opcodeLimits := flag.String("opcode-limits", "", "rate limits of signaling opcodes that replace the defaults, e.g. LOBBY_LIST=1/5,ICE=50/200 in packets per second/burst")
+	frameSize := flag.Int64("max-frame-size", srv.DefaultMaxFrameSize, "largest websocket message in bytes a client may send, unlimited if 0")
+	rateViolations := flag.Int("max-rate-violations", srv.DefaultMaximumRateViolations, "rate limited packets a client may send within 10 seconds before it is disconnected, unlimited if 0")
+	origins := flag.String("origins", "*", "comma separated origins that may connect, such as https://*.example.com. Use * for all origins")
+	allowMissing := flag.Bool("allow-missing-origin", true, "allow requests without an Origin header, made by native clients")
+	originPolicy := flag.String("origin-policy", "", "JSON origin policy file, which replaces -origins and -allow-missing-origin and can be reloaded through the admin API")
+	recordings := flag.String("recordings-dir", "", "directory that relay recordings are written to, for lobbies that request recording. Recording is disabled if empty")
+	recordingSize := flag.Int64("recording-max-size", peer.DefaultRecordingMaxSize, "bytes a relay recording may grow to before it is stopped, unlimited if 0")
+	recordingDuration := flag.Duration("recording-max-duration", peer.DefaultRecordingMaxDuration, "how long a relay recording may run before it is stopped, unlimited if 0")
+	stunHost := flag.String("stun-host", "", "public host name or IP address that clients reach the embedded STUN responder with, which DIAGNOSE uses to observe NAT behaviour. The responder is disabled if empty")
+	stunPort := flag.Int("stun-port", 3478, "UDP port of the embedded STUN responder")
+	stunAltPort := flag.Int("stun-alt-port", 3479, "second UDP port of the embedded STUN responder")
+	metricsListen := flag.String("metrics-listen", "", "address of a separate plain HTTP listener that serves /metrics without authentication, such as 127.0.0.1:9100. If empty, /metrics is served on -listen and requires the admin token")
+	listen := flag.String("listen", ":3000", "address to listen on")
+	certfile := flag.String("tls-cert", "", "PEM certificate file, which enables TLS along with -tls-key. Reloaded when it changes")
+	keyfile := flag.String("tls-key", "", "PEM private key file of the certificate")
+	tlsVersion := flag.String("tls-min-version", "1.2", "minimum TLS version, 1.2 or 1.3")
+	ciphers := flag.String("tls-ciphers", "", "comma separated TLS 1.2 cipher suites, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Go's defaults are used if empty")
+	redirect := flag.String("http-redirect", "", "address of a plain HTTP listener that redirects to HTTPS, such as :80. Disabled if empty")
+	turnOnly := flag.Bool("turn-only", false, "only relay TURN candidates, ignoring candidates that specify STUN")
+	configfile := flag.String("config", "", "JSON configuration file, which overrides the flags above and is reloaded on SIGHUP or through the admin API")
+	token := flag.String("admin-token", os.Getenv("PHI_ADMIN_TOKEN"), "bearer token of the admin API, which is disabled if empty. Defaults to $PHI_ADMIN_TOKEN")
+	flag.Parse()
+
+	// Configure logging
+	logconfig := logging.Config{Format: *format, Redact: *redact}
+	if err := logconfig.Level.UnmarshalText([]byte(*level)); err != nil {
+		slog.Error("Invalid log level", "error", err)
+		os.Exit(2)
+	}
+	parsed, err := logging.ParseLevels(*levels)
+	if err != nil {
+		slog.Error("Invalid subsystem log levels", "error", err)
+		os.Exit(2)
+	}
+	logconfig.Levels = parsed
+	logging.Setup(logconfig)
+
+	// Load the TLS certificate
+	var tlsconfig *tls.Config
+	var loader *certificate.Loader
+	if *certfile != "" || *keyfile != "" {
+		loader, err = certificate.Load(*certfile, *keyfile)
+		if err == nil {
+			tlsconfig, err = certificate.Config(loader, *tlsVersion, *ciphers)
+		}
+		if err != nil {
+			slog.Error("Invalid TLS configuration", "error", err)
+			os.Exit(2)
+		}
+	}
+
+	// Load the origin policy
+	var policy *origin.Policy
+	if *originPolicy != "" {
+		policy, err = origin.Load(*originPolicy)
+	} else {
+		policy, err = origin.Compile(origin.Config{Origins: strings.Split(*origins, ","), AllowMissing: *allowMissing})
+	}
+	if err != nil {
+		slog.Error("Invalid origin policy", "error", err)
+		os.Exit(2)
+	}
+
+	// Configure the settings that can be reloaded
+	settings := srv.DefaultSettings(*turnOnly)
+	settings.DrainTimeout = *timeout
+	settings.ReconnectURL = *reconnect
+	settings.MaxSessions = *maxSessions
+	settings.Limits = &structs.ConnectionLimits{
+		MaxSessionsPerIP: *maxPerIP,
+		Rate:             *connectionRate,
+		Burst:            *connectionBurst,
+	}
+	for _, networks := range []struct {
+		flag   string
+		text   string
+		target *[]*net.IPNet
+	}{
+		{"allow-ips", *allow, &settings.Limits.Allow},
+		{"deny-ips", *deny, &settings.Limits.Deny},
+		{"trusted-proxies", *proxies, &settings.Limits.TrustedProxies},
+	} {
+		parsed, err := address.ParseNetworks(networks.text)
+		if err != nil {
+			slog.Error("Invalid networks", "flag", networks.flag, "error", err)
+			os.Exit(2)
+		}
+		*networks.target = parsed
+	}
+
+	// Configure signaling limits
+	opcodes, err := srv.ParseOpcodeLimits(*opcodeLimits)
+	if err != nil {
+		slog.Error("Invalid opcode limits", "error", err)
+		os.Exit(2)
+	}
+	maps.Copy(settings.PacketLimits.Opcodes, opcodes)
+	settings.PacketLimits.MaxFrameSize = *frameSize
+	settings.PacketLimits.MaximumViolations = *rateViolations
+
+	// Configure relay recording
+	settings.RecordingsDir = *recordings
+	settings.RecordingMaxSize = *recordingSize
+	settings.RecordingMaxDuration = *recordingDuration
+
+	// Configure the STUN responder
+	settings.STUNHost = *stunHost
+	settings.STUNPort = *stunPort
+	settings.STUNAltPort = *stunAltPort
+	if err := config.CheckSTUN(settings); err != nil {
+		slog.Error("Invalid STUN responder configuration", "error", err)
+		os.Exit(2)
+	}
+
 	s := srv.Initialize(
-		[]string{"*"}, // Allowed origins. Use * for all origins.
-		false,         // Enable TURN only mode. Candidates that specify STUN will be ignored, and only TURN candidates will be relayed.
+		policy.Config.Origins, // Allowed origins. Use * for all origins.
+		*turnOnly,             // Enable TURN only mode. Candidates that specify STUN will be ignored, and only TURN candidates will be relayed.
+		*recordings,           // Directory to write relay recordings to. Leave empty to disable recording.
 	)
+	s.Settings.Store(settings)
+
+	// Use the loaded origin policy, which may restrict individual games
+	s.Origins.Store(policy)
+	s.OriginBase.Store(policy)
+	s.OriginPolicyFile = *originPolicy
+
+	// Load the configuration file on top of the flags
+	if *configfile != "" {
+		base := map[string]slog.Level{}
+		for _, subsystem := range logging.Subsystems {
+			base[subsystem] = logging.Level(subsystem)
+		}
+		loader := &config.Loader{Path: *configfile, Base: settings, Levels: base}
+		if err := loader.Load((*structs.Server)(s)); err != nil {
+			slog.Error("Invalid configuration file", "error", err)
+			os.Exit(2)
+		}
+		s.Reload = func() error {
+			err := loader.Load((*structs.Server)(s))
+			if err != nil {
+				logging.Signaling().Warn("Configuration reload rejected, keeping the current configuration", "error", err)
+			}
+			return err
+		}
+
+		// Reload the configuration file on SIGHUP
+		go func() {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGHUP)
+			for range signals {
+				logging.Signaling().Info("Reloading configuration", "file", *configfile)
+				s.Reload()
+			}
+		}()
+	}
+
+	// Start the STUN responder that the DIAGNOSE opcode uses to observe the NAT behaviour of clients.
+	// It needs two UDP ports that clients can reach using the public host name, so it is only started
+	// if one is configured. Like the other settings, the config file overrides the flags.
+	current := s.Settings.Load()
+	if current.STUNHost == "" {
+		logging.Signaling().Info("STUN responder disabled, set -stun-host to enable it")
+	} else if responder, err := stun.Listen(current.STUNHost, fmt.Sprintf(":%d", current.STUNPort), fmt.Sprintf(":%d", current.STUNAltPort)); err != nil {
+		logging.Signaling().Warn("STUN responder disabled", "error", err)
+	} else {
+		s.STUN = responder
+	}
 
 	// Initialize app
-	app := fiber.New()
+	app := fiber.New(fiber.Config{DisableStartupMessage: *format == "json"})
+
+	// Initialize middleware
+	app.Use(logging.Middleware())
+	app.Use(recover.New())
 
-	// Configure routes
+	// Configure routes. The metrics, health and admin endpoints must come before the websocket upgrader.
+	// The metrics describe every game, so they are only served to admins, or on their own listener.
+	health.Register(app, (*structs.Server)(s))
+	if *token != "" {
+		admin.Register(app, (*structs.Server)(s), *token)
+		if *metricsListen == "" {
+			app.Get("/metrics", admin.Authorize(*token), metrics.Handler())
+		}
+	} else {
+		logging.Signaling().Info("Admin API disabled, set -admin-token or PHI_ADMIN_TOKEN to enable it")
+	}
+	var exporter *http.Server
+	if *metricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metrics.HTTPHandler())
+		exporter = &http.Server{Addr: *metricsListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := exporter.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logging.Signaling().Error("Metrics listener stopped", "address", *metricsListen, "error", err)
+			}
+		}()
+	} else if *token == "" {
+		logging.Signaling().Info("Metrics disabled, set -metrics-listen or the admin token to enable them")
+	}
 	app.Use("/", s.Upgrader)
 	app.Get("/", websocket.New(s.Handler))
 
-	// Initialize middleware
-	app.Use(logger.New())
-	app.Use(recover.New())
+	// Listen for connections, over TLS if it is configured
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		logging.Signaling().Error("Server failed to listen", "address", *listen, "error", err)
+		os.Exit(1)
+	}
+	var redirector *http.Server
+	if tlsconfig != nil {
+		listener = tls.NewListener(listener, tlsconfig)
+		go loader.Watch(s.Stopped)
+		logging.Signaling().Info("TLS enabled", "address", *listen, "cert", *certfile, "min_version", *tlsVersion)
+
+		// Redirect plain HTTP requests to HTTPS
+		if *redirect != "" {
+			redirector = &http.Server{Addr: *redirect, Handler: certificate.Redirect(*listen), ReadHeaderTimeout: 10 * time.Second}
+			go func() {
+				if err := redirector.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logging.Signaling().Error("HTTP redirect listener stopped", "address", *redirect, "error", err)
+				}
+			}()
+		}
+	} else if *redirect != "" {
+		logging.Signaling().Warn("HTTP redirect listener disabled, since TLS isn't enabled")
+	}
+
+	// Drain the server and exit on SIGTERM or interrupt, leaving time to close the remaining connections
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		received := <-signals
+		timeout := s.Settings.Load().DrainTimeout
+		logging.Signaling().Warn("Shutting down", "signal", received.String(), "timeout", timeout)
+		exit := time.Now().Add(timeout)
+		if redirector != nil {
+			redirector.Close()
+		}
+		if exporter != nil {
+			exporter.Close()
+		}
+		session.Drain((*structs.Server)(s), "shutdown", exit.Add(-session.HandlerGrace-time.Second))
+		close(s.Stopped)
+		if err := app.ShutdownWithTimeout(time.Until(exit)); err != nil {
+			logging.Signaling().Warn("Server shutdown error", "error", err)
+		}
+	}()
 
 	// Start server
-	app.Listen(":3000") // Listen on port 3000 by default. You can change this if needed.
+	if err := app.Listener(listener); err != nil {
+		logging.Signaling().Error("Server stopped", "error", err)
+		os.Exit(1)
+	}
+	logging.Signaling().Info("Server stopped")
 }
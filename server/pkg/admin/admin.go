@@ -0,0 +1,304 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/origin"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/session"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Register mounts the admin API under /admin. Every request must carry the given token as a
+// bearer token in its Authorization header. It must be registered before the websocket upgrader.
+func Register(router fiber.Router, s *structs.Server, token string) {
+	api := router.Group("/admin", Authorize(token))
+
+	api.Get("/games", func(c *fiber.Ctx) error {
+		return c.JSON(games(s))
+	})
+
+	api.Get("/lobbies", func(c *fiber.Ctx) error {
+		ugis := []string{c.Query("ugi")}
+		if ugis[0] == "" {
+			ugis = ugis[:0]
+			for ugi := range manager.GetGameStats(s) {
+				ugis = append(ugis, ugi)
+			}
+			slices.Sort(ugis)
+		}
+		lobbies := []*structs.AdminLobby{}
+		for _, ugi := range ugis {
+			ids := manager.GetAllLobbies(s, ugi)
+			slices.Sort(ids)
+			for _, id := range ids {
+				if lobby := describe_lobby(s, ugi, id); lobby != nil {
+					lobbies = append(lobbies, lobby)
+				}
+			}
+		}
+		return c.JSON(lobbies)
+	})
+
+	api.Get("/lobbies/:lobby", func(c *fiber.Ctx) error {
+		lobby := describe_lobby(s, c.Query("ugi"), c.Params("lobby"))
+		if lobby == nil {
+			return fail(c, fiber.StatusNotFound, "lobby not found")
+		}
+		return c.JSON(lobby)
+	})
+
+	api.Get("/lobbies/:lobby/relays", func(c *fiber.Ctx) error {
+		if !lobby_exists(s, c.Query("ugi"), c.Params("lobby")) {
+			return fail(c, fiber.StatusNotFound, "lobby not found")
+		}
+		return c.JSON(peer.InspectLobby(s, c.Params("lobby"), c.Query("ugi")))
+	})
+
+	api.Post("/lobbies/:lobby/lock", func(c *fiber.Ctx) error {
+		return set_locked(c, s, true)
+	})
+
+	api.Post("/lobbies/:lobby/unlock", func(c *fiber.Ctx) error {
+		return set_locked(c, s, false)
+	})
+
+	api.Delete("/lobbies/:lobby", func(c *fiber.Ctx) error {
+		ugi, lobby := c.Query("ugi"), c.Params("lobby")
+		if !lobby_exists(s, ugi, lobby) {
+			return fail(c, fiber.StatusNotFound, "lobby not found")
+		}
+		session.CloseLobby(s, ugi, lobby)
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	api.Get("/sessions", func(c *fiber.Ctx) error {
+		sessions := []*structs.AdminSession{}
+		for _, client := range manager.GetAllSessions(s) {
+			if ugi := c.Query("ugi"); ugi != "" && client.UGI != ugi {
+				continue
+			}
+			sessions = append(sessions, describe_session(client))
+		}
+		slices.SortFunc(sessions, func(a, b *structs.AdminSession) int {
+			return strings.Compare(a.ID, b.ID)
+		})
+		return c.JSON(sessions)
+	})
+
+	api.Delete("/sessions/:id", func(c *fiber.Ctx) error {
+		client := manager.GetByULID(s, c.Params("id"))
+		if client == nil {
+			return fail(c, fiber.StatusNotFound, "session not found")
+		}
+		reason := c.Query("reason", "Kicked by an administrator")
+		session.Kick(s, client, reason)
+		return c.SendStatus(fiber.StatusAccepted)
+	})
+
+	api.Post("/announce", func(c *fiber.Ctx) error {
+		announcement := &structs.Announcement{}
+		if err := c.BodyParser(announcement); err != nil {
+			return fail(c, fiber.StatusBadRequest, err.Error())
+		}
+		if err := s.PacketValidator.Struct(announcement); err != nil {
+			return fail(c, fiber.StatusBadRequest, err.Error())
+		}
+
+		// Announce to a single lobby, or to every session on the server
+		var recipients []*structs.Client
+		if announcement.Lobby != "" {
+			if !lobby_exists(s, announcement.UGI, announcement.Lobby) {
+				return fail(c, fiber.StatusNotFound, "lobby not found")
+			}
+			recipients = slices.Clone(manager.GetLobbyPeers(s, announcement.Lobby, announcement.UGI))
+		} else {
+			recipients = manager.GetAllSessions(s)
+		}
+		message.Broadcast(
+			recipients,
+			&structs.SignalPacket{
+				Opcode:  "ANNOUNCEMENT",
+				Payload: announcement,
+			},
+		)
+		logging.Signaling().Info("Sent announcement", "ugi", announcement.UGI, "lobby", announcement.Lobby, "recipients", len(recipients))
+		return c.JSON(fiber.Map{"recipients": len(recipients)})
+	})
+
+	api.Get("/origins", func(c *fiber.Ctx) error {
+		return c.JSON(s.Origins.Load().Config)
+	})
+
+	api.Put("/origins", func(c *fiber.Ctx) error {
+		config := origin.Config{}
+		if err := c.BodyParser(&config); err != nil {
+			return fail(c, fiber.StatusBadRequest, err.Error())
+		}
+		policy, err := origin.Compile(config)
+		if err != nil {
+			return fail(c, fiber.StatusBadRequest, err.Error())
+		}
+		s.OriginBase.Store(policy)
+		s.Origins.Store(policy)
+		logging.Signaling().Info("Replaced origin policy")
+		return c.JSON(policy.Config)
+	})
+
+	api.Post("/origins/reload", func(c *fiber.Ctx) error {
+		if s.OriginPolicyFile == "" {
+			return fail(c, fiber.StatusConflict, "the server has no origin policy file")
+		}
+		policy, err := origin.Load(s.OriginPolicyFile)
+		if err != nil {
+			logging.Signaling().Warn("Origin policy reload rejected", "file", s.OriginPolicyFile, "error", err)
+			return fail(c, fiber.StatusUnprocessableEntity, err.Error())
+		}
+		s.OriginBase.Store(policy)
+		s.Origins.Store(policy)
+		logging.Signaling().Info("Reloaded origin policy", "file", s.OriginPolicyFile)
+		return c.JSON(policy.Config)
+	})
+
+	api.Post("/config/reload", func(c *fiber.Ctx) error {
+		if s.Reload == nil {
+			return fail(c, fiber.StatusConflict, "the server has no configuration file")
+		}
+		if err := s.Reload(); err != nil {
+			return fail(c, fiber.StatusUnprocessableEntity, err.Error())
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	api.Get("/drain", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"draining": s.Draining.Load()})
+	})
+
+	api.Post("/drain", func(c *fiber.Ctx) error {
+		timeout := s.Settings.Load().DrainTimeout
+		if seconds := c.QueryInt("timeout", -1); seconds >= 0 {
+			timeout = time.Duration(seconds) * time.Second
+		}
+		deadline := time.Now().Add(timeout)
+		if !session.StartDrain(s, "drain", deadline) {
+			return fail(c, fiber.StatusConflict, "the server is already draining")
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"draining": true, "deadline": deadline})
+	})
+
+	api.Delete("/drain", func(c *fiber.Ctx) error {
+		if session.CancelDrain(s) {
+			logging.Signaling().Info("Server stopped draining, new sessions and lobbies are accepted")
+		}
+		return c.JSON(fiber.Map{"draining": false})
+	})
+}
+
+// Authorize rejects requests that don't carry the admin token as a bearer token.
+func Authorize(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		given, found := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return fail(c, fiber.StatusUnauthorized, "invalid admin token")
+		}
+		return c.Next()
+	}
+}
+
+// fail replies with an error status and a JSON error message.
+func fail(c *fiber.Ctx, status int, reason string) error {
+	return c.Status(status).JSON(fiber.Map{"error": reason})
+}
+
+// games describes every game on the server.
+func games(s *structs.Server) []*structs.AdminGame {
+	games := []*structs.AdminGame{}
+	for ugi, stats := range manager.GetGameStats(s) {
+		games = append(games, &structs.AdminGame{
+			UGI:      ugi,
+			Sessions: stats.Sessions,
+			Lobbies:  stats.Lobbies,
+			Members:  stats.Members,
+		})
+	}
+	slices.SortFunc(games, func(a, b *structs.AdminGame) int {
+		return strings.Compare(a.UGI, b.UGI)
+	})
+	return games
+}
+
+// lobby_exists checks that a lobby exists, without creating it, and that it isn't the default lobby.
+func lobby_exists(s *structs.Server, ugi string, lobby string) bool {
+	return lobby != "default" && manager.DoesLobbyExist(s, lobby, ugi)
+}
+
+// describe_lobby describes a lobby, or returns nil if it doesn't exist. The password is hidden.
+func describe_lobby(s *structs.Server, ugi string, id string) *structs.AdminLobby {
+	if !lobby_exists(s, ugi, id) {
+		return nil
+	}
+	lobby := &structs.AdminLobby{
+		ID:      id,
+		UGI:     ugi,
+		Members: []*structs.PeerInfo{},
+	}
+	if settings := manager.GetLobbySettings(s, id, ugi); settings != nil {
+		copied := *settings
+		copied.Password = ""
+		lobby.Settings = &copied
+	}
+	if host, err := manager.GetLobbyHost(s, id, ugi); err == nil && host != nil {
+		lobby.Host = &structs.PeerInfo{ID: host.ID, User: host.Username}
+	}
+	for _, member := range manager.GetLobbyPeers(s, id, ugi) {
+		lobby.Members = append(lobby.Members, &structs.PeerInfo{ID: member.ID, User: member.Username})
+	}
+	return lobby
+}
+
+// describe_session describes a connected client.
+func describe_session(client *structs.Client) *structs.AdminSession {
+	described := &structs.AdminSession{
+		ID:      client.ID,
+		User:    client.Username,
+		Session: client.Session,
+		UGI:     client.UGI,
+		Mode:    "none",
+	}
+	if client.AmIInALobby() {
+		described.Lobby = client.Lobby
+	}
+	if client.AmIAHost() {
+		described.Mode = "host"
+	} else if client.AmIPeer() {
+		described.Mode = "peer"
+	}
+	if client.Address != "" {
+		described.Address = client.Address
+	} else if client.Conn != nil {
+		described.Address = client.Conn.RemoteAddr().String()
+	}
+	return described
+}
+
+// set_locked locks or unlocks a lobby, which decides whether peers can join it.
+func set_locked(c *fiber.Ctx, s *structs.Server, locked bool) error {
+	ugi, id := c.Query("ugi"), c.Params("lobby")
+	settings := manager.GetLobbySettings(s, id, ugi)
+	if !lobby_exists(s, ugi, id) || settings == nil {
+		return fail(c, fiber.StatusNotFound, "lobby not found")
+	}
+	copied := *settings
+	copied.Locked = locked
+	manager.SetLobbySettings(s, id, ugi, &copied)
+	logging.Signaling().Info("Changed lobby lock", "ugi", ugi, "lobby", id, "locked", locked)
+	return c.JSON(describe_lobby(s, ugi, id))
+}
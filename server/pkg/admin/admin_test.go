@@ -0,0 +1,280 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/origin"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	testToken = "secret"
+	testGame  = "test-game"
+	testLobby = "test-lobby"
+)
+
+// newApp returns a server and an app that serves the admin API in front of the websocket
+// upgrader, the way the server's main function does.
+func newApp(t *testing.T) (*structs.Server, *fiber.App) {
+	t.Helper()
+	s := &structs.Server{
+		Mux:             &sync.RWMutex{},
+		Games:           &structs.GameStore{Games: make(map[string]*structs.Game)},
+		Sessions:        &structs.SessionStore{Sessions: make(map[string]*structs.Session)},
+		Relays:          make(map[*structs.Client]*structs.Relay),
+		RelayLock:       &sync.RWMutex{},
+		PacketValidator: validator.New(validator.WithRequiredStructEnabled()),
+		Diagnostics:     make(map[*structs.Client]*structs.Diagnostic),
+		DiagnosticsLock: &sync.RWMutex{},
+		Addresses:       &structs.AddressStore{Addresses: make(map[string]*structs.AddressState)},
+		Stopped:         make(chan struct{}),
+	}
+	s.Settings.Store(signaling.DefaultSettings(false))
+	policy, err := origin.Compile(origin.Config{Origins: []string{"*"}, AllowMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Origins.Store(policy)
+
+	app := fiber.New()
+	Register(app, s, testToken)
+	app.Use("/", (*signaling.Server)(s).Upgrader)
+	app.Get("/", websocket.New((*signaling.Server)(s).Handler))
+	t.Cleanup(func() { app.Shutdown() })
+	return s, app
+}
+
+// request makes a request to the app with the admin token, and returns the status and body.
+func request(t *testing.T, app *fiber.App, method string, target string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+testToken)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// host opens the test lobby with a host that has no websocket connection.
+func host(t *testing.T, s *structs.Server, settings *structs.LobbySettings) *structs.Client {
+	t.Helper()
+	client := &structs.Client{ID: "host", Username: "host", UGI: testGame, Mux: &sync.RWMutex{}}
+	if err := manager.CreateSession(s, client); err != nil {
+		t.Fatal(err)
+	}
+	client.SetHostMode()
+	client.SetLobby(testLobby)
+	manager.AddClientToLobby(s, testLobby, testGame, client)
+	manager.SetLobbyHost(s, testLobby, testGame, client)
+	manager.SetLobbySettings(s, testLobby, testGame, settings)
+	return client
+}
+
+func TestAuthorization(t *testing.T) {
+	_, app := newApp(t)
+	for _, header := range []string{"", "Bearer wrong", testToken, "Basic " + testToken} {
+		req := httptest.NewRequest(fiber.MethodGet, "/admin/games", nil)
+		if header != "" {
+			req.Header.Set(fiber.HeaderAuthorization, header)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Fatalf("Authorization %q got %d, want %d", header, resp.StatusCode, fiber.StatusUnauthorized)
+		}
+		if resp.Header.Get(fiber.HeaderWWWAuthenticate) != "Bearer" {
+			t.Fatalf("Authorization %q didn't get a WWW-Authenticate challenge", header)
+		}
+	}
+
+	if status, _ := request(t, app, fiber.MethodGet, "/admin/games"); status != fiber.StatusOK {
+		t.Fatalf("the admin token got %d, want %d", status, fiber.StatusOK)
+	}
+}
+
+func TestLobbyPasswordsAreHidden(t *testing.T) {
+	s, app := newApp(t)
+	host(t, s, &structs.LobbySettings{LobbyID: testLobby, Password: "hunter2", MaximumPeers: 4})
+
+	for _, target := range []string{"/admin/lobbies", "/admin/lobbies?ugi=" + testGame, "/admin/lobbies/" + testLobby + "?ugi=" + testGame} {
+		status, body := request(t, app, fiber.MethodGet, target)
+		if status != fiber.StatusOK {
+			t.Fatalf("%s got %d, want %d", target, status, fiber.StatusOK)
+		}
+		if strings.Contains(body, "hunter2") {
+			t.Fatalf("%s shows the lobby password: %s", target, body)
+		}
+		if !strings.Contains(body, `"max_peers":4`) {
+			t.Fatalf("%s doesn't show the lobby settings: %s", target, body)
+		}
+	}
+	if settings := manager.GetLobbySettings(s, testLobby, testGame); settings.Password != "hunter2" {
+		t.Fatal("describing the lobby changed its password")
+	}
+}
+
+func TestLockLobby(t *testing.T) {
+	s, app := newApp(t)
+	host(t, s, &structs.LobbySettings{LobbyID: testLobby})
+
+	status, body := request(t, app, fiber.MethodPost, "/admin/lobbies/"+testLobby+"/lock?ugi="+testGame)
+	if status != fiber.StatusOK {
+		t.Fatalf("locking got %d, want %d", status, fiber.StatusOK)
+	}
+	lobby := &structs.AdminLobby{}
+	if err := json.Unmarshal([]byte(body), lobby); err != nil {
+		t.Fatal(err)
+	}
+	if !lobby.Settings.Locked || !manager.GetLobbySettings(s, testLobby, testGame).Locked {
+		t.Fatal("the lobby wasn't locked")
+	}
+
+	if status, _ := request(t, app, fiber.MethodPost, "/admin/lobbies/"+testLobby+"/unlock?ugi="+testGame); status != fiber.StatusOK {
+		t.Fatalf("unlocking got %d, want %d", status, fiber.StatusOK)
+	}
+	if manager.GetLobbySettings(s, testLobby, testGame).Locked {
+		t.Fatal("the lobby wasn't unlocked")
+	}
+
+	if status, _ := request(t, app, fiber.MethodPost, "/admin/lobbies/nowhere/lock?ugi="+testGame); status != fiber.StatusNotFound {
+		t.Fatalf("locking a lobby that doesn't exist got %d, want %d", status, fiber.StatusNotFound)
+	}
+	if manager.DoesLobbyExist(s, "nowhere", testGame) {
+		t.Fatal("locking a lobby that doesn't exist created it")
+	}
+}
+
+func TestCloseLobby(t *testing.T) {
+	s, app := newApp(t)
+	client := host(t, s, &structs.LobbySettings{LobbyID: testLobby})
+
+	if status, _ := request(t, app, fiber.MethodDelete, "/admin/lobbies/"+testLobby+"?ugi="+testGame); status != fiber.StatusNoContent {
+		t.Fatalf("closing got %d, want %d", status, fiber.StatusNoContent)
+	}
+	if manager.DoesLobbyExist(s, testLobby, testGame) {
+		t.Fatal("the lobby still exists after it was closed")
+	}
+	if client.AmIInALobby() || client.AmIAHost() {
+		t.Fatal("the host is still in the lobby after it was closed")
+	}
+	if manager.GetByULID(s, client.ID) == nil {
+		t.Fatal("closing the lobby ended the host's session")
+	}
+
+	if status, _ := request(t, app, fiber.MethodDelete, "/admin/lobbies/"+testLobby+"?ugi="+testGame); status != fiber.StatusNotFound {
+		t.Fatalf("closing a lobby that doesn't exist got %d, want %d", status, fiber.StatusNotFound)
+	}
+	if status, _ := request(t, app, fiber.MethodDelete, "/admin/lobbies/default?ugi="+testGame); status != fiber.StatusNotFound {
+		t.Fatalf("closing the default lobby got %d, want %d", status, fiber.StatusNotFound)
+	}
+}
+
+// connect opens a websocket connection to the app, and returns it along with its session.
+func connect(t *testing.T, s *structs.Server, app *fiber.App) (*fastws.Conn, *structs.Client) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+
+	conn, _, err := fastws.DefaultDialer.Dial("ws://"+listener.Addr().String()+"/?ugi="+testGame, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if sessions := manager.GetAllSessions(s); len(sessions) == 1 {
+			return conn, sessions[0]
+		} else if time.Now().After(deadline) {
+			t.Fatal("the websocket connection didn't open a session")
+		}
+	}
+}
+
+// receive reads the next packet sent to a websocket connection.
+func receive(t *testing.T, conn *fastws.Conn) *structs.SignalPacket {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	packet := &structs.SignalPacket{}
+	if err := conn.ReadJSON(packet); err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func TestKickSession(t *testing.T) {
+	s, app := newApp(t)
+	conn, client := connect(t, s, app)
+
+	if status, _ := request(t, app, fiber.MethodDelete, "/admin/sessions/"+client.ID+"?reason=testing"); status != fiber.StatusAccepted {
+		t.Fatalf("kicking got %d, want %d", status, fiber.StatusAccepted)
+	}
+	if packet := receive(t, conn); packet.Opcode != "KICKED" || packet.Payload != "testing" {
+		t.Fatalf("the session was sent %s %v, want KICKED testing", packet.Opcode, packet.Payload)
+	}
+	if _, _, err := conn.ReadMessage(); !fastws.IsCloseError(err, fastws.ClosePolicyViolation) {
+		t.Fatalf("the connection wasn't closed with a policy violation: %v", err)
+	}
+
+	if status, _ := request(t, app, fiber.MethodDelete, "/admin/sessions/nobody"); status != fiber.StatusNotFound {
+		t.Fatalf("kicking a session that doesn't exist got %d, want %d", status, fiber.StatusNotFound)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s, app := newApp(t)
+	conn, client := connect(t, s, app)
+
+	if status, _ := request(t, app, fiber.MethodPost, "/admin/drain?timeout=1"); status != fiber.StatusAccepted {
+		t.Fatalf("draining got %d, want %d", status, fiber.StatusAccepted)
+	}
+	if status, _ := request(t, app, fiber.MethodPost, "/admin/drain?timeout=1"); status != fiber.StatusConflict {
+		t.Fatalf("draining a draining server got %d, want %d", status, fiber.StatusConflict)
+	}
+	if packet := receive(t, conn); packet.Opcode != "SERVER_SHUTDOWN" {
+		t.Fatalf("the session was sent %s, want SERVER_SHUTDOWN", packet.Opcode)
+	}
+
+	// A cancelled drain leaves the session connected past its deadline
+	if status, _ := request(t, app, fiber.MethodDelete, "/admin/drain"); status != fiber.StatusOK {
+		t.Fatalf("cancelling got %d, want %d", status, fiber.StatusOK)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if s.Draining.Load() || manager.GetByULID(s, client.ID) == nil {
+		t.Fatal("the session was kicked after the drain was cancelled")
+	}
+
+	if status, _ := request(t, app, fiber.MethodPost, "/admin/drain?timeout=0"); status != fiber.StatusAccepted {
+		t.Fatalf("draining again got %d, want %d", status, fiber.StatusAccepted)
+	}
+	if packet := receive(t, conn); packet.Opcode != "SERVER_SHUTDOWN" {
+		t.Fatalf("the session was sent %s, want SERVER_SHUTDOWN", packet.Opcode)
+	}
+	if packet := receive(t, conn); packet.Opcode != "KICKED" {
+		t.Fatalf("the session was sent %s, want KICKED", packet.Opcode)
+	}
+}
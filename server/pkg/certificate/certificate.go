@@ -0,0 +1,149 @@
+// Package certificate serves TLS certificates that are reloaded when their files change on disk.
+package certificate
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+)
+
+// CheckInterval is how often a Loader checks whether its files have changed.
+const CheckInterval = 10 * time.Second
+
+// Loader holds a certificate and its private key, loaded from a pair of PEM files.
+type Loader struct {
+	CertFile string
+	KeyFile  string
+	current  atomic.Pointer[tls.Certificate]
+	modified time.Time // latest modification time of the files when they were last loaded
+}
+
+// Load loads a certificate and its private key from a pair of PEM files.
+func Load(certfile string, keyfile string) (*Loader, error) {
+	l := &Loader{CertFile: certfile, KeyFile: keyfile}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload loads the certificate files again. If they can't be loaded, the current certificate
+// stays in use and an error is returned.
+func (l *Loader) Reload() error {
+	modified, err := l.lastModified()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+	if err != nil {
+		return err
+	}
+	l.current.Store(&certificate)
+	l.modified = modified
+	return nil
+}
+
+// Watch reloads the certificate files whenever they change, checking every CheckInterval. It
+// runs until the stop channel is closed. Failed reloads are logged, and retried once the files
+// change again.
+func (l *Loader) Watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		l.check()
+	}
+}
+
+// check reloads the certificate files if they changed since they were last loaded.
+func (l *Loader) check() {
+	modified, err := l.lastModified()
+	if err != nil || !modified.After(l.modified) {
+		return
+	}
+	if err := l.Reload(); err != nil {
+		logging.Signaling().Error("TLS certificate reload failed, the current certificate stays in use", "cert", l.CertFile, "key", l.KeyFile, "error", err)
+		l.modified = modified
+		return
+	}
+	logging.Signaling().Info("TLS certificate reloaded", "cert", l.CertFile, "key", l.KeyFile)
+}
+
+// GetCertificate implements the GetCertificate callback of tls.Config.
+func (l *Loader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return l.current.Load(), nil
+}
+
+// lastModified returns the latest modification time of the certificate files.
+func (l *Loader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{l.CertFile, l.KeyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Config returns a TLS configuration that serves the loader's certificate. The minimum version
+// is "1.2" or "1.3", and ciphers are comma separated names of cipher suites from crypto/tls,
+// which only apply to TLS 1.2. The default cipher suites are used if ciphers is empty.
+func Config(l *Loader, minimum string, ciphers string) (*tls.Config, error) {
+	config := &tls.Config{GetCertificate: l.GetCertificate}
+	switch minimum {
+	case "1.2", "":
+		config.MinVersion = tls.VersionTLS12
+	case "1.3":
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported minimum TLS version %q, expected 1.2 or 1.3", minimum)
+	}
+	if strings.TrimSpace(ciphers) == "" {
+		return config, nil
+	}
+	suites := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	for _, name := range strings.Split(ciphers, ",") {
+		id, exists := suites[strings.TrimSpace(name)]
+		if !exists {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", strings.TrimSpace(name))
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	return config, nil
+}
+
+// Redirect returns an HTTP handler that permanently redirects every request to HTTPS on the
+// port of the given TLS listen address.
+func Redirect(address string) http.Handler {
+	_, port, _ := net.SplitHostPort(address)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 addresses keep their brackets without a port
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
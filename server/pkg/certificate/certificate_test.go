@@ -0,0 +1,203 @@
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// generate writes a new self-signed certificate for the given common name, and its private key,
+// to PEM files in dir. The files' modification time is set to the given time.
+func generate(t *testing.T, dir string, name string, modified time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certfile, keyfile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	write(t, certfile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modified)
+	write(t, keyfile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyder}), modified)
+	return certfile, keyfile
+}
+
+// write writes a file and sets its modification time.
+func write(t *testing.T, file string, data []byte, modified time.Time) {
+	t.Helper()
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// served returns the common name of the certificate that the loader serves.
+func served(t *testing.T, l *Loader) string {
+	t.Helper()
+	certificate, err := l.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	certfile, keyfile := generate(t, dir, "first.example.com", time.Now())
+	l, err := Load(certfile, keyfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := served(t, l); name != "first.example.com" {
+		t.Fatalf("the loader serves %s, want first.example.com", name)
+	}
+
+	if _, err := Load(certfile, filepath.Join(dir, "missing.pem")); err == nil {
+		t.Fatal("loading a missing key succeeded")
+	}
+	if _, err := Load(keyfile, keyfile); err == nil {
+		t.Fatal("loading a key as a certificate succeeded")
+	}
+}
+
+func TestReloadWhenModified(t *testing.T) {
+	dir := t.TempDir()
+	loaded := time.Now().Add(-time.Hour)
+	l, err := Load(generate(t, dir, "first.example.com", loaded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files that are replaced without a newer modification time aren't reloaded
+	generate(t, dir, "second.example.com", loaded)
+	l.check()
+	if name := served(t, l); name != "first.example.com" {
+		t.Fatalf("the loader serves %s before the files were modified, want first.example.com", name)
+	}
+
+	generate(t, dir, "third.example.com", loaded.Add(time.Minute))
+	l.check()
+	if name := served(t, l); name != "third.example.com" {
+		t.Fatalf("the loader serves %s after the files were modified, want third.example.com", name)
+	}
+}
+
+func TestFailedReloadKeepsCertificate(t *testing.T) {
+	dir := t.TempDir()
+	loaded := time.Now().Add(-time.Hour)
+	certfile, keyfile := generate(t, dir, "first.example.com", loaded)
+	l, err := Load(certfile, keyfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(t, certfile, []byte("not a certificate"), loaded.Add(time.Minute))
+	l.check()
+	if name := served(t, l); name != "first.example.com" {
+		t.Fatalf("the loader serves %s after a failed reload, want first.example.com", name)
+	}
+	if err := l.Reload(); err == nil {
+		t.Fatal("reloading an invalid certificate succeeded")
+	}
+	if name := served(t, l); name != "first.example.com" {
+		t.Fatalf("the loader serves %s after a failed reload, want first.example.com", name)
+	}
+
+	// The broken files aren't retried until they change again
+	generate(t, dir, "second.example.com", loaded.Add(2*time.Minute))
+	l.check()
+	if name := served(t, l); name != "second.example.com" {
+		t.Fatalf("the loader serves %s after the files were fixed, want second.example.com", name)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	l, err := Load(generate(t, t.TempDir(), "first.example.com", time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for minimum, want := range map[string]uint16{"": tls.VersionTLS12, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13} {
+		config, err := Config(l, minimum, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.MinVersion != want || config.CipherSuites != nil {
+			t.Fatalf("minimum version %q gave version %x and cipher suites %v, want %x and the defaults", minimum, config.MinVersion, config.CipherSuites, want)
+		}
+	}
+	if _, err := Config(l, "1.1", ""); err == nil {
+		t.Fatal("a minimum version of 1.1 was accepted")
+	}
+
+	config, err := Config(l, "1.2", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+	if len(config.CipherSuites) != len(want) || config.CipherSuites[0] != want[0] || config.CipherSuites[1] != want[1] {
+		t.Fatalf("the cipher suites are %v, want %v", config.CipherSuites, want)
+	}
+	for _, ciphers := range []string{"TLS_NOT_A_CIPHER", "TLS_RSA_WITH_RC4_128_SHA"} {
+		if _, err := Config(l, "1.2", ciphers); err == nil {
+			t.Fatalf("the cipher suite %s was accepted", ciphers)
+		}
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	for _, test := range []struct {
+		listen string
+		host   string
+		want   string
+	}{
+		{":443", "example.com", "https://example.com/path?query=1"},
+		{":443", "example.com:80", "https://example.com/path?query=1"},
+		{":8443", "example.com:80", "https://example.com:8443/path?query=1"},
+		{":443", "[::1]:80", "https://[::1]/path?query=1"},
+		{":443", "[::1]", "https://[::1]/path?query=1"},
+		{":8443", "[::1]:80", "https://[::1]:8443/path?query=1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/path?query=1", nil)
+		req.Host = test.host
+		recorder := httptest.NewRecorder()
+		Redirect(test.listen).ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusPermanentRedirect {
+			t.Fatalf("%s on %s got %d, want %d", test.host, test.listen, recorder.Code, http.StatusPermanentRedirect)
+		}
+		if location := recorder.Header().Get("Location"); location != test.want {
+			t.Fatalf("%s on %s redirected to %s, want %s", test.host, test.listen, location, test.want)
+		}
+	}
+}
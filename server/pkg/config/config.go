@@ -0,0 +1,340 @@
+// Package config loads the server's configuration file, which can be reloaded while the server runs.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/address"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/origin"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/goccy/go-json"
+	"github.com/pion/stun/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// File is the server's configuration file. Settings that are left out keep the values given
+// on the command line.
+type File struct {
+	TURNOnly             *bool                          `json:"turn_only"`
+	ICEServers           []webrtc.ICEServer             `json:"ice_servers"`
+	MaxSessions          *int                           `json:"max_sessions"`
+	MaxSessionsPerIP     *int                           `json:"max_sessions_per_ip"`
+	ConnectionRate       *float64                       `json:"connection_rate"`
+	ConnectionBurst      *int                           `json:"connection_burst"`
+	AllowIPs             []string                       `json:"allow_ips"`
+	DenyIPs              []string                       `json:"deny_ips"`
+	TrustedProxies       []string                       `json:"trusted_proxies"`
+	OpcodeLimits         map[string]structs.OpcodeLimit `json:"opcode_limits"` // replace the limits of the listed opcodes
+	MaxFrameSize         *int64                         `json:"max_frame_size"`
+	MaxRateViolations    *int                           `json:"max_rate_violations"`
+	ShutdownTimeout      string                         `json:"shutdown_timeout"` // such as "30s"
+	ReconnectURL         *string                        `json:"reconnect_url"`
+	RecordingsDir        *string                        `json:"recordings_dir"`
+	RecordingMaxSize     *int64                         `json:"recording_max_size"`
+	RecordingMaxDuration string                         `json:"recording_max_duration"` // such as "6h"
+	STUNHost             *string                        `json:"stun_host"`
+	STUNPort             *int                           `json:"stun_port"`
+	STUNAltPort          *int                           `json:"stun_alt_port"`
+	Origins              *Origins                       `json:"origins"`    // applied on top of the server's base origin policy
+	LogLevels            map[string]string              `json:"log_levels"` // keyed by subsystem
+}
+
+// Origins is the origin policy of a configuration file. Settings that are left out keep the
+// values of the server's base origin policy.
+type Origins struct {
+	Origins      []string            `json:"origins"`
+	Games        map[string][]string `json:"games"`
+	AllowMissing *bool               `json:"allow_missing"`
+	CacheSize    *int                `json:"cache_size"`
+}
+
+// Loader loads a configuration file into a server, on top of the settings given on the command line.
+type Loader struct {
+	Path   string
+	Base   *structs.Settings     // settings given on the command line, which must never be changed
+	Levels map[string]slog.Level // log level of each subsystem given on the command line
+	mux    sync.Mutex            // one load at a time
+	loaded bool                  // the file has been loaded once, so the STUN responder has started
+}
+
+// Load reads the configuration file and applies it to the server. The whole file is validated
+// before anything is applied, so if it is invalid, an error is returned and the server keeps
+// its current configuration. Every setting that changed is logged.
+//
+// New settings apply to new sessions, lobbies and relays. Existing ones keep running with the
+// settings they started with. Settings that are left out of the file return to the values
+// given on the command line. The file's origins apply on top of the server's base origin
+// policy, which is the one given on the command line unless the admin API replaced or reloaded
+// it, so reloads don't undo changes made through the admin API.
+func (l *Loader) Load(s *structs.Server) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	// Read and validate the file
+	data, err := os.ReadFile(l.Path)
+	if err != nil {
+		return err
+	}
+	file := &File{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(file); err != nil {
+		return fmt.Errorf("%s: %w", l.Path, err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("%s: unexpected data after the configuration", l.Path)
+	}
+	settings, err := apply(l.Base, file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", l.Path, err)
+	}
+	if current := s.Settings.Load(); l.loaded && (settings.STUNHost != current.STUNHost || settings.STUNPort != current.STUNPort || settings.STUNAltPort != current.STUNAltPort) {
+		return fmt.Errorf("%s: stun_host, stun_port and stun_alt_port can't be changed without a restart", l.Path)
+	}
+	policy := s.OriginBase.Load()
+	if file.Origins != nil {
+		if policy, err = origin.Compile(file.Origins.apply(policy.Config)); err != nil {
+			return fmt.Errorf("%s: origins: %w", l.Path, err)
+		}
+	}
+	levels := maps.Clone(l.Levels)
+	for subsystem, name := range file.LogLevels {
+		if !slices.Contains(logging.Subsystems, subsystem) {
+			return fmt.Errorf("%s: log_levels: unknown subsystem %q", l.Path, subsystem)
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return fmt.Errorf("%s: log_levels: %w", l.Path, err)
+		}
+		levels[subsystem] = level
+	}
+
+	// Apply the new configuration
+	before := describe(s.Settings.Load(), s.Origins.Load())
+	s.Settings.Store(settings)
+	s.Origins.Store(policy)
+	for subsystem, level := range levels {
+		logging.SetLevel(subsystem, level)
+	}
+	after := describe(settings, policy)
+
+	// Log what changed
+	changed := 0
+	for _, name := range slices.Sorted(maps.Keys(after)) {
+		if before[name] != after[name] {
+			logging.Signaling().Info("Configuration changed", "setting", name, "from", before[name], "to", after[name])
+			changed++
+		}
+	}
+	logging.Signaling().Info("Configuration loaded", "file", l.Path, "changes", changed)
+	l.loaded = true
+	return nil
+}
+
+// apply returns a copy of the base settings, with the settings of the file applied to it.
+func apply(base *structs.Settings, file *File) (*structs.Settings, error) {
+	limits := *base.Limits
+	packets := *base.PacketLimits
+	packets.Opcodes = maps.Clone(base.PacketLimits.Opcodes)
+	settings := *base
+	settings.Limits = &limits
+	settings.PacketLimits = &packets
+
+	if file.TURNOnly != nil {
+		settings.TURNOnly = *file.TURNOnly
+	}
+	if file.ICEServers != nil {
+		for _, server := range file.ICEServers {
+			for _, url := range server.URLs {
+				if _, err := stun.ParseURI(url); err != nil {
+					return nil, fmt.Errorf("ice_servers: %q: %w", url, err)
+				}
+			}
+		}
+		settings.ICEServers = file.ICEServers
+	}
+	if file.MaxSessions != nil {
+		settings.MaxSessions = *file.MaxSessions
+	}
+	if file.MaxSessionsPerIP != nil {
+		limits.MaxSessionsPerIP = *file.MaxSessionsPerIP
+	}
+	if file.ConnectionRate != nil {
+		limits.Rate = *file.ConnectionRate
+	}
+	if file.ConnectionBurst != nil {
+		limits.Burst = *file.ConnectionBurst
+	}
+	for _, networks := range []struct {
+		name    string
+		entries []string
+		target  *[]*net.IPNet
+	}{
+		{"allow_ips", file.AllowIPs, &limits.Allow},
+		{"deny_ips", file.DenyIPs, &limits.Deny},
+		{"trusted_proxies", file.TrustedProxies, &limits.TrustedProxies},
+	} {
+		if networks.entries == nil {
+			continue
+		}
+		parsed, err := address.ParseNetworks(strings.Join(networks.entries, ","))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", networks.name, err)
+		}
+		*networks.target = parsed
+	}
+	for opcode, limit := range file.OpcodeLimits {
+		if limit.Rate < 0 || limit.Burst < 0 {
+			return nil, fmt.Errorf("opcode_limits: %s: rate and burst can't be negative", opcode)
+		}
+		packets.Opcodes[opcode] = limit
+	}
+	if file.MaxFrameSize != nil {
+		packets.MaxFrameSize = *file.MaxFrameSize
+	}
+	if file.MaxRateViolations != nil {
+		packets.MaximumViolations = *file.MaxRateViolations
+	}
+	if file.ShutdownTimeout != "" {
+		timeout, err := time.ParseDuration(file.ShutdownTimeout)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("shutdown_timeout: invalid duration %q", file.ShutdownTimeout)
+		}
+		settings.DrainTimeout = timeout
+	}
+	if file.ReconnectURL != nil {
+		settings.ReconnectURL = *file.ReconnectURL
+	}
+	if file.RecordingsDir != nil {
+		settings.RecordingsDir = *file.RecordingsDir
+	}
+	if file.RecordingMaxSize != nil {
+		settings.RecordingMaxSize = *file.RecordingMaxSize
+	}
+	if file.RecordingMaxDuration != "" {
+		duration, err := time.ParseDuration(file.RecordingMaxDuration)
+		if err != nil || duration < 0 {
+			return nil, fmt.Errorf("recording_max_duration: invalid duration %q", file.RecordingMaxDuration)
+		}
+		settings.RecordingMaxDuration = duration
+	}
+	if file.STUNHost != nil {
+		settings.STUNHost = *file.STUNHost
+	}
+	if file.STUNPort != nil {
+		settings.STUNPort = *file.STUNPort
+	}
+	if file.STUNAltPort != nil {
+		settings.STUNAltPort = *file.STUNAltPort
+	}
+	if err := CheckSTUN(&settings); err != nil {
+		return nil, err
+	}
+	if settings.MaxSessions < 0 || limits.MaxSessionsPerIP < 0 || limits.Rate < 0 || limits.Burst < 0 || packets.MaxFrameSize < 0 || packets.MaximumViolations < 0 || settings.RecordingMaxSize < 0 {
+		return nil, fmt.Errorf("limits can't be negative")
+	}
+	return &settings, nil
+}
+
+// apply returns a copy of the base origin policy, with the settings of the file applied to it.
+func (o *Origins) apply(base origin.Config) origin.Config {
+	config := base
+	if o.Origins != nil {
+		config.Origins = o.Origins
+	}
+	if o.Games != nil {
+		config.Games = o.Games
+	}
+	if o.AllowMissing != nil {
+		config.AllowMissing = *o.AllowMissing
+	}
+	if o.CacheSize != nil {
+		config.CacheSize = *o.CacheSize
+	}
+	return config
+}
+
+// CheckSTUN returns an error if the STUN responder is enabled without two distinct valid ports.
+func CheckSTUN(settings *structs.Settings) error {
+	if settings.STUNHost == "" {
+		return nil
+	}
+	for _, port := range []struct {
+		name  string
+		value int
+	}{
+		{"stun_port", settings.STUNPort},
+		{"stun_alt_port", settings.STUNAltPort},
+	} {
+		if port.value < 1 || port.value > 65535 {
+			return fmt.Errorf("%s: invalid port %d", port.name, port.value)
+		}
+	}
+	if settings.STUNPort == settings.STUNAltPort {
+		return fmt.Errorf("stun_port and stun_alt_port must be different")
+	}
+	return nil
+}
+
+// describe formats each setting, so that changes can be logged.
+func describe(settings *structs.Settings, policy *origin.Policy) map[string]string {
+	described := map[string]string{
+		"turn_only":              fmt.Sprint(settings.TURNOnly),
+		"ice_servers":            marshal(settings.ICEServers),
+		"max_sessions":           fmt.Sprint(settings.MaxSessions),
+		"max_sessions_per_ip":    fmt.Sprint(settings.Limits.MaxSessionsPerIP),
+		"connection_rate":        fmt.Sprint(settings.Limits.Rate),
+		"connection_burst":       fmt.Sprint(settings.Limits.Burst),
+		"allow_ips":              fmt.Sprint(settings.Limits.Allow),
+		"deny_ips":               fmt.Sprint(settings.Limits.Deny),
+		"trusted_proxies":        fmt.Sprint(settings.Limits.TrustedProxies),
+		"max_frame_size":         fmt.Sprint(settings.PacketLimits.MaxFrameSize),
+		"max_rate_violations":    fmt.Sprint(settings.PacketLimits.MaximumViolations),
+		"shutdown_timeout":       settings.DrainTimeout.String(),
+		"reconnect_url":          settings.ReconnectURL,
+		"recordings_dir":         settings.RecordingsDir,
+		"recording_max_size":     fmt.Sprint(settings.RecordingMaxSize),
+		"recording_max_duration": settings.RecordingMaxDuration.String(),
+		"stun_host":              settings.STUNHost,
+		"stun_port":              fmt.Sprint(settings.STUNPort),
+		"stun_alt_port":          fmt.Sprint(settings.STUNAltPort),
+		"origins":                marshal(policy.Config),
+	}
+	for _, subsystem := range logging.Subsystems {
+		described["log_levels."+subsystem] = logging.Level(subsystem).String()
+	}
+	for opcode, limit := range settings.PacketLimits.Opcodes {
+		described["opcode_limits."+opcode] = fmt.Sprintf("%v/%d", limit.Rate, limit.Burst)
+	}
+	return described
+}
+
+// marshal formats a setting as JSON. Credentials of ICE servers are redacted.
+func marshal(value any) string {
+	if servers, ok := value.([]webrtc.ICEServer); ok {
+		redacted := make([]webrtc.ICEServer, len(servers))
+		for i, server := range servers {
+			redacted[i] = webrtc.ICEServer{URLs: server.URLs, Username: server.Username}
+			if server.Credential != nil {
+				redacted[i].Credential = logging.Redacted
+			}
+		}
+		value = redacted
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
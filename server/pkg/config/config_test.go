@@ -0,0 +1,139 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/origin"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// newLoader returns a server and a loader of a configuration file in a temporary directory,
+// whose command line settings allow requests without an Origin header from example.com.
+func newLoader(t *testing.T) (*structs.Server, *Loader) {
+	t.Helper()
+	base := &structs.Settings{
+		MaxSessions:  100,
+		Limits:       &structs.ConnectionLimits{MaxSessionsPerIP: 16},
+		PacketLimits: &structs.SignalingLimits{Opcodes: map[string]structs.OpcodeLimit{}},
+	}
+	policy, err := origin.Compile(origin.Config{Origins: []string{"https://example.com"}, AllowMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &structs.Server{}
+	s.Settings.Store(base)
+	s.Origins.Store(policy)
+	s.OriginBase.Store(policy)
+	return s, &Loader{Path: filepath.Join(t.TempDir(), "config.json"), Base: base}
+}
+
+// load writes the configuration file and loads it.
+func load(t *testing.T, s *structs.Server, l *Loader, contents string) error {
+	t.Helper()
+	if err := os.WriteFile(l.Path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return l.Load(s)
+}
+
+func TestUnknownSettingsAreRejected(t *testing.T) {
+	s, l := newLoader(t)
+	for _, contents := range []string{
+		`{"max_sesions": 5}`,
+		`{"origins": {"origins": ["*"], "allow_mising": false}}`,
+		`{"max_sessions": 5} {"max_sessions": 6}`,
+	} {
+		if err := load(t, s, l, contents); err == nil {
+			t.Fatalf("%s was loaded", contents)
+		}
+		if s.Settings.Load() != l.Base {
+			t.Fatalf("the rejected file %s changed the settings", contents)
+		}
+	}
+
+	if err := load(t, s, l, `{"max_sessions": 5}`); err != nil {
+		t.Fatal(err)
+	}
+	if settings := s.Settings.Load(); settings.MaxSessions != 5 || settings.Limits.MaxSessionsPerIP != 16 {
+		t.Fatalf("the file gave %d sessions and %d per IP, want 5 and 16", settings.MaxSessions, settings.Limits.MaxSessionsPerIP)
+	}
+}
+
+func TestOriginsDefaultToTheCommandLine(t *testing.T) {
+	s, l := newLoader(t)
+
+	if err := load(t, s, l, `{"origins": {"origins": ["https://other.example.com"]}}`); err != nil {
+		t.Fatal(err)
+	}
+	config := s.Origins.Load().Config
+	if len(config.Origins) != 1 || config.Origins[0] != "https://other.example.com" {
+		t.Fatalf("the file's origins weren't applied: %v", config.Origins)
+	}
+	if !config.AllowMissing {
+		t.Fatal("allow_missing didn't keep the command line value when the file left it out")
+	}
+	if allowed, _ := s.Origins.Load().Allowed("https://example.com", ""); allowed {
+		t.Fatal("the command line origins are still allowed")
+	}
+
+	if err := load(t, s, l, `{"origins": {"allow_missing": false}}`); err != nil {
+		t.Fatal(err)
+	}
+	config = s.Origins.Load().Config
+	if config.AllowMissing || len(config.Origins) != 1 || config.Origins[0] != "https://example.com" {
+		t.Fatalf("the file gave %+v, want the command line origins without allowing a missing origin", config)
+	}
+
+	if err := load(t, s, l, `{}`); err != nil {
+		t.Fatal(err)
+	}
+	if s.Origins.Load() != s.OriginBase.Load() {
+		t.Fatal("the command line origin policy wasn't restored when the file left out origins")
+	}
+}
+
+func TestReloadsKeepTheBaseOriginPolicy(t *testing.T) {
+	s, l := newLoader(t)
+
+	// The admin API replaces the base policy, as PUT /admin/origins does
+	replaced, err := origin.Compile(origin.Config{Origins: []string{"https://admin.example.com"}, AllowMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.OriginBase.Store(replaced)
+	s.Origins.Store(replaced)
+
+	if err := load(t, s, l, `{"max_sessions": 5}`); err != nil {
+		t.Fatal(err)
+	}
+	if s.Origins.Load() != replaced {
+		t.Fatal("a reload without origins reverted the origin policy set through the admin API")
+	}
+
+	if err := load(t, s, l, `{"origins": {"allow_missing": false}}`); err != nil {
+		t.Fatal(err)
+	}
+	config := s.Origins.Load().Config
+	if config.AllowMissing || len(config.Origins) != 1 || config.Origins[0] != "https://admin.example.com" {
+		t.Fatalf("the file gave %+v, want the admin API's origins without allowing a missing origin", config)
+	}
+}
+
+func TestSTUNChangesAreRejectedOnReload(t *testing.T) {
+	s, l := newLoader(t)
+	if err := load(t, s, l, `{"stun_host": "stun.example.com", "stun_port": 3478, "stun_alt_port": 3479}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := load(t, s, l, `{"stun_host": "stun.example.com", "stun_port": 3478, "stun_alt_port": 3479, "max_sessions": 5}`); err != nil {
+		t.Fatalf("a reload that kept the STUN settings was rejected: %v", err)
+	}
+	loaded := s.Settings.Load()
+	if err := load(t, s, l, `{"stun_host": "other.example.com", "stun_port": 3478, "stun_alt_port": 3479}`); err == nil {
+		t.Fatal("a reload that changed the STUN settings was accepted")
+	}
+	if s.Settings.Load() != loaded {
+		t.Fatal("the rejected reload changed the settings")
+	}
+}
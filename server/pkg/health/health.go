@@ -0,0 +1,124 @@
+package health
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/constants"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/gofiber/fiber/v2"
+)
+
+// CheckTimeout is how long the readiness endpoint waits for the embedded STUN responder to answer.
+const CheckTimeout = time.Second
+
+// CacheDuration is how long the readiness endpoint reuses the results of the checks that open
+// connections, so that requests to the unauthenticated endpoint stay cheap.
+const CacheDuration = 5 * time.Second
+
+// Register mounts /healthz, which reports whether the process is alive, and /readyz, which
+// reports whether the server should be sent new clients. Both respond with a structs.HealthReport,
+// and /readyz responds with 503 if any component is unavailable. They must be registered before
+// the websocket upgrader.
+func Register(router fiber.Router, s *structs.Server) {
+	router.Get("/healthz", func(c *fiber.Ctx) error {
+		return c.JSON(&structs.HealthReport{
+			Status:  structs.HEALTH_OK,
+			Version: constants.Version,
+		})
+	})
+
+	checks := &Checker{}
+	router.Get("/readyz", func(c *fiber.Ctx) error {
+		report := checks.Ready(s)
+		if report.Status != structs.HEALTH_OK {
+			logging.Signaling().Debug("Server not ready", "components", report.Components)
+			return c.Status(fiber.StatusServiceUnavailable).JSON(report)
+		}
+		return c.JSON(report)
+	})
+}
+
+// Checker caches the results of the readiness checks that open connections for CacheDuration.
+type Checker struct {
+	mux     sync.Mutex
+	checked time.Time
+	stun    *structs.ComponentHealth
+	relay   *structs.ComponentHealth
+}
+
+// Ready checks whether the server should be sent new clients. The server is ready unless it is
+// draining, it is at its session limit, the embedded STUN responder doesn't answer, or relay
+// peer connections can't be created. The last two are checked at most once per CacheDuration.
+func (c *Checker) Ready(s *structs.Server) *structs.HealthReport {
+	c.mux.Lock()
+	if time.Since(c.checked) >= CacheDuration {
+		c.stun = responder(s)
+		c.relay = relay(s)
+		c.checked = time.Now()
+	}
+	stun, relay := c.stun, c.relay
+	c.mux.Unlock()
+
+	report := &structs.HealthReport{
+		Status:  structs.HEALTH_OK,
+		Version: constants.Version,
+		Components: map[string]*structs.ComponentHealth{
+			"draining": draining(s),
+			"sessions": sessions(s),
+			"stun":     stun,
+			"relay":    relay,
+		},
+	}
+	for _, component := range report.Components {
+		if component.Status == structs.HEALTH_UNAVAILABLE {
+			report.Status = structs.HEALTH_UNAVAILABLE
+		}
+	}
+	return report
+}
+
+// draining checks that the server isn't draining.
+func draining(s *structs.Server) *structs.ComponentHealth {
+	if s.Draining.Load() {
+		return &structs.ComponentHealth{Status: structs.HEALTH_UNAVAILABLE, Detail: "the server is draining"}
+	}
+	return &structs.ComponentHealth{Status: structs.HEALTH_OK}
+}
+
+// sessions checks that the server is under its session limit.
+func sessions(s *structs.Server) *structs.ComponentHealth {
+	count := manager.CountSessions(s)
+	maximum := s.Settings.Load().MaxSessions
+	if maximum <= 0 {
+		return &structs.ComponentHealth{Status: structs.HEALTH_OK, Detail: fmt.Sprintf("%d sessions", count)}
+	}
+	detail := fmt.Sprintf("%d of %d sessions", count, maximum)
+	if count >= maximum {
+		return &structs.ComponentHealth{Status: structs.HEALTH_UNAVAILABLE, Detail: detail}
+	}
+	return &structs.ComponentHealth{Status: structs.HEALTH_OK, Detail: detail}
+}
+
+// responder checks that the embedded STUN responder answers binding requests, if it is enabled.
+func responder(s *structs.Server) *structs.ComponentHealth {
+	if s.STUN == nil {
+		return &structs.ComponentHealth{Status: structs.HEALTH_DISABLED}
+	}
+	if err := s.STUN.Check(CheckTimeout); err != nil {
+		return &structs.ComponentHealth{Status: structs.HEALTH_UNAVAILABLE, Detail: err.Error()}
+	}
+	return &structs.ComponentHealth{Status: structs.HEALTH_OK}
+}
+
+// relay checks that relay peer connections can be created.
+func relay(s *structs.Server) *structs.ComponentHealth {
+	if err := peer.SelfCheck(s); err != nil {
+		return &structs.ComponentHealth{Status: structs.HEALTH_UNAVAILABLE, Detail: err.Error()}
+	}
+	return &structs.ComponentHealth{Status: structs.HEALTH_OK}
+}
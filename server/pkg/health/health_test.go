@@ -0,0 +1,48 @@
+package health
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestReadyCachesConnectionChecks(t *testing.T) {
+	s := &structs.Server{
+		Sessions: &structs.SessionStore{Sessions: make(map[string]*structs.Session)},
+	}
+	s.Settings.Store(&structs.Settings{ICEServers: []webrtc.ICEServer{}, Limits: &structs.ConnectionLimits{}})
+	checks := &Checker{}
+
+	first := checks.Ready(s)
+	if first.Status != structs.HEALTH_OK {
+		t.Fatalf("the server isn't ready: %+v", first.Components)
+	}
+	if first.Components["stun"].Status != structs.HEALTH_DISABLED {
+		t.Fatal("the STUN responder isn't reported as disabled")
+	}
+
+	// Cheap checks aren't cached
+	s.Draining.Store(true)
+	second := checks.Ready(s)
+	if second.Status != structs.HEALTH_UNAVAILABLE {
+		t.Fatal("the draining server is reported as ready")
+	}
+	if second.Components["relay"] != first.Components["relay"] {
+		t.Fatal("the relay check ran again within the cache duration")
+	}
+
+	// Concurrent requests share the cached results
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if checks.Ready(s).Components["relay"] != first.Components["relay"] {
+				t.Error("the relay check ran again within the cache duration")
+			}
+		}()
+	}
+	wg.Wait()
+}
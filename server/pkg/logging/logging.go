@@ -0,0 +1,232 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The server's subsystems, which can be given their own log levels.
+const (
+	SIGNALING = "signaling" // websocket sessions, signaling handlers and HTTP requests
+	MANAGER   = "manager"   // changes to games, lobbies and relays
+	RELAY     = "relay"     // server relays, tick loops, recordings and diagnostics
+)
+
+// Subsystems lists every subsystem that can be given its own log level.
+var Subsystems = []string{SIGNALING, MANAGER, RELAY}
+
+// Redacted replaces the values of redacted attributes.
+const Redacted = "[redacted]"
+
+// RedactedKeys are the attributes that are redacted when Config.Redact is set.
+var RedactedKeys = []string{"user", "username", "password"}
+
+// Config configures the server's logging.
+type Config struct {
+	Format string                // "json" or "text", defaults to text
+	Level  slog.Level            // level of subsystems that aren't in Levels
+	Levels map[string]slog.Level // level of each subsystem, keyed by subsystem name
+	Redact bool                  // replaces usernames and passwords with Redacted
+	Output io.Writer             // defaults to stderr
+}
+
+// loggers holds the logger of each subsystem.
+type loggers map[string]*slog.Logger
+
+var (
+	current atomic.Pointer[loggers]
+	levels  = map[string]*slog.LevelVar{}
+)
+
+func init() {
+	for _, subsystem := range Subsystems {
+		levels[subsystem] = &slog.LevelVar{}
+	}
+	Setup(Config{})
+}
+
+// Setup replaces the server's loggers, including the default slog logger, using the given config.
+// It is safe to call while the server is running.
+func Setup(config Config) {
+	output := config.Output
+	if output == nil {
+		output = os.Stderr
+	}
+
+	// Subsystems filter records by their own level, so the handler lets everything through
+	options := &slog.HandlerOptions{Level: slog.LevelDebug - 4}
+	if config.Redact {
+		options.ReplaceAttr = redact
+	}
+	var handler slog.Handler
+	if config.Format == "json" {
+		handler = slog.NewJSONHandler(output, options)
+	} else {
+		handler = slog.NewTextHandler(output, options)
+	}
+
+	next := loggers{}
+	for _, subsystem := range Subsystems {
+		level, exists := config.Levels[subsystem]
+		if !exists {
+			level = config.Level
+		}
+		levels[subsystem].Set(level)
+		next[subsystem] = slog.New(&leveled{Handler: handler, level: levels[subsystem]}).With("subsystem", subsystem)
+	}
+	current.Store(&next)
+	slog.SetDefault(slog.New(&leveled{Handler: handler, level: levelOf(config.Level)}))
+}
+
+// SetLevel changes the log level of a subsystem. It does nothing if the subsystem doesn't exist.
+func SetLevel(subsystem string, level slog.Level) {
+	if variable, exists := levels[subsystem]; exists {
+		variable.Set(level)
+	}
+}
+
+// Level returns the log level of a subsystem. It returns the info level if the subsystem doesn't exist.
+func Level(subsystem string) slog.Level {
+	if variable, exists := levels[subsystem]; exists {
+		return variable.Level()
+	}
+	return slog.LevelInfo
+}
+
+// Signaling returns the logger of the signaling subsystem.
+func Signaling() *slog.Logger {
+	return (*current.Load())[SIGNALING]
+}
+
+// Manager returns the logger of the manager subsystem.
+func Manager() *slog.Logger {
+	return (*current.Load())[MANAGER]
+}
+
+// Relay returns the logger of the relay subsystem.
+func Relay() *slog.Logger {
+	return (*current.Load())[RELAY]
+}
+
+// Client returns the signaling logger with the fields that identify a client and its lobby.
+func Client(client *structs.Client) *slog.Logger {
+	if client == nil {
+		return Signaling()
+	}
+	return WithClient(Signaling(), client).With("ugi", client.UGI, "lobby", client.Lobby)
+}
+
+// Packet returns the signaling logger with the fields that identify a client and the opcode it sent.
+func Packet(client *structs.Client, opcode string) *slog.Logger {
+	return Client(client).With("opcode", opcode)
+}
+
+// ForRelay returns the relay logger with the fields that identify a relay's peer.
+func ForRelay(r *structs.Relay) *slog.Logger {
+	return WithClient(Relay(), r.Peer).With("ugi", r.UGI, "lobby", r.Lobby)
+}
+
+// WithClient adds the fields that identify a client to a logger. The client's game and lobby
+// aren't added, since callers often log about a different one.
+func WithClient(logger *slog.Logger, client *structs.Client) *slog.Logger {
+	if client == nil {
+		return logger
+	}
+	return logger.With(
+		"client", client.ID,
+		"session", client.Session,
+		"user", client.Username,
+	)
+}
+
+// Middleware logs every HTTP request the server handles at the debug level, or at the warning
+// level if it failed with a server error.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		started := time.Now()
+		err := c.Next()
+		status := c.Response().StatusCode()
+		if err != nil {
+			if e, ok := err.(*fiber.Error); ok {
+				status = e.Code
+			}
+		}
+		level := slog.LevelDebug
+		if status >= fiber.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		Signaling().Log(c.UserContext(), level, "HTTP request",
+			"method", c.Method(),
+			"path", c.Path(),
+			"status", status,
+			"ip", c.IP(),
+			"latency", time.Since(started),
+		)
+		return err
+	}
+}
+
+// ParseLevels parses per-subsystem log levels written as "subsystem=level" pairs separated by
+// commas, such as "signaling=debug,relay=warn".
+func ParseLevels(text string) (map[string]slog.Level, error) {
+	parsed := map[string]slog.Level{}
+	for _, pair := range strings.Split(text, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		subsystem, name, found := strings.Cut(pair, "=")
+		subsystem = strings.TrimSpace(subsystem)
+		if !found || !slices.Contains(Subsystems, subsystem) {
+			return nil, fmt.Errorf("invalid subsystem log level %q, expected one of %s followed by =level", pair, strings.Join(Subsystems, ", "))
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+			return nil, err
+		}
+		parsed[subsystem] = level
+	}
+	return parsed, nil
+}
+
+// redact replaces the values of RedactedKeys.
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if slices.Contains(RedactedKeys, attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+	return attr
+}
+
+// levelOf returns a level variable set to the given level.
+func levelOf(level slog.Level) *slog.LevelVar {
+	variable := &slog.LevelVar{}
+	variable.Set(level)
+	return variable
+}
+
+// leveled filters the records of a handler by a level that can change while the server runs.
+type leveled struct {
+	slog.Handler
+	level *slog.LevelVar
+}
+
+func (h *leveled) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h *leveled) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &leveled{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *leveled) WithGroup(name string) slog.Handler {
+	return &leveled{Handler: h.Handler.WithGroup(name), level: h.level}
+}
@@ -0,0 +1,97 @@
+package manager
+
+import (
+	"errors"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"golang.org/x/time/rate"
+)
+
+// AddressLifetime is how long an IP address without sessions is remembered, along with its
+// connection rate limit.
+const AddressLifetime = 5 * time.Minute
+
+// Errors returned by AdmitAddress.
+var (
+	ErrServerFull      = errors.New("the server is at its session limit")
+	ErrAddressFull     = errors.New("the address is at its session limit")
+	ErrAddressThrottle = errors.New("the address is connecting too often")
+)
+
+// AdmitAddress checks whether an IP address may open a new session, according to the server's
+// session limit and connection limits, and if so reserves a session for it. The checks and the
+// reservation are made under the same lock, so concurrent connections can't exceed the limits.
+// If the address is connecting too often, the time until it may try again is returned along
+// with ErrAddressThrottle. Every admitted session must be released with RemoveAddressSession,
+// including connections that fail to upgrade.
+// It locks the server's Addresses map for thread safety.
+func AdmitAddress(s *structs.Server, ip string) (time.Duration, error) {
+	settings := s.Settings.Load()
+	s.Addresses.Mutex.Lock()
+	defer s.Addresses.Mutex.Unlock()
+	if settings.MaxSessions > 0 && s.Addresses.Sessions >= settings.MaxSessions {
+		return 0, ErrServerFull
+	}
+	sweep_addresses(s)
+	state := get_address(s, ip)
+	state.Seen = time.Now()
+	if settings.Limits.MaxSessionsPerIP > 0 && state.Sessions >= settings.Limits.MaxSessionsPerIP {
+		return 0, ErrAddressFull
+	}
+	if state.Limiter != nil {
+		reservation := state.Limiter.Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			return delay, ErrAddressThrottle
+		}
+	}
+	state.Sessions++
+	s.Addresses.Sessions++
+	return 0, nil
+}
+
+// RemoveAddressSession releases a session admitted by AdmitAddress, once it closes or fails to open.
+// It locks the server's Addresses map for thread safety.
+func RemoveAddressSession(s *structs.Server, ip string) {
+	s.Addresses.Mutex.Lock()
+	defer s.Addresses.Mutex.Unlock()
+	state, exists := s.Addresses.Addresses[ip]
+	if !exists {
+		return
+	}
+	if state.Sessions > 0 {
+		state.Sessions--
+		s.Addresses.Sessions--
+	}
+	state.Seen = time.Now()
+}
+
+// get_address returns the state of an IP address, creating it if it doesn't exist.
+// The caller must hold the server's Addresses lock.
+func get_address(s *structs.Server, ip string) *structs.AddressState {
+	state, exists := s.Addresses.Addresses[ip]
+	if !exists {
+		state = &structs.AddressState{}
+		limits := s.Settings.Load().Limits
+		if limits.Rate > 0 {
+			state.Limiter = rate.NewLimiter(rate.Limit(limits.Rate), max(limits.Burst, 1))
+		}
+		s.Addresses.Addresses[ip] = state
+	}
+	return state
+}
+
+// sweep_addresses forgets addresses that have had no sessions for AddressLifetime, at most
+// once per AddressLifetime. The caller must hold the server's Addresses lock.
+func sweep_addresses(s *structs.Server) {
+	if time.Since(s.Addresses.Swept) < AddressLifetime {
+		return
+	}
+	s.Addresses.Swept = time.Now()
+	for ip, state := range s.Addresses.Addresses {
+		if state.Sessions == 0 && time.Since(state.Seen) > AddressLifetime {
+			delete(s.Addresses.Addresses, ip)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// newAddressServer returns a server with the given session limits and no connection rate limit.
+func newAddressServer(total int, perIP int) *structs.Server {
+	s := &structs.Server{
+		Addresses: &structs.AddressStore{Addresses: make(map[string]*structs.AddressState)},
+	}
+	s.Settings.Store(&structs.Settings{MaxSessions: total, Limits: &structs.ConnectionLimits{MaxSessionsPerIP: perIP}})
+	return s
+}
+
+// admitConcurrently admits the address from many goroutines at once, and returns how many were admitted.
+func admitConcurrently(s *structs.Server, ip string, attempts int) int {
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	admitted := 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := AdmitAddress(s, ip); err == nil {
+				mux.Lock()
+				admitted++
+				mux.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return admitted
+}
+
+func TestAdmitAddressReservesSessions(t *testing.T) {
+	s := newAddressServer(0, 4)
+	if admitted := admitConcurrently(s, "192.0.2.1", 100); admitted != 4 {
+		t.Fatalf("%d concurrent connections were admitted, want 4", admitted)
+	}
+	if _, err := AdmitAddress(s, "192.0.2.1"); err != ErrAddressFull {
+		t.Fatalf("admitting a full address returned %v, want %v", err, ErrAddressFull)
+	}
+
+	RemoveAddressSession(s, "192.0.2.1")
+	if _, err := AdmitAddress(s, "192.0.2.1"); err != nil {
+		t.Fatalf("the released session wasn't available again: %v", err)
+	}
+}
+
+func TestAdmitAddressReservesServerSessions(t *testing.T) {
+	s := newAddressServer(3, 0)
+	if admitted := admitConcurrently(s, "192.0.2.1", 50) + admitConcurrently(s, "192.0.2.2", 50); admitted != 3 {
+		t.Fatalf("%d concurrent connections were admitted, want 3", admitted)
+	}
+	if _, err := AdmitAddress(s, "192.0.2.3"); err != ErrServerFull {
+		t.Fatalf("admitting an address to a full server returned %v, want %v", err, ErrServerFull)
+	}
+
+	RemoveAddressSession(s, "192.0.2.1")
+	RemoveAddressSession(s, "192.0.2.3") // never admitted, so it must not free a session
+	if _, err := AdmitAddress(s, "192.0.2.3"); err != nil {
+		t.Fatalf("the released session wasn't available again: %v", err)
+	}
+	if _, err := AdmitAddress(s, "192.0.2.3"); err != ErrServerFull {
+		t.Fatalf("releasing an address without sessions freed a session, got %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package manager
+
+import (
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// SetDiagnostic stores a client's diagnostic test connection on the server.
+// It returns false if the client already has one.
+func SetDiagnostic(s *structs.Server, client *structs.Client, diagnostic *structs.Diagnostic) bool {
+	s.DiagnosticsLock.Lock()
+	defer s.DiagnosticsLock.Unlock()
+	if _, exists := s.Diagnostics[client]; exists {
+		return false
+	}
+	s.Diagnostics[client] = diagnostic
+	return true
+}
+
+// GetDiagnostic gets a client's diagnostic test connection from the server, or nil if it has none.
+func GetDiagnostic(s *structs.Server, client *structs.Client) *structs.Diagnostic {
+	s.DiagnosticsLock.RLock()
+	defer s.DiagnosticsLock.RUnlock()
+	return s.Diagnostics[client]
+}
+
+// DeleteDiagnostic removes a client's diagnostic test connection from the server.
+func DeleteDiagnostic(s *structs.Server, client *structs.Client) {
+	s.DiagnosticsLock.Lock()
+	defer s.DiagnosticsLock.Unlock()
+	delete(s.Diagnostics, client)
+}
@@ -17,7 +17,7 @@ func get_lobby(s *structs.Server, gameid string, lobbyid string) *structs.Lobby
 		s.Games.Games[gameid].Lobbies = make(map[string]*structs.Lobby)
 	}
 	if _, exists := s.Games.Games[gameid].Lobbies[lobbyid]; !exists {
-		s.Games.Games[gameid].Lobbies[lobbyid] = &structs.Lobby{Mutex: sync.RWMutex{}, Host: nil, Settings: &structs.LobbySettings{}, Clients: make([]*structs.Client, 0)}
+		s.Games.Games[gameid].Lobbies[lobbyid] = &structs.Lobby{Mutex: sync.RWMutex{}, Host: nil, Settings: &structs.LobbySettings{}, Clients: make([]*structs.Client, 0), Store: new_store(), Traffic: &structs.RelayCounters{}}
 	}
 	return s.Games.Games[gameid].Lobbies[lobbyid]
 }
@@ -30,3 +30,8 @@ func get_game(s *structs.Server, gameid string) *structs.Game {
 	}
 	return s.Games.Games[gameid]
 }
+
+// new_store is an internal helper function that creates an empty shared variable store.
+func new_store() *structs.SharedStore {
+	return &structs.SharedStore{Vars: make(map[string]*structs.SharedValue), Lists: make(map[string]*structs.SharedValue)}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"slices"
 
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
 )
 
@@ -69,12 +70,14 @@ func AddClientToLobby(s *structs.Server, lobbyid string, gameid string, client *
 			return
 		}
 		lobby.Clients = append(lobby.Clients, client)
+		logging.WithClient(logging.Manager(), client).Debug("Client added to lobby", "ugi", gameid, "lobby", lobbyid)
 	}()
 }
 
 // RemoveClientFromLobby removes a client from a lobby in a game on a server, if it exists.
 // It does nothing if the client is not in the lobby or if the lobby doesn't exist.
-// It also does nothing if the client doesn't exist on the server.
+// It also does nothing if the client doesn't exist on the server. The client's QUALITY_REPORT samples are discarded,
+// and ICE restarts the client is part of are cancelled.
 func RemoveClientFromLobby(s *structs.Server, lobbyid string, gameid string, client *structs.Client) {
 	if !DoesPeerExist(s, client.ID) {
 		return
@@ -96,11 +99,15 @@ func RemoveClientFromLobby(s *structs.Server, lobbyid string, gameid string, cli
 			return
 		}
 		lobby.Clients = append(lobby.Clients[:i], lobby.Clients[i+1:]...)
+		logging.WithClient(logging.Manager(), client).Debug("Client removed from lobby", "ugi", gameid, "lobby", lobbyid)
 	}()
+	delete(lobby.Quality, client.ID)
+	clear_restarts(lobby, client)
 }
 
 // DestroyLobby destroys a lobby in a game on a server, removing it from the server's Games map.
-// It does nothing if the lobby doesn't exist.
+// It does nothing if the lobby doesn't exist. The lobby's tick loop and recording, if any, are stopped,
+// and its relay traffic is added to the server's total.
 // It locks the server's Games map and the specific game's Lobbies map for thread safety.
 func DestroyLobby(s *structs.Server, gameid string, lobbyid string) {
 	if !DoesLobbyExist(s, lobbyid, gameid) {
@@ -112,9 +119,22 @@ func DestroyLobby(s *structs.Server, gameid string, lobbyid string) {
 		s.Games.Games[gameid].Mutex.Lock()
 		defer s.Games.Games[gameid].Mutex.Unlock()
 		func() {
+			// Stop the lobby's tick loop and recording, if it has them
+			lobby := s.Games.Games[gameid].Lobbies[lobbyid]
+			if lobby.Ticker != nil {
+				lobby.Ticker.Halt()
+			}
+			if lobby.Recorder != nil {
+				lobby.Recorder.Close()
+			}
+			if lobby.Traffic != nil {
+				s.Games.Games[gameid].Traffic.Add(lobby.Traffic)
+			}
 			delete(s.Games.Games[gameid].Lobbies, lobbyid)
 		}()
+		logging.Manager().Info("Lobby destroyed", "ugi", gameid, "lobby", lobbyid)
 		if len(s.Games.Games[gameid].Lobbies) == 0 {
+			s.Traffic.Add(&s.Games.Games[gameid].Traffic)
 			delete(s.Games.Games, gameid)
 		}
 	}()
@@ -137,6 +157,7 @@ func SetLobbyHost(s *structs.Server, lobbyid string, gameid string, client *stru
 			lobby.Host = client
 		}()
 	}()
+	logging.WithClient(logging.Manager(), client).Debug("Lobby host changed", "ugi", gameid, "lobby", lobbyid)
 }
 
 // RemoveLobbyHost removes the host of a lobby in a game on a server.
@@ -206,6 +227,78 @@ func GetLobbySettings(s *structs.Server, lobbyid string, gameid string) *structs
 	return lobby.Settings
 }
 
+// SetLobbyTicker sets the lockstep tick loop of a lobby in a game on a server.
+// It does nothing if the lobby doesn't exist.
+// It locks the server's Games map and the specific lobby's Mutex for thread safety.
+func SetLobbyTicker(s *structs.Server, lobbyid string, gameid string, ticker *structs.TickLoop) {
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return
+	}
+	s.Games.Mutex.Lock()
+	defer s.Games.Mutex.Unlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	lobby.Ticker = ticker
+}
+
+// GetLobbyTicker retrieves the lockstep tick loop of a lobby in a given game on the server.
+// It returns nil if the lobby doesn't exist or doesn't use tick mode.
+func GetLobbyTicker(s *structs.Server, lobbyid string, gameid string) *structs.TickLoop {
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return nil
+	}
+	s.Games.Mutex.RLock()
+	defer s.Games.Mutex.RUnlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.RLock()
+	defer lobby.Mutex.RUnlock()
+	return lobby.Ticker
+}
+
+// SetLobbyRecorder sets the relay recorder of a lobby in a game on a server.
+// It does nothing if the lobby doesn't exist.
+// It locks the server's Games map and the specific lobby's Mutex for thread safety.
+func SetLobbyRecorder(s *structs.Server, lobbyid string, gameid string, recorder *structs.Recorder) {
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return
+	}
+	s.Games.Mutex.Lock()
+	defer s.Games.Mutex.Unlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	lobby.Recorder = recorder
+}
+
+// GetLobbyRecorder retrieves the relay recorder of a lobby in a given game on the server.
+// It returns nil if the lobby doesn't exist or isn't being recorded.
+func GetLobbyRecorder(s *structs.Server, lobbyid string, gameid string) *structs.Recorder {
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return nil
+	}
+	s.Games.Mutex.RLock()
+	defer s.Games.Mutex.RUnlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.RLock()
+	defer lobby.Mutex.RUnlock()
+	return lobby.Recorder
+}
+
+// GetLobbyTraffic retrieves the relay traffic counters of a lobby in a given game on the server.
+// It returns nil if the lobby doesn't exist.
+func GetLobbyTraffic(s *structs.Server, lobbyid string, gameid string) *structs.RelayCounters {
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return nil
+	}
+	s.Games.Mutex.RLock()
+	defer s.Games.Mutex.RUnlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.RLock()
+	defer lobby.Mutex.RUnlock()
+	return lobby.Traffic
+}
+
 // DoesLobbyExist checks if a lobby with the given lobbyid exists in a game with the given gameid on the server.
 // It returns true if the lobby exists, otherwise false. The function acquires a read lock on the server's Games map
 // for thread safety while performing the existence checks.
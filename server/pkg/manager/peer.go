@@ -76,9 +76,9 @@ func AddClientToGame(s *structs.Server, gameid string, client *structs.Client) {
 }
 
 // RemoveClientFromGame removes a client from the specified game on the server.
-// If the game does not exist, the function will panic. The function locks
-// the game's client slice for thread safety while ensuring the client is
-// present before attempting removal.
+// It does nothing if the game doesn't exist, and removes the game once it has
+// no clients or lobbies left. The function locks the game's client slice for
+// thread safety while ensuring the client is present before attempting removal.
 func RemoveClientFromGame(s *structs.Server, gameid string, client *structs.Client) {
 	if !DoesGameExist(s, gameid) {
 		return
@@ -98,6 +98,12 @@ func RemoveClientFromGame(s *structs.Server, gameid string, client *structs.Clie
 		}
 		game.Clients = append(game.Clients[:i], game.Clients[i+1:]...)
 	}()
+
+	// Game IDs are chosen by clients, so forget games that nobody uses
+	if len(game.Clients) == 0 && len(game.Lobbies) == 0 {
+		s.Traffic.Add(&game.Traffic)
+		delete(s.Games.Games, gameid)
+	}
 }
 
 // IsClientInGame checks if a client is in the specified game on the server.
@@ -0,0 +1,182 @@
+package manager
+
+import (
+	"math"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// QualityLifetime is how long a QUALITY_REPORT sample is used before it is considered stale.
+const QualityLifetime = 60 * time.Second
+
+// LossPenalty is the cost, in milliseconds of round trip time, of losing every packet. A sample
+// costs its round trip time plus its packet loss times LossPenalty.
+const LossPenalty = 1000.0
+
+// MigrationMargin is how much better connected, as a fraction of the host's cost, a peer must be
+// before the host is sent a MIGRATE_HOST suggestion.
+const MigrationMargin = 0.75
+
+// MigrationCooldown is the minimum time between two MIGRATE_HOST suggestions in the same lobby.
+const MigrationCooldown = 2 * time.Minute
+
+// SetQualitySamples replaces the quality samples that a member of a lobby in a game on a server
+// last reported. The samples are stamped with the current time. It does nothing if the lobby doesn't exist.
+// It locks the server's Games map and the specific lobby's Mutex for thread safety.
+func SetQualitySamples(s *structs.Server, lobbyid string, gameid string, reporter *structs.Client, samples []*structs.QualitySample) {
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return
+	}
+	now := time.Now()
+	row := make(map[string]*structs.QualitySample, len(samples))
+	for _, sample := range samples {
+		if sample.Peer == reporter.ID {
+			continue
+		}
+		sample.Time = now
+		row[sample.Peer] = sample
+	}
+	s.Games.Mutex.Lock()
+	defer s.Games.Mutex.Unlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	if lobby.Quality == nil {
+		lobby.Quality = make(map[string]map[string]*structs.QualitySample)
+	}
+	lobby.Quality[reporter.ID] = row
+}
+
+// GetConnectivityMatrix returns the fresh quality samples reported by the members of a lobby in
+// a game on a server, along with the connection cost of each member that has any.
+// It returns nil if the lobby doesn't exist.
+// It locks the server's Games map and the specific lobby's Mutex for thread safety.
+func GetConnectivityMatrix(s *structs.Server, lobbyid string, gameid string) *structs.ConnectivityMatrix {
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return nil
+	}
+	s.Games.Mutex.RLock()
+	defer s.Games.Mutex.RUnlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.RLock()
+	defer lobby.Mutex.RUnlock()
+
+	matrix := &structs.ConnectivityMatrix{
+		Samples: make(map[string]map[string]*structs.QualitySample),
+		Scores:  make(map[string]float64),
+	}
+	for reporter, row := range lobby.Quality {
+		fresh := make(map[string]*structs.QualitySample)
+		for id, sample := range row {
+			if time.Since(sample.Time) <= QualityLifetime {
+				fresh[id] = sample
+			}
+		}
+		if len(fresh) > 0 {
+			matrix.Samples[reporter] = fresh
+		}
+	}
+	for _, client := range lobby.Clients {
+		if score := quality_score(lobby.Quality, client.ID, lobby.Clients); !math.IsInf(score, 1) {
+			matrix.Scores[client.ID] = score
+		}
+	}
+	return matrix
+}
+
+// BestConnectedPeer returns the candidate with the lowest connection cost to the other candidates
+// and the server relay, according to the QUALITY_REPORT samples of a lobby in a game on a server.
+// A candidate is scored by what the other candidates report about it, not by its own reports.
+// Candidates without fresh samples are only picked if none of them have any, in which case the
+// first candidate is returned. It returns nil if there are no candidates.
+// It locks the server's Games map and the specific lobby's Mutex for thread safety.
+func BestConnectedPeer(s *structs.Server, lobbyid string, gameid string, candidates []*structs.Client) *structs.Client {
+	if len(candidates) == 0 {
+		return nil
+	}
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return candidates[0]
+	}
+	s.Games.Mutex.RLock()
+	defer s.Games.Mutex.RUnlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.RLock()
+	defer lobby.Mutex.RUnlock()
+
+	best, _ := best_connected(lobby.Quality, candidates)
+	return best
+}
+
+// SuggestHostMigration returns the member of a lobby in a game on a server that should be sent
+// to the host in a MIGRATE_HOST suggestion, along with its cost and the host's. It returns nil if
+// no member is better connected than the host by MigrationMargin, or if a suggestion was made in
+// the last MigrationCooldown. Otherwise, the suggestion is recorded.
+// It locks the server's Games map and the specific lobby's Mutex for thread safety.
+func SuggestHostMigration(s *structs.Server, lobbyid string, gameid string) (*structs.Client, float64, float64) {
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return nil, 0, 0
+	}
+	s.Games.Mutex.Lock()
+	defer s.Games.Mutex.Unlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+
+	if lobby.Host == nil || time.Since(lobby.Migrated) < MigrationCooldown {
+		return nil, 0, 0
+	}
+	best, score := best_connected(lobby.Quality, lobby.Clients)
+	if best == nil || best == lobby.Host {
+		return nil, 0, 0
+	}
+	host := quality_score(lobby.Quality, lobby.Host.ID, lobby.Clients)
+	if math.IsInf(host, 1) || score >= host*MigrationMargin {
+		return nil, 0, 0
+	}
+	lobby.Migrated = time.Now()
+	return best, score, host
+}
+
+// best_connected returns the candidate with the lowest connection cost and its cost. If none of
+// the candidates have fresh samples, the first candidate is returned.
+func best_connected(quality map[string]map[string]*structs.QualitySample, candidates []*structs.Client) (*structs.Client, float64) {
+	if len(candidates) == 0 {
+		return nil, 0
+	}
+	best, cost := candidates[0], math.Inf(1)
+	for _, candidate := range candidates {
+		if score := quality_score(quality, candidate.ID, candidates); score < cost {
+			best, cost = candidate, score
+		}
+	}
+	return best, cost
+}
+
+// quality_score returns the mean connection cost of a member, using the fresh samples that the
+// other members reported about it, and its own sample of the server relay. A member's reports
+// about the other members are left out, so that it can't make itself look better connected. It
+// returns positive infinity if there are no fresh samples.
+func quality_score(quality map[string]map[string]*structs.QualitySample, id string, members []*structs.Client) float64 {
+	total, count := 0.0, 0
+	add := func(sample *structs.QualitySample) {
+		if sample == nil || time.Since(sample.Time) > QualityLifetime {
+			return
+		}
+		total += sample.RTT + sample.Loss*LossPenalty
+		count++
+	}
+
+	add(quality[id]["relay"])
+	for _, member := range members {
+		if member.ID == id {
+			continue
+		}
+		add(quality[member.ID][id])
+	}
+
+	if count == 0 {
+		return math.Inf(1)
+	}
+	return total / float64(count)
+}
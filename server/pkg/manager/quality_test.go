@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// sample returns a quality sample of the given round trip time, taken the given time ago.
+func sample(rtt float64, age time.Duration) *structs.QualitySample {
+	return &structs.QualitySample{RTT: rtt, Time: time.Now().Add(-age)}
+}
+
+func TestQualityScore(t *testing.T) {
+	members := []*structs.Client{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	for _, test := range []struct {
+		name    string
+		quality map[string]map[string]*structs.QualitySample
+		want    float64
+	}{
+		{
+			name:    "no samples",
+			quality: map[string]map[string]*structs.QualitySample{},
+			want:    math.Inf(1),
+		},
+		{
+			name: "only self reports",
+			quality: map[string]map[string]*structs.QualitySample{
+				"a": {"b": sample(1, 0), "c": sample(1, 0)},
+			},
+			want: math.Inf(1),
+		},
+		{
+			name: "reports of the other members",
+			quality: map[string]map[string]*structs.QualitySample{
+				"a": {"b": sample(1, 0), "c": sample(1, 0)},
+				"b": {"a": sample(100, 0)},
+				"c": {"a": sample(300, 0)},
+			},
+			want: 200,
+		},
+		{
+			name: "relay sample",
+			quality: map[string]map[string]*structs.QualitySample{
+				"a": {"relay": sample(50, 0)},
+				"b": {"a": sample(150, 0)},
+			},
+			want: 100,
+		},
+		{
+			name: "stale samples",
+			quality: map[string]map[string]*structs.QualitySample{
+				"a": {"relay": sample(50, 2*QualityLifetime)},
+				"b": {"a": sample(100, 0)},
+				"c": {"a": sample(10, 2*QualityLifetime)},
+			},
+			want: 100,
+		},
+		{
+			name: "packet loss",
+			quality: map[string]map[string]*structs.QualitySample{
+				"b": {"a": {RTT: 100, Loss: 0.5, Time: time.Now()}},
+			},
+			want: 100 + 0.5*LossPenalty,
+		},
+	} {
+		if score := quality_score(test.quality, "a", members); score != test.want {
+			t.Errorf("%s: the score is %v, want %v", test.name, score, test.want)
+		}
+	}
+}
+
+func TestBestConnected(t *testing.T) {
+	a, b, c := &structs.Client{ID: "a"}, &structs.Client{ID: "b"}, &structs.Client{ID: "c"}
+	candidates := []*structs.Client{a, b, c}
+
+	if best, _ := best_connected(nil, nil); best != nil {
+		t.Fatal("a candidate was picked out of none")
+	}
+	if best, cost := best_connected(map[string]map[string]*structs.QualitySample{}, candidates); best != a || !math.IsInf(cost, 1) {
+		t.Fatalf("%s was picked at a cost of %v without samples, want the first candidate", best.ID, cost)
+	}
+
+	// c claims to be well connected to everyone, but the others see it as the worst connected
+	quality := map[string]map[string]*structs.QualitySample{
+		"a": {"b": sample(20, 0), "c": sample(400, 0)},
+		"b": {"a": sample(80, 0), "c": sample(400, 0)},
+		"c": {"a": sample(1, 0), "b": sample(1, 0), "relay": sample(1, 0)},
+	}
+	best, cost := best_connected(quality, candidates)
+	if best != b || cost != 10.5 {
+		t.Fatalf("%s was picked at a cost of %v, want b at a cost of 10.5", best.ID, cost)
+	}
+}
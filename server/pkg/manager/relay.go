@@ -3,6 +3,7 @@ package manager
 import (
 	"fmt"
 
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
 )
 
@@ -14,20 +15,35 @@ func GetRelay(s *structs.Server, peer *structs.Client) *structs.Relay {
 	return s.Relays[peer]
 }
 
-// DeleteRelay gracefully shuts down a relay peer and then deletes it from the server.
-// This function is thread-safe and can be called from any goroutine.
+// DeleteRelay removes a relay peer from the server and then gracefully shuts it down.
+// The relay is removed while holding RelayLock, but is shut down after the lock is released,
+// so that the relay can still look up other relays while it shuts down.
+// It does nothing if the peer doesn't have a relay. This function is thread-safe and can be
+// called from any goroutine.
 func DeleteRelay(s *structs.Server, peer *structs.Client) {
 	s.RelayLock.Lock()
-	defer s.RelayLock.Unlock()
-	func() {
+	relay := s.Relays[peer]
+	delete(s.Relays, peer)
+	s.RelayLock.Unlock()
+	if relay == nil {
+		return
+	}
+	logging.WithClient(logging.Manager(), peer).Debug("Relay removed", "ugi", relay.UGI, "lobby", relay.Lobby)
 
-		// Gracefully shutdown the relay
-		s.Relays[peer].RequestShutdown <- true
-		<-s.Relays[peer].ShutdownComplete
+	// Gracefully shutdown the relay
+	relay.RequestShutdown <- true
+	<-relay.ShutdownComplete
+}
 
-		// Delete the relay
-		delete(s.Relays, peer)
-	}()
+// GetAllRelays returns every relay peer on the server.
+func GetAllRelays(s *structs.Server) []*structs.Relay {
+	s.RelayLock.RLock()
+	defer s.RelayLock.RUnlock()
+	relays := make([]*structs.Relay, 0, len(s.Relays))
+	for _, relay := range s.Relays {
+		relays = append(relays, relay)
+	}
+	return relays
 }
 
 // SetRelay sets a relay peer for the given peer on the server.
@@ -37,6 +53,7 @@ func SetRelay(s *structs.Server, peer *structs.Client, relay *structs.Relay) {
 	s.RelayLock.Lock()
 	defer s.RelayLock.Unlock()
 	s.Relays[peer] = relay
+	logging.WithClient(logging.Manager(), peer).Debug("Relay stored", "ugi", relay.UGI, "lobby", relay.Lobby)
 }
 
 func GetRelayPeers(s *structs.Server, lobbyid string, gameid string) []*structs.Relay {
@@ -53,6 +70,8 @@ func GetRelayPeers(s *structs.Server, lobbyid string, gameid string) []*structs.
 	var relays []*structs.Relay
 	lobby.Mutex.RLock()
 	defer lobby.Mutex.RUnlock()
+	s.RelayLock.RLock()
+	defer s.RelayLock.RUnlock()
 	for _, client := range lobby.Clients {
 		relays = append(relays, s.Relays[client])
 	}
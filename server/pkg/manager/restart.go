@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// restart_key returns the key of an ICE restart between two lobby members, no matter which of them started it.
+func restart_key(a string, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "/" + b
+}
+
+// clear_restarts is an internal helper function that removes every ICE restart a client is part of
+// from a lobby and stops their timeouts. The lobby's Mutex must be held.
+func clear_restarts(lobby *structs.Lobby, client *structs.Client) {
+	for key, restart := range lobby.Restarts {
+		if restart.Initiator != client && restart.Target != client {
+			continue
+		}
+		delete(lobby.Restarts, key)
+		if restart.Timer != nil {
+			restart.Timer.Stop()
+		}
+	}
+}
+
+// StartIceRestart records an ICE restart between two members of a lobby in a game on a server.
+// The IDs identify both members, using "relay" for the server relay. It returns false if the lobby
+// doesn't exist or a restart between the same members is already in progress.
+// It locks the server's Games map and the specific lobby's Mutex for thread safety.
+func StartIceRestart(s *structs.Server, lobbyid string, gameid string, a string, b string, restart *structs.IceRestart) bool {
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return false
+	}
+	s.Games.Mutex.Lock()
+	defer s.Games.Mutex.Unlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	if lobby.Restarts == nil {
+		lobby.Restarts = make(map[string]*structs.IceRestart)
+	}
+	key := restart_key(a, b)
+	if _, exists := lobby.Restarts[key]; exists {
+		return false
+	}
+	lobby.Restarts[key] = restart
+	return true
+}
+
+// FinishIceRestart removes the ICE restart between two members of a lobby in a game on a server
+// and stops its timeout. It returns the restart, or nil if no restart between them is in progress.
+// It locks the server's Games map and the specific lobby's Mutex for thread safety.
+func FinishIceRestart(s *structs.Server, lobbyid string, gameid string, a string, b string) *structs.IceRestart {
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return nil
+	}
+	s.Games.Mutex.Lock()
+	defer s.Games.Mutex.Unlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	key := restart_key(a, b)
+	restart, exists := lobby.Restarts[key]
+	if !exists {
+		return nil
+	}
+	delete(lobby.Restarts, key)
+	if restart.Timer != nil {
+		restart.Timer.Stop()
+	}
+	return restart
+}
@@ -0,0 +1,45 @@
+package manager
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+func TestLeavingCancelsIceRestarts(t *testing.T) {
+	s := &structs.Server{
+		Games:    &structs.GameStore{Games: make(map[string]*structs.Game)},
+		Sessions: &structs.SessionStore{Sessions: make(map[string]*structs.Session)},
+	}
+	a := &structs.Client{ID: "a", Mux: &sync.RWMutex{}}
+	b := &structs.Client{ID: "b", Mux: &sync.RWMutex{}}
+	c := &structs.Client{ID: "c", Mux: &sync.RWMutex{}}
+	for _, client := range []*structs.Client{a, b, c} {
+		if err := CreateSession(s, client); err != nil {
+			t.Fatal(err)
+		}
+		AddClientToLobby(s, "lobby", "game", client)
+	}
+
+	expired := false
+	timer := time.AfterFunc(time.Hour, func() { expired = true })
+	if !StartIceRestart(s, "lobby", "game", "a", "b", &structs.IceRestart{Initiator: a, Target: b, Timer: timer}) {
+		t.Fatal("the restart between a and b wasn't started")
+	}
+	if !StartIceRestart(s, "lobby", "game", "c", "relay", &structs.IceRestart{Initiator: c}) {
+		t.Fatal("the restart between c and the relay wasn't started")
+	}
+
+	RemoveClientFromLobby(s, "lobby", "game", b)
+	if timer.Stop() || expired {
+		t.Fatal("the timeout of the restart with the peer that left is still running")
+	}
+	if !StartIceRestart(s, "lobby", "game", "b", "a", &structs.IceRestart{Initiator: b, Target: a}) {
+		t.Fatal("the restart with the peer that left blocks a new one")
+	}
+	if StartIceRestart(s, "lobby", "game", "relay", "c", &structs.IceRestart{Initiator: c}) {
+		t.Fatal("the restart between members that stayed was cancelled")
+	}
+}
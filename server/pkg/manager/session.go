@@ -41,3 +41,21 @@ func DeleteSession(s *structs.Server, client *structs.Client) error {
 	delete(s.Sessions.Sessions, client.ID)
 	return nil
 }
+
+// GetAllSessions returns the client of every session on the server. The function is thread-safe.
+func GetAllSessions(s *structs.Server) []*structs.Client {
+	s.Sessions.Mutex.RLock()
+	defer s.Sessions.Mutex.RUnlock()
+	clients := make([]*structs.Client, 0, len(s.Sessions.Sessions))
+	for _, session := range s.Sessions.Sessions {
+		clients = append(clients, session.Client)
+	}
+	return clients
+}
+
+// CountSessions returns the number of sessions on the server. The function is thread-safe.
+func CountSessions(s *structs.Server) int {
+	s.Sessions.Mutex.RLock()
+	defer s.Sessions.Mutex.RUnlock()
+	return len(s.Sessions.Sessions)
+}
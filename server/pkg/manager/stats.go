@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// GetGameStats takes a snapshot of the sessions and lobbies of every game on a server, keyed by game ID.
+// It is meant for the metrics endpoint, so it takes the server's Games map lock once, rather than once per lobby.
+// It locks the server's Games map, each game's Mutex, and each lobby's Mutex for thread safety.
+func GetGameStats(s *structs.Server) map[string]*structs.GameStats {
+	s.Games.Mutex.RLock()
+	defer s.Games.Mutex.RUnlock()
+	stats := make(map[string]*structs.GameStats, len(s.Games.Games))
+	for gameid, game := range s.Games.Games {
+		game.Mutex.RLock()
+		entry := &structs.GameStats{
+			Sessions: len(game.Clients),
+		}
+		for lobbyid, lobby := range game.Lobbies {
+			if lobbyid == "default" {
+				continue
+			}
+			lobby.Mutex.RLock()
+			entry.Lobbies++
+			entry.Members += len(lobby.Clients)
+			lobby.Mutex.RUnlock()
+		}
+		game.Mutex.RUnlock()
+		stats[gameid] = entry
+	}
+	return stats
+}
+
+// GetRelayTraffic adds up the relay traffic of each game on a server, keyed by game ID, including
+// lobbies that have been destroyed. It also returns the traffic of games that have been removed,
+// so that the total never decreases.
+// It locks the server's Games map, each game's Mutex, and each lobby's Mutex for thread safety.
+func GetRelayTraffic(s *structs.Server) (map[string]*structs.RelayCounters, *structs.RelayCounters) {
+	s.Games.Mutex.RLock()
+	defer s.Games.Mutex.RUnlock()
+	traffic := make(map[string]*structs.RelayCounters, len(s.Games.Games))
+	for gameid, game := range s.Games.Games {
+		game.Mutex.RLock()
+		total := &structs.RelayCounters{}
+		total.Add(&game.Traffic)
+		for _, lobby := range game.Lobbies {
+			lobby.Mutex.RLock()
+			if lobby.Traffic != nil {
+				total.Add(lobby.Traffic)
+			}
+			lobby.Mutex.RUnlock()
+		}
+		game.Mutex.RUnlock()
+		traffic[gameid] = total
+	}
+	removed := &structs.RelayCounters{}
+	removed.Add(&s.Traffic)
+	return traffic, removed
+}
@@ -0,0 +1,41 @@
+package manager
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+func TestRelayTrafficOutlivesLobbies(t *testing.T) {
+	s := &structs.Server{
+		Games:    &structs.GameStore{Games: make(map[string]*structs.Game)},
+		Sessions: &structs.SessionStore{Sessions: make(map[string]*structs.Session)},
+	}
+	for _, lobby := range []string{"a", "b"} {
+		client := &structs.Client{ID: lobby, Mux: &sync.RWMutex{}}
+		if err := CreateSession(s, client); err != nil {
+			t.Fatal(err)
+		}
+		AddClientToLobby(s, lobby, "game", client)
+		GetLobbyTraffic(s, lobby, "game").BytesIn.Add(10)
+	}
+	if traffic, _ := GetRelayTraffic(s); traffic["game"].BytesIn.Load() != 20 {
+		t.Fatalf("the game's total is %d bytes, want 20", traffic["game"].BytesIn.Load())
+	}
+
+	DestroyLobby(s, "game", "a")
+	if traffic, _ := GetRelayTraffic(s); traffic["game"].BytesIn.Load() != 20 {
+		t.Fatalf("the game's total is %d bytes after a lobby was destroyed, want 20", traffic["game"].BytesIn.Load())
+	}
+
+	// Destroying the game's last lobby removes the game, and its traffic moves to the removed games
+	DestroyLobby(s, "game", "b")
+	traffic, removed := GetRelayTraffic(s)
+	if _, exists := traffic["game"]; exists {
+		t.Fatal("the game still has traffic after it was removed")
+	}
+	if removed.BytesIn.Load() != 20 {
+		t.Fatalf("the removed games' total is %d bytes, want 20", removed.BytesIn.Load())
+	}
+}
@@ -0,0 +1,97 @@
+package manager
+
+import (
+	"fmt"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/goccy/go-json"
+)
+
+// MaximumSharedValues is the number of global variables, and separately of global lists, that a
+// lobby's shared variable store may hold.
+const MaximumSharedValues = 128
+
+// MaximumSharedNameLength is the longest name a global variable or list may have, in bytes.
+const MaximumSharedNameLength = 128
+
+// MaximumSharedValueSize is the largest value a global variable or list may hold, in bytes of JSON.
+const MaximumSharedValueSize = 4096
+
+// GetLobbyStore retrieves the shared variable store of a lobby in a given game on the server.
+// It returns nil if the lobby does not exist.
+func GetLobbyStore(s *structs.Server, lobbyid string, gameid string) *structs.SharedStore {
+	if !DoesLobbyExist(s, lobbyid, gameid) {
+		return nil
+	}
+	s.Games.Mutex.RLock()
+	defer s.Games.Mutex.RUnlock()
+	lobby := get_lobby(s, gameid, lobbyid)
+	lobby.Mutex.RLock()
+	defer lobby.Mutex.RUnlock()
+	return lobby.Store
+}
+
+// SetSharedValue stores a global variable (or list, if list is true) in a shared variable store.
+// If the key is locked, only the peer that set it or the lobby host may change it, otherwise an
+// error is returned and the store is left untouched. The lock state of the key is only changed
+// if the variable specifies it. Names longer than MaximumSharedNameLength, values larger than
+// MaximumSharedValueSize, and new keys beyond MaximumSharedValues are rejected with an error.
+// The function is thread-safe.
+func SetSharedValue(store *structs.SharedStore, list bool, variable *structs.SharedVariable, setter *structs.Client, host bool) error {
+	if len(variable.Name) > MaximumSharedNameLength {
+		return fmt.Errorf("names must not be longer than %d bytes", MaximumSharedNameLength)
+	}
+	encoded, err := json.Marshal(variable.Value)
+	if err != nil {
+		return err
+	}
+	if len(encoded) > MaximumSharedValueSize {
+		return fmt.Errorf("%s is larger than %d bytes", variable.Name, MaximumSharedValueSize)
+	}
+
+	store.Mutex.Lock()
+	defer store.Mutex.Unlock()
+
+	values := store.Vars
+	if list {
+		values = store.Lists
+	}
+
+	current, exists := values[variable.Name]
+	if exists && current.Locked && current.Owner != setter.ID && !host {
+		return fmt.Errorf("%s is locked by %s", variable.Name, current.Owner)
+	}
+	if !exists && len(values) >= MaximumSharedValues {
+		return fmt.Errorf("lobbies may not hold more than %d global variables or lists each", MaximumSharedValues)
+	}
+
+	locked := exists && current.Locked
+	if variable.Lock != nil {
+		locked = *variable.Lock
+	}
+
+	values[variable.Name] = &structs.SharedValue{
+		Value:  variable.Value,
+		Owner:  setter.ID,
+		Locked: locked,
+	}
+	return nil
+}
+
+// GetStateSnapshot returns a copy of every global variable and list in a shared variable store.
+// The function is thread-safe.
+func GetStateSnapshot(store *structs.SharedStore) *structs.StateSnapshot {
+	store.Mutex.RLock()
+	defer store.Mutex.RUnlock()
+	snapshot := &structs.StateSnapshot{
+		Vars:  make(map[string]structs.SharedValue, len(store.Vars)),
+		Lists: make(map[string]structs.SharedValue, len(store.Lists)),
+	}
+	for name, value := range store.Vars {
+		snapshot.Vars[name] = *value
+	}
+	for name, value := range store.Lists {
+		snapshot.Lists[name] = *value
+	}
+	return snapshot
+}
@@ -0,0 +1,42 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+func TestSharedStoreLimits(t *testing.T) {
+	store := new_store()
+	setter := &structs.Client{ID: "peer", Mux: &sync.RWMutex{}}
+
+	for i := 0; i < MaximumSharedValues; i++ {
+		if err := SetSharedValue(store, false, &structs.SharedVariable{Name: fmt.Sprint(i), Value: i}, setter, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SetSharedValue(store, false, &structs.SharedVariable{Name: "one too many", Value: 1}, setter, false); err == nil {
+		t.Fatalf("a variable beyond the first %d was stored", MaximumSharedValues)
+	}
+	if err := SetSharedValue(store, false, &structs.SharedVariable{Name: "0", Value: "changed"}, setter, false); err != nil {
+		t.Fatalf("changing a stored variable of a full store was rejected: %v", err)
+	}
+	if err := SetSharedValue(store, true, &structs.SharedVariable{Name: "list", Value: []int{1, 2}}, setter, false); err != nil {
+		t.Fatalf("lists are limited by the number of variables: %v", err)
+	}
+
+	for _, variable := range []*structs.SharedVariable{
+		{Name: strings.Repeat("a", MaximumSharedNameLength+1), Value: 1},
+		{Name: "large", Value: strings.Repeat("a", MaximumSharedValueSize)},
+	} {
+		if err := SetSharedValue(store, true, variable, setter, false); err == nil {
+			t.Fatalf("%.16s... was stored", variable.Name)
+		}
+	}
+	if len(store.Lists) != 1 {
+		t.Fatalf("the store holds %d lists, want 1", len(store.Lists))
+	}
+}
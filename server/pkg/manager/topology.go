@@ -0,0 +1,45 @@
+package manager
+
+import (
+	"slices"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// DefaultMeshSize is how many members form the mesh of a hybrid lobby that doesn't set mesh_size.
+const DefaultMeshSize = 8
+
+// LobbyTopology returns the topology of a lobby with the given settings, defaulting to a full mesh.
+func LobbyTopology(settings *structs.LobbySettings) string {
+	if settings == nil || settings.Topology == "" {
+		return structs.MESH_TOPOLOGY
+	}
+	return settings.Topology
+}
+
+// ShouldConnect checks if two members of a lobby should connect to each other over WebRTC, given
+// the lobby's settings, its host, and its members in the order they joined. In a hybrid lobby,
+// the members that joined first form a mesh, and the others only connect to the host.
+func ShouldConnect(settings *structs.LobbySettings, host *structs.Client, members []*structs.Client, a *structs.Client, b *structs.Client) bool {
+	if a == b {
+		return false
+	}
+	switch LobbyTopology(settings) {
+	case structs.RELAY_TOPOLOGY:
+		return false
+	case structs.STAR_TOPOLOGY:
+		return a == host || b == host
+	case structs.HYBRID_TOPOLOGY:
+		if a == host || b == host {
+			return true
+		}
+		size := settings.MeshSize
+		if size == 0 {
+			size = DefaultMeshSize
+		}
+		i, j := slices.Index(members, a), slices.Index(members, b)
+		return i != -1 && j != -1 && i < size && j < size
+	default:
+		return true
+	}
+}
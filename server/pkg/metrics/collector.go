@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MaximumGames is the number of games, with the most sessions, that have their own series. Game
+// IDs are chosen by clients, so the rest are added up under the "other" game to bound cardinality.
+const MaximumGames = 50
+
+var (
+	sessionsDesc = prometheus.NewDesc("phi_sessions", "Clients connected to the server, by game.", []string{"ugi"}, nil)
+	lobbiesDesc  = prometheus.NewDesc("phi_lobbies", "Open lobbies, by game.", []string{"ugi"}, nil)
+	membersDesc  = prometheus.NewDesc("phi_lobby_members", "Clients in a lobby, including hosts, by game.", []string{"ugi"}, nil)
+	relaysDesc   = prometheus.NewDesc("phi_relays", "Server relays, by game.", []string{"ugi"}, nil)
+
+	relayMessagesDesc  = prometheus.NewDesc("phi_relay_messages_total", "Messages exchanged by server relays, by game and direction.", []string{"ugi", "direction"}, nil)
+	relayBytesDesc     = prometheus.NewDesc("phi_relay_bytes_total", "Bytes exchanged by server relays, by game and direction.", []string{"ugi", "direction"}, nil)
+	relayThrottledDesc = prometheus.NewDesc("phi_relay_throttled_total", "Messages dropped by server relays for exceeding their lobby's limits, by game.", []string{"ugi"}, nil)
+)
+
+// collector reports the state of the server's games, lobbies and relays when the metrics are
+// scraped, so that the hot paths don't have to keep gauges up to date under the server's locks.
+type collector struct {
+	server *structs.Server
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sessionsDesc
+	ch <- lobbiesDesc
+	ch <- membersDesc
+	ch <- relaysDesc
+	ch <- relayMessagesDesc
+	ch <- relayBytesDesc
+	ch <- relayThrottledDesc
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	relays := make(map[string]int)
+	for _, relay := range manager.GetAllRelays(c.server) {
+		relays[relay.UGI]++
+	}
+
+	traffic, removed := manager.GetRelayTraffic(c.server)
+	series := top_games(manager.GetGameStats(c.server), relays, traffic)
+
+	// The traffic of games that have been removed is kept under "other", so that totals never decrease
+	if series["other"] == nil {
+		series["other"] = &game_series{}
+	}
+	series["other"].Traffic.Add(removed)
+
+	for ugi, game := range series {
+		ch <- prometheus.MustNewConstMetric(sessionsDesc, prometheus.GaugeValue, float64(game.Sessions), ugi)
+		ch <- prometheus.MustNewConstMetric(lobbiesDesc, prometheus.GaugeValue, float64(game.Lobbies), ugi)
+		ch <- prometheus.MustNewConstMetric(membersDesc, prometheus.GaugeValue, float64(game.Members), ugi)
+		ch <- prometheus.MustNewConstMetric(relaysDesc, prometheus.GaugeValue, float64(game.Relays), ugi)
+		ch <- prometheus.MustNewConstMetric(relayMessagesDesc, prometheus.CounterValue, float64(game.Traffic.MessagesIn.Load()), ugi, "in")
+		ch <- prometheus.MustNewConstMetric(relayMessagesDesc, prometheus.CounterValue, float64(game.Traffic.MessagesOut.Load()), ugi, "out")
+		ch <- prometheus.MustNewConstMetric(relayBytesDesc, prometheus.CounterValue, float64(game.Traffic.BytesIn.Load()), ugi, "in")
+		ch <- prometheus.MustNewConstMetric(relayBytesDesc, prometheus.CounterValue, float64(game.Traffic.BytesOut.Load()), ugi, "out")
+		ch <- prometheus.MustNewConstMetric(relayThrottledDesc, prometheus.CounterValue, float64(game.Traffic.Throttled.Load()), ugi)
+	}
+}
+
+// game_series holds the values reported for a game, or for the games added up under "other".
+type game_series struct {
+	structs.GameStats
+	Relays  int
+	Traffic structs.RelayCounters
+}
+
+// top_games keeps the MaximumGames games with the most sessions, and adds up the rest under "other".
+// Games that only have traffic, because they were created after the stats were taken, are added
+// up under "other" too.
+func top_games(stats map[string]*structs.GameStats, relays map[string]int, traffic map[string]*structs.RelayCounters) map[string]*game_series {
+	ugis := slices.Collect(maps.Keys(stats))
+	slices.SortFunc(ugis, func(a, b string) int {
+		return cmp.Or(stats[b].Sessions-stats[a].Sessions, strings.Compare(a, b))
+	})
+	series := make(map[string]*game_series, min(len(ugis), MaximumGames+1))
+	for i, ugi := range ugis {
+		name := ugi
+		if i >= MaximumGames {
+			name = "other"
+		}
+		entry, exists := series[name]
+		if !exists {
+			entry = &game_series{}
+			series[name] = entry
+		}
+		entry.Sessions += stats[ugi].Sessions
+		entry.Lobbies += stats[ugi].Lobbies
+		entry.Members += stats[ugi].Members
+		entry.Relays += relays[ugi]
+	}
+	for ugi, counters := range traffic {
+		entry, exists := series[ugi]
+		if !exists {
+			if series["other"] == nil {
+				series["other"] = &game_series{}
+			}
+			entry = series["other"]
+		}
+		entry.Traffic.Add(counters)
+	}
+	return series
+}
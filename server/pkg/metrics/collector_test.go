@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+func TestTopGamesCapsCardinality(t *testing.T) {
+	stats := make(map[string]*structs.GameStats)
+	relays := make(map[string]int)
+	traffic := make(map[string]*structs.RelayCounters)
+	for i := 0; i < MaximumGames+10; i++ {
+		ugi := fmt.Sprintf("game-%03d", i)
+		stats[ugi] = &structs.GameStats{Sessions: 1000 - i, Lobbies: 1, Members: 2}
+		relays[ugi] = 1
+		traffic[ugi] = &structs.RelayCounters{}
+		traffic[ugi].BytesIn.Store(100)
+	}
+	// A game that was created after the stats were taken
+	traffic["new-game"] = &structs.RelayCounters{}
+	traffic["new-game"].BytesIn.Store(5)
+
+	series := top_games(stats, relays, traffic)
+	if len(series) != MaximumGames+1 {
+		t.Fatalf("%d games have series, want %d", len(series), MaximumGames+1)
+	}
+	if _, exists := series["game-000"]; !exists {
+		t.Fatal("the game with the most sessions doesn't have its own series")
+	}
+	if _, exists := series[fmt.Sprintf("game-%03d", MaximumGames)]; exists {
+		t.Fatal("a game outside the top games has its own series")
+	}
+	other := series["other"]
+	if other == nil || other.Lobbies != 10 || other.Members != 20 || other.Relays != 10 {
+		t.Fatalf("the other games add up to %+v, want 10 lobbies, 20 members and 10 relays", other)
+	}
+	if bytes := series["game-000"].Traffic.BytesIn.Load(); bytes != 100 {
+		t.Fatalf("the game with the most sessions received %d bytes, want 100", bytes)
+	}
+	if bytes := other.Traffic.BytesIn.Load(); bytes != 1005 {
+		t.Fatalf("the other games received %d bytes, want 1005", bytes)
+	}
+}
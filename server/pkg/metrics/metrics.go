@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds the server's metrics. It is served by Handler.
+var Registry = prometheus.NewRegistry()
+
+var (
+	received = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "phi",
+		Subsystem: "signaling",
+		Name:      "opcodes_received_total",
+		Help:      "Signaling packets received, by opcode. Unknown opcodes are counted as \"unknown\".",
+	}, []string{"opcode"})
+
+	sent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "phi",
+		Subsystem: "signaling",
+		Name:      "opcodes_sent_total",
+		Help:      "Signaling packets sent, by opcode.",
+	}, []string{"opcode"})
+
+	violations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "phi",
+		Subsystem: "signaling",
+		Name:      "violations_total",
+		Help:      "VIOLATION packets sent to clients.",
+	})
+
+	latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "phi",
+		Subsystem: "signaling",
+		Name:      "handler_duration_seconds",
+		Help:      "Time taken to handle a signaling packet, by opcode.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"opcode"})
+
+	size = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "phi",
+		Subsystem: "signaling",
+		Name:      "message_size_bytes",
+		Help:      "Size of signaling messages, by direction.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"direction"})
+)
+
+// Register registers the server's metrics, along with the Go runtime and process metrics.
+// It must only be called once.
+func Register(s *structs.Server) {
+	Registry.MustRegister(
+		received,
+		sent,
+		violations,
+		latency,
+		size,
+		&collector{server: s},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the server's metrics in the Prometheus text format.
+func Handler() fiber.Handler {
+	return adaptor.HTTPHandler(HTTPHandler())
+}
+
+// HTTPHandler serves the server's metrics in the Prometheus text format, for listeners that
+// don't use fiber.
+func HTTPHandler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Received counts a signaling packet received from a client.
+func Received(opcode string, bytes int) {
+	received.WithLabelValues(opcode).Inc()
+	size.WithLabelValues("in").Observe(float64(bytes))
+}
+
+// Sent counts a signaling packet sent to a client.
+func Sent(opcode string, bytes int) {
+	sent.WithLabelValues(opcode).Inc()
+	size.WithLabelValues("out").Observe(float64(bytes))
+	if opcode == "VIOLATION" {
+		violations.Inc()
+	}
+}
+
+// Violated counts a VIOLATION packet that was written to a client without going through the message package.
+func Violated() {
+	sent.WithLabelValues("VIOLATION").Inc()
+	violations.Inc()
+}
+
+// Handled records how long it took to handle a signaling packet that was received at the given time.
+func Handled(opcode string, started time.Time) {
+	latency.WithLabelValues(opcode).Observe(time.Since(started).Seconds())
+}
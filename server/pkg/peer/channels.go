@@ -0,0 +1,126 @@
+package peer
+
+import (
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/pion/webrtc/v4"
+)
+
+// GetChannel returns the data channel of the relay with the given label, if it exists.
+func GetChannel(r *structs.Relay, label string) (*webrtc.DataChannel, bool) {
+	r.Mux.RLock()
+	defer r.Mux.RUnlock()
+	d, exists := r.Channels[label]
+	return d, exists
+}
+
+// addChannel registers a data channel with the relay and attaches the channel handler.
+// If a channel with the same label already exists, it is replaced. The channel's wire
+// encoding is taken from its protocol.
+func addChannel(r *structs.Relay, d *webrtc.DataChannel) {
+	r.Mux.Lock()
+	r.Channels[d.Label()] = d
+	r.Encodings[d.Label()] = ChannelEncoding(d)
+	r.Mux.Unlock()
+	channelhandler(r, d)
+}
+
+// removeChannel unregisters a data channel from the relay, unless it has since been replaced.
+func removeChannel(r *structs.Relay, d *webrtc.DataChannel) {
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+	if r.Channels[d.Label()] == d {
+		delete(r.Channels, d.Label())
+		delete(r.Encodings, d.Label())
+	}
+}
+
+// channelInfo describes a data channel's label, ordering and reliability settings.
+func channelInfo(d *webrtc.DataChannel) *structs.RelayChannelInfo {
+	return &structs.RelayChannelInfo{
+		Label:             d.Label(),
+		Ordered:           d.Ordered(),
+		MaxRetransmits:    d.MaxRetransmits(),
+		MaxPacketLifeTime: d.MaxPacketLifeTime(),
+		Protocol:          d.Protocol(),
+	}
+}
+
+// createChannel creates a data channel on the relay using the given settings, unless a channel
+// with the same label already exists. It returns true if a channel was created.
+func createChannel(r *structs.Relay, info *structs.RelayChannelInfo) bool {
+	r.Mux.Lock()
+	if _, exists := r.Channels[info.Label]; exists {
+		r.Mux.Unlock()
+		return false
+	}
+	ordered := info.Ordered
+	protocol := info.Protocol
+	d, err := r.Conn.CreateDataChannel(info.Label, &webrtc.DataChannelInit{
+		Ordered:           &ordered,
+		MaxRetransmits:    info.MaxRetransmits,
+		MaxPacketLifeTime: info.MaxPacketLifeTime,
+		Protocol:          &protocol,
+	})
+	if err != nil {
+		r.Mux.Unlock()
+		logging.ForRelay(r).Warn("Relay failed to create data channel", "channel", info.Label, "error", err)
+		return false
+	}
+	r.Channels[info.Label] = d
+	r.Encodings[info.Label] = ChannelEncoding(d)
+	r.Mux.Unlock()
+
+	channelhandler(r, d)
+	return true
+}
+
+// mirrorChannel creates a data channel that a peer opened with its relay on every other relay
+// in the lobby, and tells the other peers about it using the NEW_CHAN opcode.
+func mirrorChannel(r *structs.Relay, d *webrtc.DataChannel) {
+	info := channelInfo(d)
+	for _, other := range manager.WithoutRelay(manager.GetRelayPeers(r.Server, r.Lobby, r.UGI), r) {
+		if !createChannel(other, info) {
+			continue
+		}
+		Code(
+			other,
+			"NEW_CHAN",
+			"default",
+			info,
+			&structs.PeerInfo{
+				ID:   r.Peer.ID,
+				User: r.Peer.Username,
+			},
+		)
+	}
+}
+
+// syncChannels creates every data channel that other relays in the lobby have on the relay,
+// so that peers joining a lobby late can use channels that were opened before they joined.
+func syncChannels(r *structs.Relay) {
+	for _, other := range manager.WithoutRelay(manager.GetRelayPeers(r.Server, r.Lobby, r.UGI), r) {
+		other.Mux.RLock()
+		channels := make([]*webrtc.DataChannel, 0, len(other.Channels))
+		for _, d := range other.Channels {
+			channels = append(channels, d)
+		}
+		other.Mux.RUnlock()
+
+		for _, d := range channels {
+			if createChannel(r, channelInfo(d)) {
+				Code(
+					r,
+					"NEW_CHAN",
+					"default",
+					channelInfo(d),
+					&structs.PeerInfo{
+						ID:   other.Peer.ID,
+						User: other.Peer.Username,
+					},
+				)
+			}
+		}
+	}
+}
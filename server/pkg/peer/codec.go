@@ -0,0 +1,235 @@
+package peer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/goccy/go-json"
+	"github.com/oklog/ulid/v2"
+	"github.com/pion/webrtc/v4"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Wire encodings supported by the relay. Peers pick an encoding for a data channel by opening it
+// with a protocol or label ending in "+msgpack". The encoding is fixed for the channel's lifetime.
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+)
+
+// MaximumFragmentSize is the largest data channel message the relay sends. Larger messages are
+// split into FRAG packets. This stays well below the SCTP message size limit of every browser.
+const MaximumFragmentSize = 16 * 1024
+
+// MaximumMessageSize is the largest message the relay accepts or sends, after reassembly.
+const MaximumMessageSize = 1024 * 1024
+
+// MaximumPendingMessages is the number of fragmented messages a peer may have in flight at once.
+const MaximumPendingMessages = 16
+
+// FragmentTimeout is how long the relay waits for the rest of a fragmented message.
+const FragmentTimeout = 30 * time.Second
+
+// Encode marshals a message using the given wire encoding.
+func Encode(encoding string, message interface{}) ([]byte, error) {
+	if encoding != EncodingMsgpack {
+		// Marshal the message using go-json instead of interface/json
+		return json.Marshal(message)
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(message); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decode unmarshals a message using the given wire encoding.
+func decode(encoding string, data []byte, message interface{}) error {
+	if encoding != EncodingMsgpack {
+		return json.Unmarshal(data, message)
+	}
+
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(message)
+}
+
+// ChannelEncoding returns the wire encoding requested by a data channel's protocol or label.
+func ChannelEncoding(d *webrtc.DataChannel) string {
+	if strings.HasSuffix(d.Protocol(), "+msgpack") || strings.HasSuffix(d.Label(), "+msgpack") {
+		return EncodingMsgpack
+	}
+	return EncodingJSON
+}
+
+// getEncoding returns the wire encoding used by the relay's data channel.
+func getEncoding(r *structs.Relay, channel string) string {
+	r.Mux.RLock()
+	defer r.Mux.RUnlock()
+	if encoding, exists := r.Encodings[channel]; exists {
+		return encoding
+	}
+	return EncodingJSON
+}
+
+// write sends an encoded message over a data channel, as text for JSON and as binary otherwise.
+func write(d *webrtc.DataChannel, encoding string, data []byte) error {
+	if encoding == EncodingMsgpack {
+		return d.Send(data)
+	}
+	return d.SendText(string(data))
+}
+
+// writeFragments splits an encoded message into FRAG packets and sends them over a data channel.
+func writeFragments(d *webrtc.DataChannel, encoding string, data []byte) error {
+
+	// Leave room for the FRAG envelope, and for base64 expansion when using JSON.
+	size := MaximumFragmentSize - 256
+	if encoding == EncodingJSON {
+		size = size * 3 / 4
+	}
+
+	id := ulid.Make().String()
+	total := (len(data) + size - 1) / size
+	for i := 0; i < total; i++ {
+		chunk := data[i*size : min((i+1)*size, len(data))]
+		frame, err := Encode(encoding, &structs.RelayPacket{
+			Opcode: "FRAG",
+			Payload: &structs.RelayFragment{
+				ID:    id,
+				Index: i,
+				Total: total,
+				Data:  chunk,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if err := write(d, encoding, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reassemble stores a fragment of a message. Once every fragment has arrived, it returns the
+// complete message and true. Stale messages are discarded, and an error is returned if the
+// message would exceed MaximumMessageSize or the peer has too many messages in flight.
+func reassemble(r *structs.Relay, channel string, fragment *structs.RelayFragment) ([]byte, bool, error) {
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+
+	// Discard messages that were never completed
+	for key, pending := range r.Fragments {
+		if time.Since(pending.Started) > FragmentTimeout {
+			delete(r.Fragments, key)
+		}
+	}
+
+	key := channel + "/" + fragment.ID
+	pending, exists := r.Fragments[key]
+	if !exists {
+		if len(r.Fragments) >= MaximumPendingMessages {
+			return nil, false, fmt.Errorf("too many fragmented messages in flight")
+		}
+		if fragment.Total > MaximumMessageSize/MaximumFragmentSize*2 {
+			return nil, false, fmt.Errorf("message exceeds the maximum size of %d bytes", MaximumMessageSize)
+		}
+		pending = &structs.RelayFragments{
+			Parts:   make([][]byte, fragment.Total),
+			Started: time.Now(),
+		}
+		r.Fragments[key] = pending
+	}
+
+	if fragment.Total != len(pending.Parts) {
+		delete(r.Fragments, key)
+		return nil, false, fmt.Errorf("fragment %d of message %s has an inconsistent total", fragment.Index, fragment.ID)
+	}
+	if pending.Parts[fragment.Index] != nil {
+		return nil, false, nil
+	}
+
+	pending.Size += len(fragment.Data)
+	if pending.Size > MaximumMessageSize {
+		delete(r.Fragments, key)
+		return nil, false, fmt.Errorf("message exceeds the maximum size of %d bytes", MaximumMessageSize)
+	}
+	pending.Parts[fragment.Index] = fragment.Data
+	pending.Received++
+	if pending.Received < len(pending.Parts) {
+		return nil, false, nil
+	}
+
+	delete(r.Fragments, key)
+	return bytes.Join(pending.Parts, nil), true, nil
+}
+
+// receive decodes a data channel message using the channel's framing, reassembles
+// fragmented messages, and hands complete packets to the protocol handler.
+func receive(r *structs.Relay, channel string, msg webrtc.DataChannelMessage) {
+	if len(msg.Data) > MaximumMessageSize {
+		Code(r, "WARN", channel, fmt.Sprintf("Message exceeds the maximum size of %d bytes", MaximumMessageSize), nil)
+		return
+	}
+
+	// Enforce the lobby's rate limits
+	if !allow(r, channel, len(msg.Data)) {
+		return
+	}
+
+	// Reject frames that don't match the channel's framing, JSON is sent as text and MessagePack as binary
+	encoding := getEncoding(r, channel)
+	if msg.IsString != (encoding == EncodingJSON) {
+		Code(r, "WARN", channel, fmt.Sprintf("Channel %s uses %s framing", channel, encoding), nil)
+		return
+	}
+
+	// Parse the message
+	var packet structs.RelayPacket
+	if err := decode(encoding, msg.Data, &packet); err != nil {
+		logging.ForRelay(r).Warn("Relay failed to parse message", "channel", channel, "error", err)
+		return
+	}
+
+	if packet.Opcode != "FRAG" {
+		protocolhandler(r, channel, &packet)
+		return
+	}
+
+	// Read the message as a fragment
+	fragment := &structs.RelayFragmentPacket{}
+	if err := decode(encoding, msg.Data, fragment); err != nil {
+		logging.ForRelay(r).Warn("Relay failed to parse fragment", "channel", channel, "error", err)
+		return
+	}
+	if err := r.Server.PacketValidator.Struct(fragment); err != nil {
+		Code(r, "WARN", channel, err.Error(), nil)
+		return
+	}
+
+	data, done, err := reassemble(r, channel, fragment.Payload)
+	if err != nil {
+		Code(r, "WARN", channel, err.Error(), nil)
+		return
+	}
+	if !done {
+		return
+	}
+
+	// Parse the reassembled message
+	packet = structs.RelayPacket{}
+	if err := decode(encoding, data, &packet); err != nil {
+		logging.ForRelay(r).Warn("Relay failed to parse reassembled message", "channel", channel, "error", err)
+		return
+	}
+	protocolhandler(r, channel, &packet)
+}
@@ -0,0 +1,247 @@
+package peer
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/stun"
+	"github.com/pion/webrtc/v4"
+)
+
+// DiagnoseTimeout is how long a DIAGNOSE test connection has to open its data channel.
+const DiagnoseTimeout = 15 * time.Second
+
+// DiagnoseSettle is how long the server waits after the test data channel opens, so that ICE
+// keepalives can measure the round trip time of the selected candidate pair.
+const DiagnoseSettle = 3 * time.Second
+
+// Diagnose starts a test connection between the server and a client. The client is sent
+// DIAGNOSE_START with the ICE servers to use, followed by a MAKE_OFFER of type
+// DIAGNOSTIC_CANDIDATE. Once the test data channel opens or DiagnoseTimeout passes, the client
+// is sent a DIAGNOSE_REPORT describing how the connection was made, and the test connection is closed.
+func Diagnose(s *structs.Server, client *structs.Client, listener string) error {
+	conn, err := webrtc.NewPeerConnection(configuration(s))
+	if err != nil {
+		return err
+	}
+	diagnostic := &structs.Diagnostic{
+		Conn:    conn,
+		Opened:  make(chan bool),
+		Started: time.Now(),
+	}
+	if !manager.SetDiagnostic(s, client, diagnostic) {
+		conn.Close()
+		return fmt.Errorf("a diagnostic test is already running")
+	}
+
+	// Create the test data channel
+	yes := true
+	zero := uint16(0)
+	d, err := conn.CreateDataChannel("diagnostics", &webrtc.DataChannelInit{
+		Negotiated: &yes,
+		ID:         &zero,
+		Ordered:    &yes,
+	})
+	if err != nil {
+		manager.DeleteDiagnostic(s, client)
+		conn.Close()
+		return err
+	}
+	d.OnOpen(func() {
+		close(diagnostic.Opened)
+	})
+
+	// Trickle the server's candidates to the client
+	conn.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+		message.Code(
+			client,
+			"ICE",
+			&structs.RelayOutboundIce{
+				Type:     structs.DIAGNOSTIC_CANDIDATE,
+				Contents: c,
+			},
+			"",
+			&structs.PeerInfo{
+				ID:   "diagnostics",
+				User: "diagnostics",
+			},
+		)
+	})
+
+	offer, err := conn.CreateOffer(nil)
+	if err == nil {
+		err = conn.SetLocalDescription(offer)
+	}
+	if err != nil {
+		manager.DeleteDiagnostic(s, client)
+		conn.Close()
+		return err
+	}
+
+	// Tell the client how to set up its side of the test
+	servers := []webrtc.ICEServer{}
+	if s.STUN != nil {
+		servers = append(servers, webrtc.ICEServer{URLs: s.STUN.URLs})
+	}
+	message.Code(
+		client,
+		"DIAGNOSE_START",
+		&structs.DiagnoseStart{
+			ICEServers: append(servers, configuration(s).ICEServers...),
+			Timeout:    int(DiagnoseTimeout / time.Millisecond),
+		},
+		listener,
+		nil,
+	)
+	message.Code(
+		client,
+		"MAKE_OFFER",
+		&structs.RelayCandidate{
+			Type:     structs.DIAGNOSTIC_CANDIDATE,
+			Contents: conn.LocalDescription(),
+		},
+		"",
+		&structs.PeerInfo{
+			ID:   "diagnostics",
+			User: "diagnostics",
+		},
+	)
+
+	go diagnose(s, client, diagnostic, listener)
+	return nil
+}
+
+// diagnose waits for a test connection to open, then reports on it and closes it.
+func diagnose(s *structs.Server, client *structs.Client, diagnostic *structs.Diagnostic, listener string) {
+	defer manager.DeleteDiagnostic(s, client)
+	defer diagnostic.Conn.Close()
+
+	report := &structs.DiagnosticReport{}
+	select {
+	case <-diagnostic.Opened:
+		report.Connected = true
+		time.Sleep(DiagnoseSettle)
+	case <-time.After(DiagnoseTimeout):
+		report.Error = "The test connection did not open in time"
+	}
+
+	inspect(s, diagnostic, report)
+	report.Duration = float64(time.Since(diagnostic.Started)) / float64(time.Millisecond)
+	logging.WithClient(logging.Relay(), client).Info("Diagnostics finished", "connected", report.Connected, "local", report.LocalCandidate, "remote", report.RemoteCandidate, "nat", report.NAT)
+
+	message.Code(
+		client,
+		"DIAGNOSE_REPORT",
+		report,
+		listener,
+		nil,
+	)
+}
+
+// inspect fills in a report from the statistics of a test connection and the observations of
+// the server's STUN responder.
+func inspect(s *structs.Server, diagnostic *structs.Diagnostic, report *structs.DiagnosticReport) {
+	report.Gathered = []string{}
+	report.Succeeded = []string{}
+	report.NAT = stun.MappingUnknown
+
+	stats := diagnostic.Conn.GetStats()
+	candidates := make(map[string]webrtc.ICECandidateStats)
+	for _, stat := range stats {
+		if candidate, ok := stat.(webrtc.ICECandidateStats); ok {
+			candidates[candidate.ID] = candidate
+			if candidate.Type == webrtc.StatsTypeRemoteCandidate && !slices.Contains(report.Gathered, candidate.CandidateType.String()) {
+				report.Gathered = append(report.Gathered, candidate.CandidateType.String())
+			}
+		}
+	}
+
+	// Find the candidate types that connected, and whether any of them avoided TURN
+	direct := false
+	for _, stat := range stats {
+		pair, ok := stat.(webrtc.ICECandidatePairStats)
+		if !ok || pair.State != webrtc.StatsICECandidatePairStateSucceeded {
+			continue
+		}
+		local, remote := candidates[pair.LocalCandidateID], candidates[pair.RemoteCandidateID]
+		if !slices.Contains(report.Succeeded, remote.CandidateType.String()) {
+			report.Succeeded = append(report.Succeeded, remote.CandidateType.String())
+		}
+		if local.CandidateType != webrtc.ICECandidateTypeRelay && remote.CandidateType != webrtc.ICECandidateTypeRelay {
+			direct = true
+		}
+		if pair.Nominated && pair.CurrentRoundTripTime > 0 {
+			report.RTT = pair.CurrentRoundTripTime * 1000
+		}
+	}
+
+	// Describe the selected candidate pair
+	if sctp := diagnostic.Conn.SCTP(); sctp != nil && sctp.Transport() != nil {
+		pair, err := sctp.Transport().ICETransport().GetSelectedCandidatePair()
+		if err == nil && pair != nil {
+			report.LocalCandidate = pair.Local.Typ.String()
+			report.RemoteCandidate = pair.Remote.Typ.String()
+			report.TURNRequired = !direct && (pair.Local.Typ == webrtc.ICECandidateTypeRelay || pair.Remote.Typ == webrtc.ICECandidateTypeRelay)
+			if pair.Remote.Typ != webrtc.ICECandidateTypeRelay {
+				report.MappedAddress = fmt.Sprintf("%s:%d", pair.Remote.Address, pair.Remote.Port)
+			}
+		}
+	}
+
+	// Classify the client's NAT using the requests its server reflexive candidates made to the responder
+	if s.STUN == nil {
+		return
+	}
+	for _, candidate := range candidates {
+		if candidate.Type != webrtc.StatsTypeRemoteCandidate || candidate.CandidateType != webrtc.ICECandidateTypeSrflx {
+			continue
+		}
+		observations := s.STUN.Observed(candidate.IP, diagnostic.Started)
+		if nat := stun.Classify(observations); nat != stun.MappingUnknown {
+			report.NAT = nat
+			report.MappedAddress = fmt.Sprintf("%s:%d", candidate.IP, candidate.Port)
+			return
+		}
+	}
+}
+
+// HandleDiagnosticAnswer applies a client's answer to its diagnostic test connection.
+func HandleDiagnosticAnswer(s *structs.Server, client *structs.Client, answer *webrtc.SessionDescription) error {
+	diagnostic := manager.GetDiagnostic(s, client)
+	if diagnostic == nil {
+		return fmt.Errorf("no diagnostic test is running")
+	}
+	return diagnostic.Conn.SetRemoteDescription(*answer)
+}
+
+// HandleDiagnosticIce adds a client's ICE candidate to its diagnostic test connection.
+func HandleDiagnosticIce(s *structs.Server, client *structs.Client, ice *webrtc.ICECandidateInit) error {
+	diagnostic := manager.GetDiagnostic(s, client)
+	if diagnostic == nil {
+		return fmt.Errorf("no diagnostic test is running")
+	}
+	return diagnostic.Conn.AddICECandidate(*ice)
+}
+
+// SelfCheck checks that the server can create relay peer connections, by creating one with a
+// data channel and an offer, and closing it again. ICE candidates aren't gathered.
+func SelfCheck(s *structs.Server) error {
+	conn, err := webrtc.NewPeerConnection(configuration(s))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.CreateDataChannel("health", nil); err != nil {
+		return err
+	}
+	_, err = conn.CreateOffer(nil)
+	return err
+}
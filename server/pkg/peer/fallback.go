@@ -0,0 +1,109 @@
+package peer
+
+import (
+	"fmt"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/goccy/go-json"
+)
+
+// IsFallback checks if the relay exchanges packets with its peer over the signaling websocket.
+func IsFallback(r *structs.Relay) bool {
+	r.Mux.RLock()
+	defer r.Mux.RUnlock()
+	return r.Fallback
+}
+
+// setFallback switches the relay between WebRTC and the signaling websocket. It returns true
+// if the relay wasn't already using the requested transport.
+func setFallback(r *structs.Relay, fallback bool) bool {
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+	changed := r.Fallback != fallback
+	r.Fallback = fallback
+	return changed
+}
+
+// EnableFallback switches the relay over to the signaling websocket. The first time it is
+// enabled, the peer is sent the lobby's global state and buffered frames, as it would be
+// when its default data channel opens.
+func EnableFallback(r *structs.Relay) {
+	if !setFallback(r, true) {
+		return
+	}
+	logging.ForRelay(r).Info("Relay falling back to the signaling websocket")
+	catchUp(r)
+}
+
+// catchUp sends the peer the lobby's global state and the frames buffered by its tick loop,
+// so that peers joining a lobby late can catch up.
+func catchUp(r *structs.Relay) {
+	if err := SendState(r, "default"); err != nil {
+		logging.ForRelay(r).Warn("Relay failed to send state snapshot", "error", err)
+	}
+	if manager.GetLobbyTicker(r.Server, r.Lobby, r.UGI) != nil {
+		if err := SendTicks(r, "default"); err != nil {
+			logging.ForRelay(r).Warn("Relay failed to send buffered frames", "error", err)
+		}
+	}
+}
+
+// ReceiveSocket handles a relay packet that the peer sent over the signaling websocket using
+// the WS_RELAY opcode. The relay falls back to the websocket for the rest of the session, and
+// the packet is handled as if it arrived on the given data channel, which must be "default" or
+// a channel the relay has registered.
+func ReceiveSocket(r *structs.Relay, channel string, packet *structs.RelayPacket) error {
+	defer guard(r)
+	if channel == "" {
+		channel = "default"
+	}
+
+	// Fragments aren't needed over the websocket
+	if packet.Opcode == "FRAG" {
+		return fmt.Errorf("fragmented messages can't be relayed over the signaling websocket")
+	}
+
+	// Only channels the relay has registered are limited, so that peers can't get a new token
+	// bucket by making up channel names
+	if _, exists := GetChannel(r, channel); !exists && channel != "default" {
+		return fmt.Errorf("the relay has no channel %s", channel)
+	}
+
+	data, err := json.Marshal(packet)
+	if err != nil {
+		return err
+	}
+	if len(data) > MaximumMessageSize {
+		return fmt.Errorf("message exceeds the maximum size of %d bytes", MaximumMessageSize)
+	}
+	EnableFallback(r)
+
+	// Enforce the lobby's rate limits
+	if !allow(r, channel, len(data)) {
+		return nil
+	}
+
+	protocolhandler(r, channel, packet)
+	return nil
+}
+
+// sendSocket sends an encoded relay message to the peer over the signaling websocket using the
+// WS_RELAY opcode.
+func sendSocket(r *structs.Relay, channel string, data []byte) error {
+	return message.Code(
+		r.Peer,
+		"WS_RELAY",
+		&structs.RelayOutboundSocketFrame{
+			Channel: channel,
+			Packet:  json.RawMessage(data),
+		},
+		"",
+		&structs.PeerInfo{
+			ID:   "relay",
+			User: "relay",
+		},
+	)
+}
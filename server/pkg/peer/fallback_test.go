@@ -0,0 +1,29 @@
+package peer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+func TestSocketRejectsUnknownChannels(t *testing.T) {
+	s := newServer(t)
+	r := join(t, s, "a")
+
+	for i := 0; i < 10; i++ {
+		channel := fmt.Sprintf("made-up-%d", i)
+		if err := ReceiveSocket(r, channel, &structs.RelayPacket{Opcode: "G_MSG", Payload: "hello"}); err == nil {
+			t.Fatalf("a packet on the unregistered channel %s was accepted", channel)
+		}
+	}
+	if err := ReceiveSocket(r, "", &structs.RelayPacket{Opcode: "G_MSG", Payload: "hello"}); err != nil {
+		t.Fatalf("a packet on the default channel was rejected: %v", err)
+	}
+
+	r.Mux.RLock()
+	defer r.Mux.RUnlock()
+	if len(r.Limiters) != 1 {
+		t.Fatalf("the relay has %d limiters, want only the default channel's", len(r.Limiters))
+	}
+}
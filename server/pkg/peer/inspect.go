@@ -0,0 +1,49 @@
+package peer
+
+import (
+	"slices"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// Inspect describes the state of a relay, for the admin API.
+func Inspect(r *structs.Relay) *structs.AdminRelay {
+	r.Mux.RLock()
+	defer r.Mux.RUnlock()
+	info := &structs.AdminRelay{
+		RelayStats: structs.RelayStats{
+			ID:          r.Peer.ID,
+			User:        r.Peer.Username,
+			MessagesIn:  r.Counters.MessagesIn.Load(),
+			MessagesOut: r.Counters.MessagesOut.Load(),
+			BytesIn:     r.Counters.BytesIn.Load(),
+			BytesOut:    r.Counters.BytesOut.Load(),
+			Throttled:   r.Counters.Throttled.Load(),
+		},
+		Connected: r.Connected,
+		Fallback:  r.Fallback,
+		Restarts:  r.Restarts,
+		Started:   r.Started,
+		Failed:    r.Failed,
+		Channels:  []string{},
+		Voice:     r.Voice != nil,
+	}
+	if r.Conn != nil {
+		info.State = r.Conn.ConnectionState().String()
+	}
+	for label := range r.Channels {
+		info.Channels = append(info.Channels, label)
+	}
+	slices.Sort(info.Channels)
+	return info
+}
+
+// InspectLobby describes the state of every relay in a lobby in a given game on the server.
+func InspectLobby(s *structs.Server, lobbyid string, gameid string) []*structs.AdminRelay {
+	relays := []*structs.AdminRelay{}
+	for _, relay := range manager.WithoutRelay(manager.GetRelayPeers(s, lobbyid, gameid), nil) {
+		relays = append(relays, Inspect(relay))
+	}
+	return relays
+}
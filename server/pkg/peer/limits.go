@@ -0,0 +1,171 @@
+package peer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"golang.org/x/time/rate"
+)
+
+// DefaultRelayLimits are the per-peer, per-channel limits used when a lobby doesn't set its own.
+var DefaultRelayLimits = structs.RelayLimits{
+	MessagesPerSecond: 60,
+	MessageBurst:      120,
+	BytesPerSecond:    256 * 1024,
+	ByteBurst:         MaximumMessageSize,
+	MaximumViolations: 50,
+}
+
+// MaximumRelayLimits are the highest limits a lobby may set. Higher limits are lowered to these.
+var MaximumRelayLimits = structs.RelayLimits{
+	MessagesPerSecond: 240,
+	MessageBurst:      480,
+	BytesPerSecond:    2 * 1024 * 1024,
+	ByteBurst:         4 * MaximumMessageSize,
+	MaximumViolations: 200,
+}
+
+// ViolationWindow is how long a peer has to stay within its limits before its violations are forgiven.
+const ViolationWindow = 10 * time.Second
+
+// CheckLimits returns an error if a lobby's relay limits can never be met. The byte burst must
+// fit the largest message, or every message would be dropped.
+func CheckLimits(limits *structs.RelayLimits) error {
+	if limits != nil && limits.ByteBurst > 0 && limits.ByteBurst < MaximumMessageSize {
+		return fmt.Errorf("byte_burst must be at least %d bytes, the maximum message size", MaximumMessageSize)
+	}
+	return nil
+}
+
+// lobbyLimits returns the relay limits of the relay's lobby, falling back to DefaultRelayLimits
+// for any limit that isn't set. Limits are capped at MaximumRelayLimits.
+func lobbyLimits(r *structs.Relay) structs.RelayLimits {
+	limits := DefaultRelayLimits
+	settings := manager.GetLobbySettings(r.Server, r.Lobby, r.UGI)
+	if settings == nil || settings.RelayLimits == nil {
+		return limits
+	}
+	if settings.RelayLimits.MessagesPerSecond > 0 {
+		limits.MessagesPerSecond = min(settings.RelayLimits.MessagesPerSecond, MaximumRelayLimits.MessagesPerSecond)
+	}
+	if settings.RelayLimits.MessageBurst > 0 {
+		limits.MessageBurst = min(settings.RelayLimits.MessageBurst, MaximumRelayLimits.MessageBurst)
+	}
+	if settings.RelayLimits.BytesPerSecond > 0 {
+		limits.BytesPerSecond = min(settings.RelayLimits.BytesPerSecond, MaximumRelayLimits.BytesPerSecond)
+	}
+	if settings.RelayLimits.ByteBurst > 0 {
+		limits.ByteBurst = min(max(settings.RelayLimits.ByteBurst, MaximumMessageSize), MaximumRelayLimits.ByteBurst)
+	}
+	if settings.RelayLimits.MaximumViolations > 0 {
+		limits.MaximumViolations = min(settings.RelayLimits.MaximumViolations, MaximumRelayLimits.MaximumViolations)
+	}
+	return limits
+}
+
+// getLimiter returns the token buckets of the relay's data channel, creating them from the
+// lobby's limits the first time the channel is used.
+func getLimiter(r *structs.Relay, channel string) *structs.RelayLimiter {
+	r.Mux.RLock()
+	limiter, exists := r.Limiters[channel]
+	r.Mux.RUnlock()
+	if exists {
+		return limiter
+	}
+
+	limits := lobbyLimits(r)
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+	if limiter, exists := r.Limiters[channel]; exists {
+		return limiter
+	}
+	limiter = &structs.RelayLimiter{
+		Messages:          rate.NewLimiter(rate.Limit(limits.MessagesPerSecond), limits.MessageBurst),
+		Bytes:             rate.NewLimiter(rate.Limit(limits.BytesPerSecond), limits.ByteBurst),
+		MaximumViolations: limits.MaximumViolations,
+	}
+	r.Limiters[channel] = limiter
+	return limiter
+}
+
+// allow counts a message received on the relay's data channel and checks it against the
+// lobby's limits. If the message is over the limit, the peer is sent a THROTTLED warning and
+// false is returned. Peers that keep exceeding their limits are disconnected from the relay.
+func allow(r *structs.Relay, channel string, size int) bool {
+	r.Counters.MessagesIn.Add(1)
+	r.Counters.BytesIn.Add(uint64(size))
+	if r.LobbyCounters != nil {
+		r.LobbyCounters.MessagesIn.Add(1)
+		r.LobbyCounters.BytesIn.Add(uint64(size))
+	}
+
+	limiter := getLimiter(r, channel)
+	now := time.Now()
+	limit := ""
+	if !limiter.Messages.AllowN(now, 1) {
+		limit = "messages"
+	} else if !limiter.Bytes.AllowN(now, size) {
+		limit = "bytes"
+	}
+	if limit == "" {
+		return true
+	}
+
+	// Record the violation
+	maximum := limiter.MaximumViolations
+	r.Mux.Lock()
+	if now.Sub(r.LastViolation) > ViolationWindow {
+		r.Violations = 0
+	}
+	r.Violations++
+	r.LastViolation = now
+	violations := r.Violations
+	r.Mux.Unlock()
+	r.Counters.Throttled.Add(1)
+	if r.LobbyCounters != nil {
+		r.LobbyCounters.Throttled.Add(1)
+	}
+
+	Code(
+		r,
+		"THROTTLED",
+		channel,
+		&structs.Throttled{
+			Channel:    channel,
+			Limit:      limit,
+			Violations: violations,
+			Maximum:    maximum,
+		},
+		nil,
+	)
+
+	if violations > maximum {
+		logging.ForRelay(r).Warn("Relay disconnecting peer for exceeding the lobby's limits", "limit", limit)
+		if IsFallback(r) && r.Peer.Conn != nil {
+			r.Peer.Conn.Close()
+		} else {
+			r.Conn.Close()
+		}
+	}
+	return false
+}
+
+// GetStats returns the traffic counters of every relay in a lobby in a given game on the server.
+func GetStats(s *structs.Server, lobbyid string, gameid string) []*structs.RelayStats {
+	stats := []*structs.RelayStats{}
+	for _, relay := range manager.WithoutRelay(manager.GetRelayPeers(s, lobbyid, gameid), nil) {
+		stats = append(stats, &structs.RelayStats{
+			ID:          relay.Peer.ID,
+			User:        relay.Peer.Username,
+			MessagesIn:  relay.Counters.MessagesIn.Load(),
+			MessagesOut: relay.Counters.MessagesOut.Load(),
+			BytesIn:     relay.Counters.BytesIn.Load(),
+			BytesOut:    relay.Counters.BytesOut.Load(),
+			Throttled:   relay.Counters.Throttled.Load(),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,39 @@
+package peer
+
+import (
+	"testing"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+func TestLobbyLimitsAreCapped(t *testing.T) {
+	s := newServer(t)
+	manager.AddClientToLobby(s, testLobby, testGame, &structs.Client{ID: "host"})
+	manager.SetLobbySettings(s, testLobby, testGame, &structs.LobbySettings{
+		RelayLimits: &structs.RelayLimits{
+			MessagesPerSecond: 1e9,
+			MessageBurst:      1e9,
+			BytesPerSecond:    1e12,
+			ByteBurst:         1e12,
+			MaximumViolations: 1e9,
+		},
+	})
+
+	limits := lobbyLimits(&structs.Relay{Server: s, Lobby: testLobby, UGI: testGame})
+	if limits != MaximumRelayLimits {
+		t.Fatalf("got limits %+v, want them capped at %+v", limits, MaximumRelayLimits)
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	if err := CheckLimits(nil); err != nil {
+		t.Fatalf("lobbies without limits were rejected: %v", err)
+	}
+	if err := CheckLimits(&structs.RelayLimits{ByteBurst: MaximumMessageSize}); err != nil {
+		t.Fatalf("a byte burst of the maximum message size was rejected: %v", err)
+	}
+	if err := CheckLimits(&structs.RelayLimits{ByteBurst: MaximumMessageSize - 1}); err == nil {
+		t.Fatal("a byte burst smaller than the maximum message size was accepted")
+	}
+}
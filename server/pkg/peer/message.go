@@ -1,46 +1,74 @@
 package peer
 
 import (
-	"log"
+	"fmt"
 
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
-	"github.com/goccy/go-json"
 )
 
-// Send marshals the given message using go-json and sends it over the given
-// webrtc DataChannel. If the channel is nil, it logs the error and returns
-// nil.
+// Send marshals the given message using the wire encoding of the channel and sends it
+// over the relay's webrtc DataChannel. Messages larger than MaximumFragmentSize are sent
+// as FRAG packets. If the peer isn't part of the channel, it returns an error.
+//
+// If the relay has fallen back to the signaling websocket, the message is sent there as JSON
+// instead, whether or not the channel exists.
 func Send(r *structs.Relay, channel string, message interface{}) error {
 	if channel == "" {
-		log.Printf("Got an empty channel when relaying message: %v", message)
+		logging.ForRelay(r).Warn("Relay got an empty channel when relaying a message")
 		return nil
 	}
 
-	// Marshal the message using go-json instead of interface/json
-	bytes, err := json.Marshal(message)
+	// Marshal the message
+	encoding := getEncoding(r, channel)
+	fallback := IsFallback(r)
+	if fallback {
+		encoding = EncodingJSON
+	}
+	bytes, err := Encode(encoding, message)
 	if err != nil {
 		return err
 	}
+	if len(bytes) > MaximumMessageSize {
+		return fmt.Errorf("message exceeds the maximum size of %d bytes", MaximumMessageSize)
+	}
 
 	// Send the message
-	if dchannel, exists := r.Channels[channel]; !exists {
-		log.Printf("Peer %s was not part of channel %s in relay %v while trying to relay message: %s", r.Peer.ID, channel, r, message)
-		return nil
+	if fallback {
+		err = sendSocket(r, channel, bytes)
 	} else {
-		return dchannel.SendText(string(bytes))
+		dchannel, exists := GetChannel(r, channel)
+		if !exists {
+			logging.ForRelay(r).Debug("Relay peer is not part of the channel of a message", "channel", channel)
+			return fmt.Errorf("peer is not part of channel %s", channel)
+		}
+		if len(bytes) > MaximumFragmentSize {
+			err = writeFragments(dchannel, encoding, bytes)
+		} else {
+			err = write(dchannel, encoding, bytes)
+		}
+	}
+	if err == nil {
+		r.Counters.MessagesOut.Add(1)
+		r.Counters.BytesOut.Add(uint64(len(bytes)))
+		if r.LobbyCounters != nil {
+			r.LobbyCounters.MessagesOut.Add(1)
+			r.LobbyCounters.BytesOut.Add(uint64(len(bytes)))
+		}
 	}
+	return err
 }
 
 // Code sends a RelayPacket over the given DataChannel with the given opcode and
-// optional payload. If the channel is nil, it logs the error and returns nil.
+// optional payload. If the peer isn't part of the channel, it returns an error.
 //
-// The payload is marshaled using go-json. If the origin is not nil, it is
+// The payload is marshaled using the channel's wire encoding. If the origin is not nil, it is
 // included in the RelayPacket.
 func Code(r *structs.Relay, code string, channel string, message interface{}, origin *structs.PeerInfo) error {
 	return Send(r, channel, &structs.RelayPacket{Opcode: code, Payload: message, Origin: origin})
 }
 
-// Broadcast sends the given message to all the given DataChannels. If the message is a RelayPacket, it will be marshaled using go-json before being sent. If a channel is nil, it is skipped.
+// Broadcast sends the given message to all the given DataChannels. If the message is a RelayPacket, it will be marshaled using the wire encoding of each channel before being sent. If a channel is nil, it is skipped.
 func Broadcast(relays []*structs.Relay, channel string, message interface{}) {
 	for _, relay := range relays {
 		Send(relay, channel, message)
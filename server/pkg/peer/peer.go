@@ -1,10 +1,11 @@
 package peer
 
 import (
-	"log"
-
-	"github.com/goccy/go-json"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
@@ -15,38 +16,70 @@ type Relay struct {
 	*structs.Relay
 }
 
-func Spawn(s *structs.Server, ugi string, lobby string, peer *structs.Client) *structs.Relay {
+// DefaultICEServers are used by relay peer connections unless the server is configured with its own.
+var DefaultICEServers = []webrtc.ICEServer{
+	{
+		URLs:           []string{"turn:vpn.mikedev101.cc:5349", "turn:vpn.mikedev101.cc:3478", "turn:freeturn.net:5349", "turn:freeturn.net:3478"},
+		Username:       "free",
+		Credential:     "free",
+		CredentialType: webrtc.ICECredentialTypePassword,
+	},
+	{
+		URLs: []string{"stun:vpn.mikedev101.cc:5349", "stun:vpn.mikedev101.cc:3478", "stun:stun.l.google.com:19302", "stun:freeturn.net:3478", "stun:freeturn.net:5349"},
+	},
+}
+
+// configuration builds the WebRTC configuration used by relay peer connections.
+// If the server is in TURN only mode, STUN servers are omitted and the ICE transport
+// policy is restricted to relay candidates.
+func configuration(s *structs.Server) webrtc.Configuration {
 
 	// Prepare the configuration
+	settings := s.Settings.Load()
 	policy := webrtc.ICETransportPolicyAll
-	if s.TURNOnly {
+	if settings.TURNOnly {
 		policy = webrtc.ICETransportPolicyRelay
 	}
 
+	servers := settings.ICEServers
+	if servers == nil {
+		servers = DefaultICEServers
+	}
+
 	// Build the configuration
 	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs:           []string{"turn:vpn.mikedev101.cc:5349", "turn:vpn.mikedev101.cc:3478", "turn:freeturn.net:5349", "turn:freeturn.net:3478"},
-				Username:       "free",
-				Credential:     "free",
-				CredentialType: webrtc.ICECredentialTypePassword,
-			},
-		},
+		ICEServers:         []webrtc.ICEServer{},
 		ICETransportPolicy: policy,
 	}
 
-	// Add STUN servers if not TURN only
-	if !s.TURNOnly {
-		config.ICEServers = append(config.ICEServers, webrtc.ICEServer{
-			URLs: []string{"stun:vpn.mikedev101.cc:5349", "stun:vpn.mikedev101.cc:3478", "stun:stun.l.google.com:19302", "stun:freeturn.net:3478", "stun:freeturn.net:5349"},
-		})
+	// Leave out STUN servers if TURN only
+	for _, server := range servers {
+		urls := []string{}
+		for _, url := range server.URLs {
+			if !settings.TURNOnly || !(strings.HasPrefix(url, "stun:") || strings.HasPrefix(url, "stuns:")) {
+				urls = append(urls, url)
+			}
+		}
+		if len(urls) > 0 {
+			server.URLs = urls
+			config.ICEServers = append(config.ICEServers, server)
+		}
 	}
 
+	return config
+}
+
+// Spawn creates a relay peer for a client in a lobby and starts running it in the background.
+// Use Start instead to have the relay supervised.
+func Spawn(s *structs.Server, ugi string, lobby string, peer *structs.Client) (*structs.Relay, error) {
+
+	// Build the configuration
+	config := configuration(s)
+
 	// Create a new RTCPeerConnection
 	peerConnection, err := webrtc.NewPeerConnection(config)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Create a new relay peer
@@ -55,15 +88,22 @@ func Spawn(s *structs.Server, ugi string, lobby string, peer *structs.Client) *s
 		Conn:             peerConnection,
 		Lobby:            lobby,
 		UGI:              ugi,
-		RequestShutdown:  make(chan bool),
+		RequestShutdown:  make(chan bool, 1),
 		ShutdownComplete: make(chan bool),
 		Running:          true,
 		Peer:             peer,
 		Channels:         make(map[string]*webrtc.DataChannel),
+		Mux:              &sync.RWMutex{},
+		Calls:            make(map[string]*structs.RelayCall),
+		Encodings:        make(map[string]string),
+		Fragments:        make(map[string]*structs.RelayFragments),
+		Limiters:         make(map[string]*structs.RelayLimiter),
+		Topics:           map[string]bool{WildcardTopic: true},
+		Recorder:         manager.GetLobbyRecorder(s, lobby, ugi),
+		LobbyCounters:    manager.GetLobbyTraffic(s, lobby, ugi),
+		Started:          time.Now(),
 	}
 
-	log.Printf("Relay [peer: %s, game: %s, lobby: %s] starting up...", relay.Peer.ID, relay.UGI, relay.Lobby)
-
 	// Create the default data channel
 	yes := true
 	zero := uint16(0)
@@ -75,10 +115,13 @@ func Spawn(s *structs.Server, ugi string, lobby string, peer *structs.Client) *s
 		Protocol:   &protocol,
 	})
 	if err != nil {
-		panic(err)
-	} else {
-		channelhandler(relay, relay.Channels["default"])
+		peerConnection.Close()
+		return nil, err
 	}
+	channelhandler(relay, relay.Channels["default"])
+
+	logging.ForRelay(relay).Info("Relay starting up")
+	record(relay, "join", "", nil)
 
 	// Begin running the peer in the background
 	go func() {
@@ -89,69 +132,79 @@ func Spawn(s *structs.Server, ugi string, lobby string, peer *structs.Client) *s
 		// Keep running until the shutdown signal is received
 		<-relay.RequestShutdown
 
-		// Shutdown the peer if it is running
-		if relay.Running {
-			relay.Conn.Close()
-			log.Printf("Relay [peer: %s, game: %s, lobby: %s] shutting down...", relay.Peer.ID, relay.UGI, relay.Lobby)
-		}
+		// Stop forwarding voice to and from the peer
+		CloseVoice(relay)
+
+		// Fail any RPC calls that are waiting on the peer
+		FailCalls(relay)
+		record(relay, "leave", "", nil)
+
+		// Shutdown the peer. Failed connections still need to be closed to release their resources.
+		relay.Mux.Lock()
+		relay.Running = false
+		relay.Mux.Unlock()
+		relay.Conn.Close()
+		logging.ForRelay(relay).Info("Relay shutting down")
 
 		// Send the shutdown complete signal
-		relay.ShutdownComplete <- true
+		close(relay.ShutdownComplete)
 	}()
 
-	return relay
+	return relay, nil
 }
 
-func MakeOffer(r *structs.Relay) *webrtc.SessionDescription {
+// MakeOffer creates an offer for the relay's peer connection and sets it as the local description.
+// If restart is true, the offer restarts the connection's ICE session.
+func MakeOffer(r *structs.Relay, restart bool) (*webrtc.SessionDescription, error) {
 
 	// Create an offer.
-	offer, offer_err := r.Conn.CreateOffer(&webrtc.OfferOptions{})
-	if offer_err != nil {
-		panic(offer_err)
+	offer, err := r.Conn.CreateOffer(&webrtc.OfferOptions{ICERestart: restart})
+	if err != nil {
+		return nil, err
 	}
 
 	// Set the local description.
 	if err := r.Conn.SetLocalDescription(offer); err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Get the local description
-	return r.Conn.LocalDescription()
+	return r.Conn.LocalDescription(), nil
 }
 
-func MakeAnswerFromOffer(r *structs.Relay, offer *webrtc.SessionDescription) *webrtc.SessionDescription {
+// MakeAnswerFromOffer applies a peer's offer to the relay's peer connection and returns the relay's answer.
+func MakeAnswerFromOffer(r *structs.Relay, offer *webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
 
 	// Set the remote description.
 	if err := r.Conn.SetRemoteDescription(*offer); err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Make answer
-	answer, answer_err := r.Conn.CreateAnswer(&webrtc.AnswerOptions{})
-	if answer_err != nil {
-		panic(answer_err)
+	answer, err := r.Conn.CreateAnswer(&webrtc.AnswerOptions{})
+	if err != nil {
+		return nil, err
 	}
 
 	// Set the local description.
 	if err := r.Conn.SetLocalDescription(answer); err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Get the local description
-	return r.Conn.LocalDescription()
+	return r.Conn.LocalDescription(), nil
 }
 
-func HandleAnswer(r *structs.Relay, answer *webrtc.SessionDescription) {
+// HandleAnswer applies a peer's answer to the relay's peer connection.
+func HandleAnswer(r *structs.Relay, answer *webrtc.SessionDescription) error {
 	// Set the remote description.
-	if err := r.Conn.SetRemoteDescription(*answer); err != nil {
-		panic(err)
-	}
+	return r.Conn.SetRemoteDescription(*answer)
 }
 
 func HandleIce(r *structs.Relay, ice *webrtc.ICECandidateInit) {
 	// Add the ICE candidate.
 	if err := r.Conn.AddICECandidate(*ice); err != nil {
-		log.Print(err)
+		logging.ForRelay(r).Warn("Failed to add ICE candidate", "error", err)
 	}
 }
 
@@ -160,15 +213,45 @@ func handler(r *structs.Relay) {
 
 	// Handle connection state changes
 	r.Conn.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
-		log.Printf("Relay [peer: %s, game: %s, lobby: %s] state changed to %s.", r.Peer.ID, r.UGI, r.Lobby, s.String())
+		defer guard(r)
+		logging.ForRelay(r).Info("Relay state changed", "state", s.String())
 
 		switch s {
+		case webrtc.PeerConnectionStateConnected:
+			markConnected(r)
+			finishIceRestart(r)
+
+			// Peers that were using the signaling websocket while ICE restarted switch back to WebRTC
+			if _, exists := GetChannel(r, "default"); exists {
+				setFallback(r, false)
+			}
+			return
+
+		case webrtc.PeerConnectionStateDisconnected:
+			markFailed(r, false)
+			return
+
 		case webrtc.PeerConnectionStateFailed:
-			r.Running = false
+			markFailed(r, true)
+
+			// Keep the peer connected to the lobby over the signaling websocket
+			EnableFallback(r)
+			message.Code(
+				r.Peer,
+				"RELAY_FALLBACK",
+				nil,
+				r.Lobby,
+				&structs.PeerInfo{
+					ID:   "relay",
+					User: "relay",
+				},
+			)
 			return
 
 		case webrtc.PeerConnectionStateClosed:
+			r.Mux.Lock()
 			r.Running = false
+			r.Mux.Unlock()
 			return
 		}
 	})
@@ -179,7 +262,7 @@ func handler(r *structs.Relay) {
 			return
 		}
 
-		log.Printf("Relay [peer: %s, game: %s, lobby: %s] preparing ICE candidate: %s.", r.Peer.ID, r.UGI, r.Lobby, c.ToJSON().Candidate)
+		logging.ForRelay(r).Debug("Relay preparing ICE candidate", "candidate", c.ToJSON().Candidate)
 
 		// Send the ICE candidate
 		message.Code(
@@ -197,44 +280,55 @@ func handler(r *structs.Relay) {
 		)
 	})
 
+	// Register data channels created by the peer and mirror them to the rest of the lobby
 	r.Conn.OnDataChannel(func(d *webrtc.DataChannel) {
-		channelhandler(r, d)
+		defer guard(r)
+		addChannel(r, d)
+		recordChannel(r, d)
+		mirrorChannel(r, d)
 	})
 }
 
 func channelhandler(r *structs.Relay, d *webrtc.DataChannel) {
 
 	d.OnError(func(err error) {
-		log.Printf("Relay [peer: %s, game: %s, lobby: %s] data channel \"%s\" error: %s.", r.Peer.ID, r.UGI, r.Lobby, d.Label(), err.Error())
+		logging.ForRelay(r).Warn("Relay data channel error", "channel", d.Label(), "error", err)
 	})
 
 	d.OnOpen(func() {
-		log.Printf("Relay [peer: %s, game: %s, lobby: %s] data channel \"%s\" open.", r.Peer.ID, r.UGI, r.Lobby, d.Label())
+		defer guard(r)
+		logging.ForRelay(r).Info("Relay data channel open", "channel", d.Label())
+
+		// Catch up on channels, global state and frames from before the peer joined.
+		// Peers that were using the signaling websocket switch back to WebRTC.
+		if d.Label() == "default" {
+			setFallback(r, false)
+			syncChannels(r)
+			catchUp(r)
+		}
 	})
 
 	d.OnClose(func() {
-		log.Printf("Relay [peer: %s, game: %s, lobby: %s] data channel \"%s\" closed.", r.Peer.ID, r.UGI, r.Lobby, d.Label())
+		logging.ForRelay(r).Info("Relay data channel closed", "channel", d.Label())
+		removeChannel(r, d)
 	})
 
 	d.OnMessage(func(msg webrtc.DataChannelMessage) {
-		protocolhandler(r, d.Label(), string(msg.Data))
+		defer guard(r)
+		receive(r, d.Label(), msg)
 	})
 }
 
-func protocolhandler(r *structs.Relay, channel string, rawpacket string) {
+func protocolhandler(r *structs.Relay, channel string, packet *structs.RelayPacket) {
 
-	// Parse the message as JSON
-	var packet structs.RelayPacket
-	if err := json.Unmarshal([]byte(rawpacket), &packet); err != nil {
-		log.Printf("Failed to parse message: %s", err.Error())
-		return
-	}
+	// Record the packet if the lobby is being recorded
+	record(r, "packet", channel, packet)
 
 	// Handle the opcode
 	switch packet.Opcode {
 
 	case "G_MSG":
-		relays := manager.WithoutRelay(manager.GetRelayPeers(r.Server, r.Lobby, r.UGI), r)
+		relays := subscribers(r, topic(packet))
 		Broadcast(
 			relays,
 			channel,
@@ -242,6 +336,7 @@ func protocolhandler(r *structs.Relay, channel string, rawpacket string) {
 				Opcode:  "G_MSG",
 				Payload: packet.Payload,
 				Channel: packet.Channel,
+				Topic:   packet.Topic,
 				Origin: &structs.PeerInfo{
 					ID:   r.Peer.ID,
 					User: r.Peer.Username,
@@ -250,7 +345,11 @@ func protocolhandler(r *structs.Relay, channel string, rawpacket string) {
 		)
 
 	case "G_VAR":
-		relays := manager.WithoutRelay(manager.GetRelayPeers(r.Server, r.Lobby, r.UGI), r)
+		if err := storeValue(r, packet); err != nil {
+			Code(r, "WARN", channel, err.Error(), nil)
+			return
+		}
+		relays := subscribers(r, topic(packet))
 		Broadcast(
 			relays,
 			channel,
@@ -258,6 +357,7 @@ func protocolhandler(r *structs.Relay, channel string, rawpacket string) {
 				Opcode:  "G_VAR",
 				Payload: packet.Payload,
 				Channel: packet.Channel,
+				Topic:   packet.Topic,
 				Origin: &structs.PeerInfo{
 					ID:   r.Peer.ID,
 					User: r.Peer.Username,
@@ -266,7 +366,11 @@ func protocolhandler(r *structs.Relay, channel string, rawpacket string) {
 		)
 
 	case "G_LIST":
-		relays := manager.WithoutRelay(manager.GetRelayPeers(r.Server, r.Lobby, r.UGI), r)
+		if err := storeValue(r, packet); err != nil {
+			Code(r, "WARN", channel, err.Error(), nil)
+			return
+		}
+		relays := subscribers(r, topic(packet))
 		Broadcast(
 			relays,
 			channel,
@@ -274,6 +378,7 @@ func protocolhandler(r *structs.Relay, channel string, rawpacket string) {
 				Opcode:  "G_LIST",
 				Payload: packet.Payload,
 				Channel: packet.Channel,
+				Topic:   packet.Topic,
 				Origin: &structs.PeerInfo{
 					ID:   r.Peer.ID,
 					User: r.Peer.Username,
@@ -282,7 +387,8 @@ func protocolhandler(r *structs.Relay, channel string, rawpacket string) {
 		)
 
 	case "P_MSG":
-		if manager.VerifyRelayState(r, &packet) != nil {
+		if err := manager.VerifyRelayState(r, packet); err != nil {
+			Code(r, "WARN", channel, err.Error(), nil)
 			return
 		}
 		recipient := manager.GetRelay(r.Server, manager.GetByULID(r.Server, packet.Recipient))
@@ -301,7 +407,8 @@ func protocolhandler(r *structs.Relay, channel string, rawpacket string) {
 		)
 
 	case "P_VAR":
-		if manager.VerifyRelayState(r, &packet) != nil {
+		if err := manager.VerifyRelayState(r, packet); err != nil {
+			Code(r, "WARN", channel, err.Error(), nil)
 			return
 		}
 		recipient := manager.GetRelay(r.Server, manager.GetByULID(r.Server, packet.Recipient))
@@ -320,7 +427,8 @@ func protocolhandler(r *structs.Relay, channel string, rawpacket string) {
 		)
 
 	case "P_LIST":
-		if manager.VerifyRelayState(r, &packet) != nil {
+		if err := manager.VerifyRelayState(r, packet); err != nil {
+			Code(r, "WARN", channel, err.Error(), nil)
 			return
 		}
 		recipient := manager.GetRelay(r.Server, manager.GetByULID(r.Server, packet.Recipient))
@@ -338,8 +446,64 @@ func protocolhandler(r *structs.Relay, channel string, rawpacket string) {
 			},
 		)
 
+	case "RPC_CALL":
+		Call(r, channel, packet)
+
+	case "RPC_RESULT", "RPC_ERROR":
+		Reply(r, channel, packet)
+
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		if err := SetTopics(r, packet.Payload, packet.Opcode == "SUBSCRIBE"); err != nil {
+			Code(r, "WARN", channel, err.Error(), nil)
+			return
+		}
+		Code(r, "ACK_"+packet.Opcode, channel, GetTopics(r), nil)
+
+	case "RELAY_STATS":
+		if !r.Peer.AmIAHost() {
+			Code(r, "WARN", channel, "Not the lobby host", nil)
+			return
+		}
+		Code(r, "RELAY_STATS", channel, GetStats(r.Server, r.Lobby, r.UGI), nil)
+
+	case "INPUT":
+		if err := Input(r, packet); err != nil {
+			Code(r, "WARN", channel, err.Error(), nil)
+		}
+
+	case "TICK_SYNC":
+		if err := SendTicks(r, channel); err != nil {
+			Code(r, "WARN", channel, err.Error(), nil)
+		}
+
+	case "STATE_SYNC":
+		if err := SendState(r, channel); err != nil {
+			Code(r, "WARN", channel, err.Error(), nil)
+		}
+
+	case "VOICE_MUTE", "VOICE_UNMUTE":
+		if err := SetMuted(r, packet.Opcode == "VOICE_MUTE"); err != nil {
+			Code(r, "WARN", channel, err.Error(), nil)
+		}
+
+	case "VOICE_SUBSCRIBE", "VOICE_UNSUBSCRIBE":
+		id, ok := packet.Payload.(string)
+		if !ok {
+			Code(r, "WARN", channel, "Payload (peer ID) must be a string", nil)
+			return
+		}
+		var err error
+		if packet.Opcode == "VOICE_SUBSCRIBE" {
+			err = Subscribe(r, id)
+		} else {
+			err = Unsubscribe(r, id)
+		}
+		if err != nil {
+			Code(r, "WARN", channel, err.Error(), nil)
+		}
+
 	default:
-		log.Printf("Got unknown opcode: %s", packet.Opcode)
+		logging.ForRelay(r).Debug("Relay got unknown opcode", "opcode", packet.Opcode)
 		Send(
 			manager.GetRelay(r.Server, r.Peer),
 			channel,
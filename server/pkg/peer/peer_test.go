@@ -0,0 +1,71 @@
+package peer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/go-playground/validator/v10"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	testGame  = "test-game"
+	testLobby = "test-lobby"
+)
+
+// newServer returns a server whose relays connect over loopback, without STUN or TURN servers.
+func newServer(t *testing.T) *structs.Server {
+	t.Helper()
+	s := &structs.Server{
+		Mux:             &sync.RWMutex{},
+		Games:           &structs.GameStore{Games: make(map[string]*structs.Game)},
+		Sessions:        &structs.SessionStore{Sessions: make(map[string]*structs.Session)},
+		Relays:          make(map[*structs.Client]*structs.Relay),
+		RelayLock:       &sync.RWMutex{},
+		PacketValidator: validator.New(validator.WithRequiredStructEnabled()),
+		Diagnostics:     make(map[*structs.Client]*structs.Diagnostic),
+		DiagnosticsLock: &sync.RWMutex{},
+		Addresses:       &structs.AddressStore{Addresses: make(map[string]*structs.AddressState)},
+	}
+	s.Settings.Store(&structs.Settings{
+		ICEServers:   []webrtc.ICEServer{},
+		Limits:       &structs.ConnectionLimits{},
+		PacketLimits: &structs.SignalingLimits{},
+	})
+	return s
+}
+
+// join adds a client without a websocket connection to the test lobby and spawns its relay.
+func join(t *testing.T, s *structs.Server, id string) *structs.Relay {
+	t.Helper()
+	client := &structs.Client{ID: id, Username: id, UGI: testGame, Mux: &sync.RWMutex{}}
+	client.SetPeerMode()
+	client.SetLobby(testLobby)
+	if err := manager.CreateSession(s, client); err != nil {
+		t.Fatal(err)
+	}
+	manager.AddClientToLobby(s, testLobby, testGame, client)
+
+	relay, err := Spawn(s, testGame, testLobby, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetRelay(s, client, relay)
+	t.Cleanup(func() { manager.DeleteRelay(s, client) })
+	return relay
+}
+
+// eventually fails the test if the condition doesn't become true within the timeout.
+func eventually(t *testing.T, timeout time.Duration, condition func() bool, message string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,120 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/pion/webrtc/v4"
+)
+
+// DefaultRecordingMaxSize is the size a recording may grow to unless the server sets its own limit.
+const DefaultRecordingMaxSize = 256 * 1024 * 1024
+
+// DefaultRecordingMaxDuration is how long a recording may run unless the server sets its own limit.
+const DefaultRecordingMaxDuration = 6 * time.Hour
+
+// unsafe matches characters that shouldn't appear in recording file names.
+var unsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// StartRecording opens a recording for a lobby in the server's recordings directory, starting
+// it with the lobby's settings.
+// Relays spawned in the lobby afterwards write every packet they receive and their
+// membership events to it. The recording is closed when the lobby is destroyed, or once it
+// reaches the server's recording size or duration limit.
+func StartRecording(s *structs.Server, ugi string, lobby string) error {
+	settings := s.Settings.Load()
+	if settings.RecordingsDir == "" {
+		return fmt.Errorf("recording is not enabled on this server")
+	}
+	if err := os.MkdirAll(settings.RecordingsDir, 0o755); err != nil {
+		return err
+	}
+
+	started := time.Now()
+	name := fmt.Sprintf("%s_%s_%s.jsonl", unsafe.ReplaceAllString(ugi, "-"), unsafe.ReplaceAllString(lobby, "-"), started.UTC().Format("20060102T150405.000000000Z"))
+	path := filepath.Join(settings.RecordingsDir, name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	recorder := &structs.Recorder{
+		File:        file,
+		Path:        path,
+		Started:     started,
+		MaxSize:     settings.RecordingMaxSize,
+		MaxDuration: settings.RecordingMaxDuration,
+	}
+
+	// Keep the lobby's password out of the recording
+	var lobbysettings *structs.LobbySettings
+	if current := manager.GetLobbySettings(s, lobby, ugi); current != nil {
+		copied := *current
+		copied.Password = ""
+		lobbysettings = &copied
+	}
+	if err := recorder.Write(&structs.RecordEntry{
+		Event:    "start",
+		Settings: lobbysettings,
+	}); err != nil {
+		recorder.Close()
+		return err
+	}
+
+	manager.SetLobbyRecorder(s, lobby, ugi, recorder)
+	logging.Relay().Info("Recording lobby", "ugi", ugi, "lobby", lobby, "path", path)
+	return nil
+}
+
+// record writes an event to the relay's recording, if the lobby is being recorded.
+func record(r *structs.Relay, event string, channel string, packet *structs.RelayPacket) {
+	if r.Recorder == nil {
+		return
+	}
+	entry := &structs.RecordEntry{
+		Event: event,
+		Origin: &structs.PeerInfo{
+			ID:   r.Peer.ID,
+			User: r.Peer.Username,
+		},
+		Channel: channel,
+		Packet:  packet,
+	}
+	if packet != nil {
+		entry.Opcode = packet.Opcode
+	}
+	writeRecord(r, entry)
+}
+
+// recordChannel writes the settings of a data channel that the peer opened to the relay's
+// recording, if the lobby is being recorded, so that replays can open it the same way.
+func recordChannel(r *structs.Relay, d *webrtc.DataChannel) {
+	if r.Recorder == nil {
+		return
+	}
+	writeRecord(r, &structs.RecordEntry{
+		Event: "open",
+		Origin: &structs.PeerInfo{
+			ID:   r.Peer.ID,
+			User: r.Peer.Username,
+		},
+		Channel: d.Label(),
+		Info:    channelInfo(d),
+	})
+}
+
+// writeRecord appends an entry to the relay's recording.
+func writeRecord(r *structs.Relay, entry *structs.RecordEntry) {
+	if err := r.Recorder.Write(entry); errors.Is(err, structs.ErrRecordingLimit) {
+		logging.ForRelay(r).Info("Relay recording stopped at its limit", "path", r.Recorder.Path)
+	} else if err != nil {
+		logging.ForRelay(r).Warn("Relay recording error", "error", err)
+	}
+}
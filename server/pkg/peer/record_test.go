@@ -0,0 +1,56 @@
+package peer
+
+import (
+	"bufio"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/goccy/go-json"
+)
+
+func TestRecordingStopsAtItsSizeLimit(t *testing.T) {
+	s := newServer(t)
+	settings := *s.Settings.Load()
+	settings.RecordingsDir = t.TempDir()
+	settings.RecordingMaxSize = 1024
+	s.Settings.Store(&settings)
+	client := &structs.Client{ID: "a", Username: "a", Mux: &sync.RWMutex{}}
+	manager.AddClientToLobby(s, testLobby, testGame, client)
+
+	if err := StartRecording(s, testGame, testLobby); err != nil {
+		t.Fatal(err)
+	}
+	r := &structs.Relay{Server: s, Lobby: testLobby, UGI: testGame, Peer: client, Recorder: manager.GetLobbyRecorder(s, testLobby, testGame)}
+	for i := 0; i < 100; i++ {
+		record(r, "packet", "default", &structs.RelayPacket{Opcode: "G_MSG", Payload: "hello"})
+	}
+	if !r.Recorder.Closed {
+		t.Fatal("the recording wasn't stopped at its size limit")
+	}
+
+	// The recording ends with a stop event and stays within its limit
+	info, err := os.Stat(r.Recorder.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(r.Recorder.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var last structs.RecordEntry
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if last.Event != "stop" {
+		t.Fatalf("the last event is %q, want stop", last.Event)
+	}
+	if info.Size() > settings.RecordingMaxSize+64 {
+		t.Fatalf("the recording is %d bytes, over its limit of %d", info.Size(), settings.RecordingMaxSize)
+	}
+}
@@ -0,0 +1,205 @@
+package peer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// DefaultCallTimeout is how long the relay waits for a reply to an RPC call if the caller doesn't specify a timeout.
+const DefaultCallTimeout = 10 * time.Second
+
+// MaximumCallTimeout is the longest the relay will wait for a reply to an RPC call.
+const MaximumCallTimeout = 60 * time.Second
+
+// callError replies to an RPC call with an RPC_ERROR generated by the relay.
+func callError(r *structs.Relay, channel string, id string, reason string, text string) {
+	Send(
+		r,
+		channel,
+		&structs.RelayPacket{
+			Opcode: "RPC_ERROR",
+			ID:     id,
+			Payload: &structs.RPCError{
+				Reason:  reason,
+				Message: text,
+			},
+			Origin: &structs.PeerInfo{
+				ID:   "relay",
+				User: "relay",
+			},
+		},
+	)
+}
+
+// callee resolves the recipient of an RPC call to its relay. The recipient may be a peer ID,
+// or "host" to call the lobby host.
+func callee(r *structs.Relay, recipient string) (*structs.Relay, error) {
+	var peer *structs.Client
+	if recipient == "host" {
+		host, err := manager.GetLobbyHost(r.Server, r.Lobby, r.UGI)
+		if err != nil {
+			return nil, err
+		}
+		peer = host
+	} else {
+		peer = manager.GetByULID(r.Server, recipient)
+		if peer == nil {
+			return nil, fmt.Errorf("recipient not found")
+		}
+	}
+	if peer == r.Peer {
+		return nil, fmt.Errorf("cannot call yourself")
+	}
+	if !manager.IsClientInLobby(r.Server, r.Lobby, r.UGI, peer) {
+		return nil, fmt.Errorf("recipient peer is not in the same lobby")
+	}
+	relay := manager.GetRelay(r.Server, peer)
+	if relay == nil {
+		return nil, fmt.Errorf("recipient peer is not connected to the relay")
+	}
+	return relay, nil
+}
+
+// Call handles an RPC_CALL packet. The call is forwarded to the recipient and tracked until
+// the recipient replies or the call times out. If the recipient can't be reached, the caller
+// gets an RPC_ERROR reply straight away.
+func Call(r *structs.Relay, channel string, packet *structs.RelayPacket) {
+	if packet.ID == "" {
+		callError(r, channel, packet.ID, "invalid", "no request ID specified")
+		return
+	}
+
+	target, err := callee(r, packet.Recipient)
+	if err != nil {
+		callError(r, channel, packet.ID, "unreachable", err.Error())
+		return
+	}
+
+	timeout := DefaultCallTimeout
+	if packet.Timeout > 0 {
+		timeout = min(time.Duration(packet.Timeout)*time.Millisecond, MaximumCallTimeout)
+	}
+
+	// Track the call
+	r.Mux.Lock()
+	if _, exists := r.Calls[packet.ID]; exists {
+		r.Mux.Unlock()
+		callError(r, channel, packet.ID, "invalid", "request ID is already in use")
+		return
+	}
+	call := &structs.RelayCall{
+		ID:      packet.ID,
+		Callee:  target.Peer.ID,
+		Channel: channel,
+	}
+	call.Timer = time.AfterFunc(timeout, func() {
+		if takeCall(r, call.ID, "") != nil {
+			callError(r, call.Channel, call.ID, "timeout", "recipient did not reply in time")
+		}
+	})
+	r.Calls[packet.ID] = call
+	r.Mux.Unlock()
+
+	// Forward the call
+	if err := Send(
+		target,
+		channel,
+		&structs.RelayPacket{
+			Opcode:  "RPC_CALL",
+			ID:      packet.ID,
+			Payload: packet.Payload,
+			Channel: packet.Channel,
+			Origin: &structs.PeerInfo{
+				ID:   r.Peer.ID,
+				User: r.Peer.Username,
+			},
+		},
+	); err != nil {
+		if takeCall(r, call.ID, "") != nil {
+			callError(r, channel, call.ID, "unreachable", err.Error())
+		}
+	}
+}
+
+// Reply handles an RPC_RESULT or RPC_ERROR packet sent by the peer that was called.
+// The reply is forwarded to the caller, provided the caller is still waiting for it.
+func Reply(r *structs.Relay, channel string, packet *structs.RelayPacket) {
+	caller := manager.GetByULID(r.Server, packet.Recipient)
+	if caller == nil {
+		Code(r, "WARN", channel, "Caller not found: "+packet.Recipient, nil)
+		return
+	}
+	relay := manager.GetRelay(r.Server, caller)
+	if relay == nil {
+		Code(r, "WARN", channel, "Caller is not connected to the relay: "+packet.Recipient, nil)
+		return
+	}
+
+	call := takeCall(relay, packet.ID, r.Peer.ID)
+	if call == nil {
+		Code(r, "WARN", channel, "No pending call with ID: "+packet.ID, nil)
+		return
+	}
+
+	Send(
+		relay,
+		call.Channel,
+		&structs.RelayPacket{
+			Opcode:  packet.Opcode,
+			ID:      packet.ID,
+			Payload: packet.Payload,
+			Channel: packet.Channel,
+			Origin: &structs.PeerInfo{
+				ID:   r.Peer.ID,
+				User: r.Peer.Username,
+			},
+		},
+	)
+}
+
+// takeCall removes a pending call from the relay and stops its timer. If callee is not empty,
+// the call is only removed if it was made to that peer. It returns nil if there was no such call.
+func takeCall(r *structs.Relay, id string, callee string) *structs.RelayCall {
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+	call, exists := r.Calls[id]
+	if !exists || (callee != "" && call.Callee != callee) {
+		return nil
+	}
+	call.Timer.Stop()
+	delete(r.Calls, id)
+	return call
+}
+
+// FailCalls cancels every call the relay's peer was waiting on, and replies with an RPC_ERROR
+// to every call in the lobby that was made to the relay's peer. It is used when a relay shuts down.
+func FailCalls(r *structs.Relay) {
+	r.Mux.Lock()
+	for id, call := range r.Calls {
+		call.Timer.Stop()
+		delete(r.Calls, id)
+	}
+	r.Mux.Unlock()
+
+	for _, other := range manager.WithoutRelay(manager.GetRelayPeers(r.Server, r.Lobby, r.UGI), r) {
+		other.Mux.RLock()
+		var ids []string
+		for id, call := range other.Calls {
+			if call.Callee == r.Peer.ID {
+				ids = append(ids, id)
+			}
+		}
+		other.Mux.RUnlock()
+
+		for _, id := range ids {
+			if call := takeCall(other, id, r.Peer.ID); call != nil {
+				logging.ForRelay(other).Debug("Relay call failed, recipient left", "call", id)
+				callError(other, call.Channel, id, "gone", "recipient left the lobby")
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package peer
+
+import (
+	"fmt"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/goccy/go-json"
+)
+
+// storeValue records the payload of a G_VAR or G_LIST packet in the lobby's shared variable store.
+// Payloads that aren't an object with a name and value are left out of the store, so that they are
+// still broadcast as-is to clients that predate the store. It returns an error if the key is locked
+// by another peer.
+func storeValue(r *structs.Relay, packet *structs.RelayPacket) error {
+	store := manager.GetLobbyStore(r.Server, r.Lobby, r.UGI)
+	if store == nil {
+		return fmt.Errorf("lobby %s in %s does not exist", r.Lobby, r.UGI)
+	}
+
+	// Read the payload as a shared variable
+	bytes, err := json.Marshal(packet.Payload)
+	if err != nil {
+		return nil
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(bytes, &fields); err != nil {
+		return nil
+	}
+	if _, exists := fields["value"]; !exists {
+		return nil
+	}
+	variable := &structs.SharedVariable{}
+	if err := json.Unmarshal(bytes, variable); err != nil {
+		return nil
+	}
+	if err := r.Server.PacketValidator.Struct(variable); err != nil {
+		return nil
+	}
+
+	return manager.SetSharedValue(store, packet.Opcode == "G_LIST", variable, r.Peer, r.Peer.AmIAHost())
+}
+
+// SendState sends a snapshot of the lobby's global variables and lists to the relay's peer
+// using the STATE_SYNC opcode.
+func SendState(r *structs.Relay, channel string) error {
+	store := manager.GetLobbyStore(r.Server, r.Lobby, r.UGI)
+	if store == nil {
+		return fmt.Errorf("lobby %s in %s does not exist", r.Lobby, r.UGI)
+	}
+	return Code(r, "STATE_SYNC", channel, manager.GetStateSnapshot(store), nil)
+}
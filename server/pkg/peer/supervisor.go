@@ -0,0 +1,239 @@
+package peer
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// RelayTimeout is how long a relay may go without connecting to its peer, or stay failed or
+// disconnected, before the supervisor steps in.
+const RelayTimeout = 30 * time.Second
+
+// MaximumRestarts is how many times the supervisor restarts a relay or its ICE session before giving up on it.
+const MaximumRestarts = 3
+
+// SuperviseInterval is how often the supervisor checks on the server's relays.
+const SuperviseInterval = 5 * time.Second
+
+// Start spawns a supervised relay for a client in a lobby, stores it on the server and tells the
+// client to discover it. If the relay can't be spawned, the client is sent a RELAY_ERROR.
+func Start(s *structs.Server, ugi string, lobby string, client *structs.Client) (relay *structs.Relay, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logging.WithClient(logging.Relay(), client).Error("Relay panicked while starting", "ugi", ugi, "lobby", lobby, "panic", recovered, "stack", string(debug.Stack()))
+			err = fmt.Errorf("relay panicked while starting: %v", recovered)
+			relay = nil
+			relayError(client, "panic", err.Error(), false)
+		}
+	}()
+
+	// Spawn a new message relay
+	relay, err = Spawn(s, ugi, lobby, client)
+	if err != nil {
+		logging.WithClient(logging.Relay(), client).Error("Relay failed to start", "ugi", ugi, "lobby", lobby, "error", err)
+		relayError(client, "spawn", err.Error(), false)
+		return nil, err
+	}
+
+	// Store the relay
+	manager.SetRelay(s, client, relay)
+
+	// Tell the client to discover a new relay connection
+	message.Code(
+		client,
+		"DISCOVER",
+		&structs.NewPeerParams{
+			ID:   "relay",
+			User: "relay",
+		},
+		"",
+		nil,
+	)
+	return relay, nil
+}
+
+// Supervise checks on the server's relays every SuperviseInterval, reaping relays that have been
+// left behind and restarting relays that can't connect. It runs until the stop channel is closed.
+func Supervise(s *structs.Server, stop <-chan struct{}) {
+	ticker := time.NewTicker(SuperviseInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sweep(s)
+		}
+	}
+}
+
+// sweep checks on every relay on the server once.
+func sweep(s *structs.Server) {
+	for _, relay := range manager.GetAllRelays(s) {
+
+		// Reap relays whose peer has disconnected or left the lobby
+		if !manager.DoesPeerExist(s, relay.Peer.ID) || !manager.IsClientInLobby(s, relay.Lobby, relay.UGI, relay.Peer) {
+			logging.ForRelay(relay).Info("Reaping relay of a peer that left")
+			manager.DeleteRelay(s, relay.Peer)
+			continue
+		}
+
+		relay.Mux.RLock()
+		connected := relay.Connected
+		failed := relay.Failed
+		fallback := relay.Fallback
+		restarts := relay.Restarts
+		started := relay.Started
+		relay.Mux.RUnlock()
+
+		// Leave healthy relays alone. Peers that never connected over WebRTC may still be using the signaling websocket.
+		stalled := !connected && !fallback && time.Since(started) > RelayTimeout
+		broken := !failed.IsZero() && time.Since(failed) > RelayTimeout
+		if !stalled && !broken {
+			continue
+		}
+
+		// Give up on relays that keep failing. Peers using the signaling websocket keep their relay.
+		if restarts >= MaximumRestarts {
+			if fallback {
+				continue
+			}
+			logging.ForRelay(relay).Warn("Relay giving up", "restarts", restarts)
+			relayError(relay.Peer, "timeout", "The relay could not connect and has been shut down", false)
+			manager.DeleteRelay(s, relay.Peer)
+			continue
+		}
+
+		// Restart the ICE session of relays that were connected before, otherwise start over
+		if connected {
+			if err := restartIce(relay); err == nil {
+				continue
+			}
+		}
+		restart(s, relay)
+	}
+}
+
+// restartIce restarts the ICE session of a relay whose connection failed, counting it towards
+// the relay's restarts.
+func restartIce(r *structs.Relay) error {
+	r.Mux.Lock()
+	r.Restarts++
+	r.Failed = time.Now()
+	r.Mux.Unlock()
+	return RestartIce(r)
+}
+
+// RestartIce restarts the ICE session of the relay's peer connection using pion's ICE restart,
+// by sending the peer an offer with new ICE credentials. Data channels survive the restart.
+func RestartIce(r *structs.Relay) error {
+	offer, err := MakeOffer(r, true)
+	if err != nil {
+		logging.ForRelay(r).Warn("Relay ICE restart error", "error", err)
+		return err
+	}
+	logging.ForRelay(r).Info("Relay restarting ICE")
+
+	// Send the offer to the peer
+	return message.Code(
+		r.Peer,
+		"MAKE_OFFER",
+		&structs.RelayCandidate{
+			Type:     structs.DATA_CANDIDATE,
+			Contents: offer,
+		},
+		"",
+		&structs.PeerInfo{
+			ID:   "relay",
+			User: "relay",
+		},
+	)
+}
+
+// finishIceRestart completes an ICE restart that the peer requested with its relay, if there is
+// one, and tells the peer using the ICE_RESTART_DONE opcode.
+func finishIceRestart(r *structs.Relay) {
+	if manager.FinishIceRestart(r.Server, r.Lobby, r.UGI, r.Peer.ID, "relay") == nil {
+		return
+	}
+	message.Code(
+		r.Peer,
+		"ICE_RESTART_DONE",
+		nil,
+		"",
+		&structs.PeerInfo{
+			ID:   "relay",
+			User: "relay",
+		},
+	)
+}
+
+// restart replaces a relay with a new one, which the peer is told to discover.
+func restart(s *structs.Server, r *structs.Relay) {
+	logging.ForRelay(r).Info("Relay restarting")
+	relayError(r.Peer, "timeout", "The relay could not connect and is being restarted", true)
+	manager.DeleteRelay(s, r.Peer)
+
+	relay, err := Start(s, r.UGI, r.Lobby, r.Peer)
+	if err != nil {
+		return
+	}
+	relay.Mux.Lock()
+	relay.Restarts = r.Restarts + 1
+	relay.Mux.Unlock()
+}
+
+// markConnected records that the relay's peer connection is connected.
+func markConnected(r *structs.Relay) {
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+	r.Running = true
+	r.Connected = true
+	r.Failed = time.Time{}
+}
+
+// markFailed records when the relay's peer connection failed or disconnected. Failed connections
+// are no longer running.
+func markFailed(r *structs.Relay, failed bool) {
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+	if failed {
+		r.Running = false
+	}
+	if r.Failed.IsZero() {
+		r.Failed = time.Now()
+	}
+}
+
+// relayError tells a client that its relay failed using the RELAY_ERROR opcode.
+func relayError(client *structs.Client, reason string, text string, restarting bool) {
+	message.Code(
+		client,
+		"RELAY_ERROR",
+		&structs.RelayError{
+			Reason:     reason,
+			Message:    text,
+			Restarting: restarting,
+		},
+		"",
+		&structs.PeerInfo{
+			ID:   "relay",
+			User: "relay",
+		},
+	)
+}
+
+// guard recovers from a panic in one of the relay's handlers, so that a single misbehaving relay
+// can't take down the server, and tells the peer using the RELAY_ERROR opcode. It must be deferred.
+func guard(r *structs.Relay) {
+	if recovered := recover(); recovered != nil {
+		logging.ForRelay(r).Error("Relay recovered from panic", "panic", recovered, "stack", string(debug.Stack()))
+		relayError(r.Peer, "panic", fmt.Sprintf("%v", recovered), false)
+	}
+}
@@ -0,0 +1,143 @@
+package peer
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/pion/webrtc/v4"
+)
+
+// settle waits for goroutines that are shutting down to exit, and fails the test if more than
+// the baseline number of goroutines are still running after the timeout.
+func settle(t *testing.T, baseline int, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines are running, want at most %d:\n%s", runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// connectData connects a pion client to the relay's default data channel and waits until the
+// relay's peer connection is connected.
+func connectData(t *testing.T, r *structs.Relay) *webrtc.PeerConnection {
+	t.Helper()
+	conn, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	yes := true
+	zero := uint16(0)
+	protocol := "clomega"
+	if _, err := conn.CreateDataChannel("default", &webrtc.DataChannelInit{Negotiated: &yes, ID: &zero, Ordered: &yes, Protocol: &protocol}); err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := conn.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(conn)
+	if err := conn.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	answer, err := MakeAnswerFromOffer(r, conn.LocalDescription())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetRemoteDescription(*answer); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, 10*time.Second, func() bool {
+		r.Mux.RLock()
+		defer r.Mux.RUnlock()
+		return r.Connected
+	}, "the relay didn't connect to its peer")
+	return conn
+}
+
+func TestSuperviseStops(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	s := newServer(t)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		Supervise(s, stop)
+		close(done)
+	}()
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the supervisor didn't stop when its stop channel was closed")
+	}
+	settle(t, baseline, 5*time.Second)
+}
+
+func TestRelaysDontLeak(t *testing.T) {
+	s := newServer(t)
+
+	// Warm up pion, which starts a few goroutines that live as long as the process
+	warmup := join(t, s, "warmup")
+	connectData(t, warmup).Close()
+	manager.DeleteRelay(s, warmup.Peer)
+	time.Sleep(500 * time.Millisecond)
+	baseline := runtime.NumGoroutine()
+
+	var relays []*structs.Relay
+	var clients []*webrtc.PeerConnection
+	for i := 0; i < 4; i++ {
+		relay := join(t, s, fmt.Sprint(i))
+		relays = append(relays, relay)
+		clients = append(clients, connectData(t, relay))
+	}
+	if runtime.NumGoroutine() <= baseline {
+		t.Fatal("connected relays should be running goroutines")
+	}
+
+	for i, relay := range relays {
+		manager.DeleteRelay(s, relay.Peer)
+		clients[i].Close()
+	}
+	for _, relay := range relays {
+		if state := relay.Conn.ConnectionState(); state != webrtc.PeerConnectionStateClosed {
+			t.Fatalf("relay %s peer connection is %s after it was deleted, want closed", relay.Peer.ID, state)
+		}
+		relay.Mux.RLock()
+		running := relay.Running
+		relay.Mux.RUnlock()
+		if running {
+			t.Fatalf("relay %s is still running after it was deleted", relay.Peer.ID)
+		}
+	}
+	if relays := manager.GetAllRelays(s); len(relays) != 0 {
+		t.Fatalf("%d relays are left on the server", len(relays))
+	}
+	settle(t, baseline, 10*time.Second)
+}
+
+func TestSweepReapsRelaysOfPeersThatLeft(t *testing.T) {
+	s := newServer(t)
+	baseline := runtime.NumGoroutine()
+	relay := join(t, s, "a")
+
+	manager.RemoveClientFromLobby(s, testLobby, testGame, relay.Peer)
+	sweep(s)
+	if manager.GetRelay(s, relay.Peer) != nil {
+		t.Fatal("the supervisor didn't reap the relay of a peer that left the lobby")
+	}
+	if state := relay.Conn.ConnectionState(); state != webrtc.PeerConnectionStateClosed {
+		t.Fatalf("the reaped relay's peer connection is %s, want closed", state)
+	}
+	settle(t, baseline, 10*time.Second)
+}
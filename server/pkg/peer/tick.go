@@ -0,0 +1,218 @@
+package peer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// StartTicks starts a lockstep tick loop for a lobby. Every frame, the loop collects one input
+// from each peer connected to the relay, and broadcasts them as a TICK bundle once every peer
+// has reported or the frame deadline passes. The loop runs until the lobby is destroyed.
+func StartTicks(s *structs.Server, ugi string, lobby string, settings *structs.TickSettings) {
+	loop := &structs.TickLoop{
+		Settings: settings,
+		Frame:    1,
+		Inputs:   make(map[string]any),
+		Last:     make(map[string]any),
+		Ready:    make(chan bool, 1),
+		Stop:     make(chan bool),
+	}
+	manager.SetLobbyTicker(s, lobby, ugi, loop)
+
+	logging.Relay().Info("Tick loop starting", "ugi", ugi, "lobby", lobby, "rate", settings.Rate)
+	go ticker(s, ugi, lobby, loop)
+}
+
+// ticker runs a lobby's tick loop.
+func ticker(s *structs.Server, ugi string, lobby string, loop *structs.TickLoop) {
+	interval := time.Second / time.Duration(loop.Settings.Rate)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		deadline := false
+		select {
+		case <-loop.Stop:
+			logging.Relay().Info("Tick loop shutting down", "ugi", ugi, "lobby", lobby)
+			return
+		case <-loop.Ready:
+		case <-timer.C:
+			deadline = true
+		}
+
+		relays := manager.WithoutRelay(manager.GetRelayPeers(s, lobby, ugi), nil)
+		bundle := closeFrame(loop, relays, deadline)
+		if bundle == nil {
+			// Wait for another deadline if the frame is being stalled or the lobby is idle
+			if deadline {
+				timer.Reset(interval)
+			}
+			continue
+		}
+
+		Broadcast(
+			relays,
+			"default",
+			&structs.RelayPacket{
+				Opcode:  "TICK",
+				Frame:   bundle.Frame,
+				Payload: bundle,
+			},
+		)
+
+		// Start the deadline of the next frame
+		timer.Reset(interval)
+	}
+}
+
+// reported checks if every relay has submitted an input for the frame being collected.
+// The caller must hold the loop's lock.
+func reported(loop *structs.TickLoop, relays []*structs.Relay) bool {
+	for _, relay := range relays {
+		if _, exists := loop.Inputs[relay.Peer.ID]; !exists {
+			return false
+		}
+	}
+	return len(relays) > 0
+}
+
+// closeFrame finishes the frame being collected and returns its bundle, applying the lobby's
+// policy to peers that haven't reported. It returns nil if the frame isn't ready yet, is
+// being stalled to wait for slow peers, or nobody is connected to the relay.
+func closeFrame(loop *structs.TickLoop, relays []*structs.Relay, deadline bool) *structs.TickBundle {
+	loop.Mux.Lock()
+	defer loop.Mux.Unlock()
+
+	// Idle while nobody is connected to the relay
+	if len(relays) == 0 {
+		return nil
+	}
+
+	complete := reported(loop, relays)
+	if !complete && !deadline {
+		return nil
+	}
+
+	// Find the peers that didn't report in time
+	var missing []string
+	for _, relay := range relays {
+		if _, exists := loop.Inputs[relay.Peer.ID]; !exists {
+			missing = append(missing, relay.Peer.ID)
+		}
+	}
+
+	// Apply the policy
+	if len(missing) > 0 {
+		switch loop.Settings.Policy {
+		case "stall":
+			if loop.Stalled < loop.Settings.MaxStall {
+				loop.Stalled++
+				return nil
+			}
+		case "repeat":
+			for _, id := range missing {
+				if input, exists := loop.Last[id]; exists {
+					loop.Inputs[id] = input
+				}
+			}
+		}
+	}
+
+	bundle := &structs.TickBundle{
+		Frame:   loop.Frame,
+		Inputs:  loop.Inputs,
+		Missing: missing,
+	}
+	for id, input := range loop.Inputs {
+		loop.Last[id] = input
+	}
+
+	// Forget peers that left the lobby
+	for id := range loop.Last {
+		if !hasPeer(relays, id) {
+			delete(loop.Last, id)
+		}
+	}
+
+	// Buffer the frame for late joiners
+	if loop.Settings.Window > 0 {
+		loop.History = append(loop.History, bundle)
+		if len(loop.History) > loop.Settings.Window {
+			loop.History = loop.History[len(loop.History)-loop.Settings.Window:]
+		}
+	}
+
+	// Start collecting the next frame
+	loop.Frame++
+	loop.Inputs = make(map[string]any)
+	loop.Stalled = 0
+	return bundle
+}
+
+// hasPeer checks if any of the relays belongs to the peer with the given ID.
+func hasPeer(relays []*structs.Relay, id string) bool {
+	for _, relay := range relays {
+		if relay.Peer.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Input handles an INPUT packet, recording the peer's input for the frame being collected.
+// If the packet names a frame, it must be the frame being collected.
+func Input(r *structs.Relay, packet *structs.RelayPacket) error {
+	loop := manager.GetLobbyTicker(r.Server, r.Lobby, r.UGI)
+	if loop == nil {
+		return fmt.Errorf("tick mode is not enabled in this lobby")
+	}
+
+	relays := manager.WithoutRelay(manager.GetRelayPeers(r.Server, r.Lobby, r.UGI), nil)
+	loop.Mux.Lock()
+	if packet.Frame != 0 && packet.Frame != loop.Frame {
+		frame := loop.Frame
+		loop.Mux.Unlock()
+		return fmt.Errorf("input for frame %d arrived while collecting frame %d", packet.Frame, frame)
+	}
+	loop.Inputs[r.Peer.ID] = packet.Payload
+	complete := reported(loop, relays)
+	loop.Mux.Unlock()
+
+	// Close the frame early if everyone has reported
+	if complete {
+		select {
+		case loop.Ready <- true:
+		default:
+		}
+	}
+	return nil
+}
+
+// SendTicks sends the frames buffered by the lobby's tick loop to the relay's peer using the
+// TICK_SYNC opcode, so that late joiners can catch up.
+func SendTicks(r *structs.Relay, channel string) error {
+	loop := manager.GetLobbyTicker(r.Server, r.Lobby, r.UGI)
+	if loop == nil {
+		return fmt.Errorf("tick mode is not enabled in this lobby")
+	}
+
+	loop.Mux.Lock()
+	history := make([]*structs.TickBundle, len(loop.History))
+	copy(history, loop.History)
+	frame := loop.Frame
+	loop.Mux.Unlock()
+
+	return Send(
+		r,
+		channel,
+		&structs.RelayPacket{
+			Opcode:  "TICK_SYNC",
+			Frame:   frame,
+			Payload: history,
+		},
+	)
+}
@@ -0,0 +1,119 @@
+package peer
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// WildcardTopic subscribes a peer to every G_* broadcast. Relays start out subscribed to it,
+// so clients that don't know about topics keep receiving everything.
+const WildcardTopic = "*"
+
+// MaximumTopics is the number of topics a peer may be subscribed to at once.
+const MaximumTopics = 64
+
+// MaximumTopicLength is the longest topic a peer may subscribe to, in bytes.
+const MaximumTopicLength = 128
+
+// topic returns the topic of a G_* packet: its topic if set, otherwise its channel.
+func topic(packet *structs.RelayPacket) string {
+	if packet.Topic != "" {
+		return packet.Topic
+	}
+	return packet.Channel
+}
+
+// IsSubscribed checks if the relay's peer wants broadcasts for the given topic.
+func IsSubscribed(r *structs.Relay, topic string) bool {
+	r.Mux.RLock()
+	defer r.Mux.RUnlock()
+	return r.Topics[WildcardTopic] || (topic != "" && r.Topics[topic])
+}
+
+// GetTopics returns the topics the relay's peer is subscribed to, in sorted order.
+func GetTopics(r *structs.Relay) []string {
+	r.Mux.RLock()
+	defer r.Mux.RUnlock()
+	topics := make([]string, 0, len(r.Topics))
+	for topic := range r.Topics {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	return topics
+}
+
+// subscribers returns every other relay in the lobby that is subscribed to the given topic.
+func subscribers(r *structs.Relay, topic string) []*structs.Relay {
+	var relays []*structs.Relay
+	for _, other := range manager.WithoutRelay(manager.GetRelayPeers(r.Server, r.Lobby, r.UGI), r) {
+		if IsSubscribed(other, topic) {
+			relays = append(relays, other)
+		}
+	}
+	return relays
+}
+
+// readTopics reads the payload of a SUBSCRIBE or UNSUBSCRIBE packet, which is either a single
+// topic or a list of topics. Topics must not be longer than MaximumTopicLength.
+func readTopics(payload any) ([]string, error) {
+	var topics []string
+	switch value := payload.(type) {
+	case string:
+		topics = []string{value}
+	case []any:
+		if len(value) > MaximumTopics {
+			return nil, fmt.Errorf("payload (topics) must not list more than %d topics", MaximumTopics)
+		}
+		topics = make([]string, 0, len(value))
+		for _, item := range value {
+			topic, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("payload (topics) must be a string or a list of strings")
+			}
+			topics = append(topics, topic)
+		}
+	default:
+		return nil, fmt.Errorf("payload (topics) must be a string or a list of strings")
+	}
+	for _, topic := range topics {
+		if len(topic) > MaximumTopicLength {
+			return nil, fmt.Errorf("topics must not be longer than %d bytes", MaximumTopicLength)
+		}
+	}
+	return topics, nil
+}
+
+// SetTopics subscribes or unsubscribes the relay's peer from the topics in the payload of a
+// SUBSCRIBE or UNSUBSCRIBE packet. Subscriptions that would take the peer over MaximumTopics
+// are rejected as a whole.
+func SetTopics(r *structs.Relay, payload any, subscribed bool) error {
+	topics, err := readTopics(payload)
+	if err != nil {
+		return err
+	}
+
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+	if subscribed {
+		added := 0
+		for _, topic := range slices.Compact(slices.Sorted(slices.Values(topics))) {
+			if !r.Topics[topic] {
+				added++
+			}
+		}
+		if len(r.Topics)+added > MaximumTopics {
+			return fmt.Errorf("peers may not subscribe to more than %d topics", MaximumTopics)
+		}
+	}
+	for _, topic := range topics {
+		if subscribed {
+			r.Topics[topic] = true
+		} else {
+			delete(r.Topics, topic)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package peer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+func TestTopicLimits(t *testing.T) {
+	r := &structs.Relay{Mux: &sync.RWMutex{}, Topics: map[string]bool{WildcardTopic: true}}
+
+	if err := SetTopics(r, strings.Repeat("a", MaximumTopicLength+1), true); err == nil {
+		t.Fatal("a topic longer than the maximum was accepted")
+	}
+
+	topics := []any{}
+	for i := 1; i < MaximumTopics; i++ {
+		topics = append(topics, fmt.Sprint(i))
+	}
+	if err := SetTopics(r, topics, true); err != nil {
+		t.Fatalf("subscribing up to the maximum failed: %v", err)
+	}
+	if err := SetTopics(r, "one too many", true); err == nil {
+		t.Fatal("subscribing past the maximum was accepted")
+	}
+	if err := SetTopics(r, "1", true); err != nil {
+		t.Fatalf("subscribing to a topic again at the maximum failed: %v", err)
+	}
+	if len(GetTopics(r)) != MaximumTopics {
+		t.Fatalf("got %d topics, want %d", len(GetTopics(r)), MaximumTopics)
+	}
+}
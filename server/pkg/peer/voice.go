@@ -0,0 +1,447 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/pion/webrtc/v4"
+)
+
+// getVoice returns the voice state of a relay, creating the voice peer connection
+// if the peer hasn't negotiated one yet.
+func getVoice(r *structs.Relay) (*structs.VoiceRelay, error) {
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+	if r.Voice != nil {
+		return r.Voice, nil
+	}
+
+	// Create a new RTCPeerConnection for voice
+	conn, err := webrtc.NewPeerConnection(configuration(r.Server))
+	if err != nil {
+		return nil, err
+	}
+
+	r.Voice = &structs.VoiceRelay{
+		Mux:          &sync.Mutex{},
+		Conn:         conn,
+		Senders:      make(map[string]*webrtc.RTPSender),
+		Unsubscribed: make(map[string]bool),
+	}
+
+	logging.ForRelay(r).Info("Relay starting voice")
+	voicehandler(r, r.Voice)
+	return r.Voice, nil
+}
+
+// MakeVoiceAnswerFromOffer handles an SDP offer for the relay's voice connection and
+// returns the answer. Once answered, the peer is subscribed to the audio of every other
+// peer in the lobby that is publishing to the relay.
+func MakeVoiceAnswerFromOffer(r *structs.Relay, offer *webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	v, err := getVoice(r)
+	if err != nil {
+		return nil, err
+	}
+
+	v.Mux.Lock()
+
+	// If the relay has an offer in flight, roll it back and offer again once this negotiation completes.
+	if v.Conn.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		if err := v.Conn.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+			v.Mux.Unlock()
+			return nil, err
+		}
+		v.PendingRenegotiation = true
+	}
+
+	// Set the remote description.
+	if err := v.Conn.SetRemoteDescription(*offer); err != nil {
+		v.Mux.Unlock()
+		return nil, err
+	}
+
+	// Make answer
+	answer, err := v.Conn.CreateAnswer(&webrtc.AnswerOptions{})
+	if err != nil {
+		v.Mux.Unlock()
+		return nil, err
+	}
+
+	// Set the local description.
+	if err := v.Conn.SetLocalDescription(answer); err != nil {
+		v.Mux.Unlock()
+		return nil, err
+	}
+	local := v.Conn.LocalDescription()
+	pending := v.PendingRenegotiation
+	v.PendingRenegotiation = false
+	v.Mux.Unlock()
+
+	// Subscribe to everyone else in the lobby. Subscribing renegotiates once the answer has been sent.
+	go func() {
+		for _, other := range voiceRelays(r) {
+			subscribe(r, other)
+		}
+		if pending {
+			renegotiate(r)
+		}
+	}()
+
+	return local, nil
+}
+
+// HandleVoiceAnswer handles the peer's answer to an offer made by the relay's voice connection.
+// If another renegotiation was requested while the offer was in flight, a new offer is made.
+func HandleVoiceAnswer(r *structs.Relay, answer *webrtc.SessionDescription) error {
+	r.Mux.RLock()
+	v := r.Voice
+	r.Mux.RUnlock()
+	if v == nil {
+		return errors.New("voice connection was not negotiated")
+	}
+
+	v.Mux.Lock()
+	if err := v.Conn.SetRemoteDescription(*answer); err != nil {
+		v.Mux.Unlock()
+		return err
+	}
+	pending := v.PendingRenegotiation
+	v.PendingRenegotiation = false
+	v.Mux.Unlock()
+
+	if pending {
+		renegotiate(r)
+	}
+	return nil
+}
+
+// HandleVoiceIce adds an ICE candidate to the relay's voice connection.
+func HandleVoiceIce(r *structs.Relay, ice *webrtc.ICECandidateInit) error {
+	r.Mux.RLock()
+	v := r.Voice
+	r.Mux.RUnlock()
+	if v == nil {
+		return errors.New("voice connection was not negotiated")
+	}
+	return v.Conn.AddICECandidate(*ice)
+}
+
+// SetMuted mutes or unmutes the peer's audio. While muted, the relay drops the peer's
+// audio instead of forwarding it. The other relays in the lobby are told about the change.
+func SetMuted(r *structs.Relay, muted bool) error {
+	r.Mux.RLock()
+	v := r.Voice
+	r.Mux.RUnlock()
+	if v == nil {
+		return errors.New("voice connection was not negotiated")
+	}
+
+	v.Mux.Lock()
+	v.Muted = muted
+	v.Mux.Unlock()
+
+	opcode := "VOICE_UNMUTE"
+	if muted {
+		opcode = "VOICE_MUTE"
+	}
+	Broadcast(
+		manager.WithoutRelay(manager.GetRelayPeers(r.Server, r.Lobby, r.UGI), r),
+		"default",
+		&structs.RelayPacket{
+			Opcode: opcode,
+			Origin: &structs.PeerInfo{
+				ID:   r.Peer.ID,
+				User: r.Peer.Username,
+			},
+		},
+	)
+	return nil
+}
+
+// Subscribe resumes forwarding audio from the given peer to the relay's peer.
+func Subscribe(r *structs.Relay, id string) error {
+	publisher := voiceRelay(r, id)
+	if publisher == nil {
+		return fmt.Errorf("peer %s is not publishing voice to the relay", id)
+	}
+
+	r.Mux.RLock()
+	v := r.Voice
+	r.Mux.RUnlock()
+	if v == nil {
+		return errors.New("voice connection was not negotiated")
+	}
+
+	v.Mux.Lock()
+	delete(v.Unsubscribed, id)
+	v.Mux.Unlock()
+
+	subscribe(r, publisher)
+	return nil
+}
+
+// Unsubscribe stops forwarding audio from the given peer to the relay's peer.
+func Unsubscribe(r *structs.Relay, id string) error {
+	r.Mux.RLock()
+	v := r.Voice
+	r.Mux.RUnlock()
+	if v == nil {
+		return errors.New("voice connection was not negotiated")
+	}
+
+	v.Mux.Lock()
+	v.Unsubscribed[id] = true
+	v.Mux.Unlock()
+
+	unsubscribe(r, id)
+	return nil
+}
+
+// CloseVoice stops forwarding the peer's audio to the other relays in the lobby
+// and closes the relay's voice connection.
+func CloseVoice(r *structs.Relay) {
+	r.Mux.Lock()
+	v := r.Voice
+	r.Voice = nil
+	r.Mux.Unlock()
+	if v == nil {
+		return
+	}
+
+	for _, other := range voiceRelays(r) {
+		unsubscribe(other, r.Peer.ID)
+	}
+
+	if err := v.Conn.Close(); err != nil {
+		logging.ForRelay(r).Warn("Relay voice close error", "error", err)
+	}
+}
+
+// voiceRelays returns every other relay in the lobby that has a voice connection.
+func voiceRelays(r *structs.Relay) []*structs.Relay {
+	var relays []*structs.Relay
+	for _, other := range manager.WithoutRelay(manager.GetRelayPeers(r.Server, r.Lobby, r.UGI), r) {
+		other.Mux.RLock()
+		if other.Voice != nil {
+			relays = append(relays, other)
+		}
+		other.Mux.RUnlock()
+	}
+	return relays
+}
+
+// voiceRelay returns the relay in the same lobby as r that belongs to the peer with the given ID,
+// or nil if that peer has no voice connection.
+func voiceRelay(r *structs.Relay, id string) *structs.Relay {
+	for _, other := range voiceRelays(r) {
+		if other.Peer.ID == id {
+			return other
+		}
+	}
+	return nil
+}
+
+// subscribe forwards the audio published to the publisher relay to the subscriber relay's peer,
+// unless the subscriber has opted out or is already receiving it.
+func subscribe(subscriber *structs.Relay, publisher *structs.Relay) {
+	subscriber.Mux.RLock()
+	sv := subscriber.Voice
+	subscriber.Mux.RUnlock()
+	publisher.Mux.RLock()
+	pv := publisher.Voice
+	publisher.Mux.RUnlock()
+	if sv == nil || pv == nil {
+		return
+	}
+
+	pv.Mux.Lock()
+	track := pv.Track
+	pv.Mux.Unlock()
+	if track == nil {
+		return
+	}
+
+	sv.Mux.Lock()
+	if _, exists := sv.Senders[publisher.Peer.ID]; exists || sv.Unsubscribed[publisher.Peer.ID] {
+		sv.Mux.Unlock()
+		return
+	}
+	sender, err := sv.Conn.AddTrack(track)
+	if err != nil {
+		sv.Mux.Unlock()
+		logging.ForRelay(subscriber).Warn("Relay failed to forward voice", "publisher", publisher.Peer.ID, "error", err)
+		return
+	}
+	sv.Senders[publisher.Peer.ID] = sender
+	sv.Mux.Unlock()
+
+	// Read incoming RTCP packets so that interceptors can process them.
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	renegotiate(subscriber)
+}
+
+// unsubscribe stops forwarding the audio of the peer with the given ID to the subscriber relay's peer.
+func unsubscribe(subscriber *structs.Relay, id string) {
+	subscriber.Mux.RLock()
+	sv := subscriber.Voice
+	subscriber.Mux.RUnlock()
+	if sv == nil {
+		return
+	}
+
+	sv.Mux.Lock()
+	sender, exists := sv.Senders[id]
+	if !exists {
+		sv.Mux.Unlock()
+		return
+	}
+	delete(sv.Senders, id)
+	err := sv.Conn.RemoveTrack(sender)
+	sv.Mux.Unlock()
+	if err != nil {
+		logging.ForRelay(subscriber).Warn("Relay failed to stop forwarding voice", "publisher", id, "error", err)
+		return
+	}
+
+	renegotiate(subscriber)
+}
+
+// renegotiate makes a new offer on the relay's voice connection and sends it to the peer.
+// If an offer is already in flight, the renegotiation is deferred until the peer answers.
+func renegotiate(r *structs.Relay) {
+	r.Mux.RLock()
+	v := r.Voice
+	r.Mux.RUnlock()
+	if v == nil {
+		return
+	}
+
+	v.Mux.Lock()
+	if v.Conn.SignalingState() != webrtc.SignalingStateStable {
+		v.PendingRenegotiation = true
+		v.Mux.Unlock()
+		return
+	}
+
+	// Create an offer.
+	offer, err := v.Conn.CreateOffer(&webrtc.OfferOptions{})
+	if err == nil {
+		err = v.Conn.SetLocalDescription(offer)
+	}
+	if err != nil {
+		v.Mux.Unlock()
+		logging.ForRelay(r).Warn("Relay voice renegotiation error", "error", err)
+		return
+	}
+	local := v.Conn.LocalDescription()
+	v.Mux.Unlock()
+
+	// Send the offer to the peer
+	message.Code(
+		r.Peer,
+		"MAKE_OFFER",
+		&structs.RelayCandidate{
+			Type:     structs.VOICE_CANDIDATE,
+			Contents: local,
+		},
+		r.Lobby,
+		&structs.PeerInfo{
+			ID:   "relay",
+			User: "relay",
+		},
+	)
+}
+
+// voicehandler handles events related to the relay's voice connection, such as incoming
+// audio tracks, connection state changes, and ICE candidates.
+func voicehandler(r *structs.Relay, v *structs.VoiceRelay) {
+
+	// Handle connection state changes
+	v.Conn.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		logging.ForRelay(r).Info("Relay voice state changed", "state", s.String())
+
+		// Stop forwarding the peer's audio if the voice connection is gone.
+		if s == webrtc.PeerConnectionStateFailed {
+			CloseVoice(r)
+		}
+	})
+
+	// Handle ICE candidates
+	v.Conn.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+
+		// Send the ICE candidate
+		message.Code(
+			r.Peer,
+			"ICE",
+			&structs.RelayOutboundIce{
+				Type:     structs.VOICE_CANDIDATE,
+				Contents: c,
+			},
+			r.Lobby,
+			&structs.PeerInfo{
+				ID:   "relay",
+				User: "relay",
+			},
+		)
+	})
+
+	// Forward incoming audio to every other relay in the lobby
+	v.Conn.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if remote.Kind() != webrtc.RTPCodecTypeAudio {
+			logging.ForRelay(r).Debug("Relay ignoring track", "kind", remote.Kind().String())
+			return
+		}
+
+		local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, "audio", r.Peer.ID)
+		if err != nil {
+			logging.ForRelay(r).Warn("Relay failed to publish voice", "error", err)
+			return
+		}
+
+		v.Mux.Lock()
+		v.Track = local
+		v.Mux.Unlock()
+
+		logging.ForRelay(r).Info("Relay publishing voice")
+		for _, other := range voiceRelays(r) {
+			subscribe(other, r)
+		}
+
+		// Copy RTP packets to the local track, which writes them to every subscriber.
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := remote.Read(buf)
+			if err != nil {
+				return
+			}
+
+			v.Mux.Lock()
+			muted := v.Muted
+			v.Mux.Unlock()
+			if muted {
+				continue
+			}
+
+			if _, err := local.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				return
+			}
+		}
+	})
+}
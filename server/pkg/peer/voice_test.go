@@ -0,0 +1,228 @@
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// voiceClient is a pion client at the other end of a relay's voice connection.
+type voiceClient struct {
+	conn     *webrtc.PeerConnection
+	track    *webrtc.TrackLocalStaticSample
+	received chan string // stream ID of every RTP packet received, which is the publishing peer's ID
+}
+
+// connectVoice negotiates a voice connection between a new pion client and the relay. The client
+// answers every renegotiation the relay makes, and publishes audio until the test ends.
+func connectVoice(t *testing.T, r *structs.Relay) *voiceClient {
+	t.Helper()
+	conn, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, "audio", r.Peer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	client := &voiceClient{conn: conn, track: track, received: make(chan string, 1024)}
+	conn.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		for {
+			if _, _, err := remote.ReadRTP(); err != nil {
+				return
+			}
+			select {
+			case client.received <- remote.StreamID():
+			default:
+			}
+		}
+	})
+
+	// Offer with every candidate, since the relay's ICE messages have no websocket to go to
+	offer, err := conn.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(conn)
+	if err := conn.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	answer, err := MakeVoiceAnswerFromOffer(r, conn.LocalDescription())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetRemoteDescription(*answer); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		conn.Close()
+	})
+
+	// Answer the relay's renegotiations, which it makes when it forwards a new track
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+			r.Mux.RLock()
+			v := r.Voice
+			r.Mux.RUnlock()
+			if v == nil || v.Conn.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+				continue
+			}
+			if err := conn.SetRemoteDescription(*v.Conn.LocalDescription()); err != nil {
+				continue
+			}
+			answer, err := conn.CreateAnswer(nil)
+			if err == nil {
+				err = conn.SetLocalDescription(answer)
+			}
+			if err == nil {
+				err = HandleVoiceAnswer(r, conn.LocalDescription())
+			}
+			if err != nil {
+				t.Errorf("renegotiation failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	// Publish 20ms Opus frames of silence
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+			client.track.WriteSample(media.Sample{Data: []byte{0xf8, 0xff, 0xfe}, Duration: 20 * time.Millisecond})
+		}
+	}()
+
+	return client
+}
+
+// receives reports whether the client receives audio from the given peer within the timeout.
+func (c *voiceClient) receives(from string, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case id := <-c.received:
+			if id == from {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// drain discards the packets the client has received so far.
+func (c *voiceClient) drain() {
+	for {
+		select {
+		case <-c.received:
+		default:
+			return
+		}
+	}
+}
+
+func senders(r *structs.Relay) map[string]bool {
+	r.Mux.RLock()
+	v := r.Voice
+	r.Mux.RUnlock()
+	v.Mux.Lock()
+	defer v.Mux.Unlock()
+	ids := make(map[string]bool)
+	for id := range v.Senders {
+		ids[id] = true
+	}
+	return ids
+}
+
+func TestVoiceForwarding(t *testing.T) {
+	s := newServer(t)
+	a := join(t, s, "a")
+	b := join(t, s, "b")
+
+	alice := connectVoice(t, a)
+	bob := connectVoice(t, b)
+
+	if !bob.receives("a", 10*time.Second) {
+		t.Fatal("b didn't receive a's audio through the relay")
+	}
+	if !alice.receives("b", 10*time.Second) {
+		t.Fatal("a didn't receive b's audio through the relay")
+	}
+}
+
+func TestVoiceMute(t *testing.T) {
+	s := newServer(t)
+	a := join(t, s, "a")
+	b := join(t, s, "b")
+
+	connectVoice(t, a)
+	bob := connectVoice(t, b)
+	if !bob.receives("a", 10*time.Second) {
+		t.Fatal("b didn't receive a's audio through the relay")
+	}
+
+	if err := SetMuted(a, true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	bob.drain()
+	if bob.receives("a", 500*time.Millisecond) {
+		t.Fatal("the relay forwarded a's audio while a was muted")
+	}
+
+	if err := SetMuted(a, false); err != nil {
+		t.Fatal(err)
+	}
+	if !bob.receives("a", 5*time.Second) {
+		t.Fatal("the relay didn't forward a's audio after a was unmuted")
+	}
+}
+
+func TestVoiceSubscription(t *testing.T) {
+	s := newServer(t)
+	a := join(t, s, "a")
+	b := join(t, s, "b")
+
+	connectVoice(t, a)
+	bob := connectVoice(t, b)
+	if !bob.receives("a", 10*time.Second) {
+		t.Fatal("b didn't receive a's audio through the relay")
+	}
+
+	if err := Unsubscribe(b, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if senders(b)["a"] {
+		t.Fatal("the relay still forwards a's audio to b after b unsubscribed")
+	}
+
+	if err := Subscribe(b, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if !senders(b)["a"] {
+		t.Fatal("the relay doesn't forward a's audio to b after b subscribed again")
+	}
+
+	if err := Subscribe(b, "nobody"); err == nil {
+		t.Fatal("subscribing to a peer that isn't publishing succeeded")
+	}
+}
@@ -0,0 +1,71 @@
+package address
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseNetworks parses a comma separated list of networks in CIDR notation. Plain IP addresses
+// are treated as networks of a single address.
+func ParseNetworks(text string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(text, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Contains checks if an IP address is in any of the given networks.
+func Contains(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the IP address of the client that made a request. If the request was made
+// by a trusted proxy, the X-Forwarded-For headers are joined in the order they were received
+// and read from right to left, skipping trusted proxies, and the first untrusted address is
+// the client. Otherwise, the headers are ignored, since anyone can set them. Every header is
+// read because some proxies add their own header rather than appending to the client's.
+func Resolve(remote net.IP, forwarded []string, trusted []*net.IPNet) net.IP {
+	if len(forwarded) == 0 || !Contains(remote, trusted) {
+		return remote
+	}
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// Everything to the left of a malformed hop is unreliable
+			return remote
+		}
+		remote = hop
+		if !Contains(hop, trusted) {
+			return hop
+		}
+	}
+	return remote
+}
@@ -0,0 +1,57 @@
+package address
+
+import (
+	"net"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	trusted, err := ParseNetworks("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"no header", "198.51.100.1", nil, "198.51.100.1"},
+		{"untrusted remote", "198.51.100.1", []string{"203.0.113.1"}, "198.51.100.1"},
+		{"trusted proxy", "10.0.0.1", []string{"203.0.113.1"}, "203.0.113.1"},
+		{"chain of trusted proxies", "10.0.0.1", []string{"203.0.113.1, 10.0.0.2, 192.0.2.1"}, "203.0.113.1"},
+		{"spoofed hops left of the client", "10.0.0.1", []string{"198.51.100.9, 203.0.113.1"}, "203.0.113.1"},
+		{"spoofed header before the proxy's own header", "10.0.0.1", []string{"198.51.100.9", "203.0.113.1"}, "203.0.113.1"},
+		{"trusted hops across headers", "10.0.0.1", []string{"203.0.113.1", "10.0.0.2"}, "203.0.113.1"},
+		{"only trusted hops", "10.0.0.1", []string{"10.0.0.2, 10.0.0.3"}, "10.0.0.2"},
+		{"malformed hop", "10.0.0.1", []string{"203.0.113.1, not-an-ip"}, "10.0.0.1"},
+		{"malformed hop left of the client", "10.0.0.1", []string{"not-an-ip, 203.0.113.1"}, "203.0.113.1"},
+		{"empty header", "10.0.0.1", []string{""}, "10.0.0.1"},
+		{"IPv6 client", "10.0.0.1", []string{"2001:db8::1"}, "2001:db8::1"},
+	} {
+		got := Resolve(net.ParseIP(test.remote), test.forwarded, trusted)
+		if !got.Equal(net.ParseIP(test.want)) {
+			t.Errorf("%s: resolved %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks(" 10.0.0.0/8, 192.0.2.1,,2001:db8::1 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 3 {
+		t.Fatalf("parsed %d networks, want 3", len(networks))
+	}
+	for ip, want := range map[string]bool{"10.1.2.3": true, "192.0.2.1": true, "192.0.2.2": false, "2001:db8::1": true, "2001:db8::2": false} {
+		if Contains(net.ParseIP(ip), networks) != want {
+			t.Errorf("Contains(%s) is %v, want %v", ip, !want, want)
+		}
+	}
+	for _, text := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := ParseNetworks(text); err == nil {
+			t.Errorf("%q was parsed", text)
+		}
+	}
+}
@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"log"
-
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
@@ -35,6 +34,18 @@ func CONFIG_HOST(s *structs.Server, client *structs.Client, rawpacket []byte, li
 		return
 	}
 
+	// Don't open new lobbies while the server is draining
+	if s.Draining.Load() {
+		message.Code(
+			client,
+			"DRAINING",
+			nil,
+			listener,
+			nil,
+		)
+		return
+	}
+
 	// Prepare to transition to host mode
 	if client.InitialTransitionOverride || client.AmIPeer() {
 		session.PrepareToChangeModesOrDisconnect(s, client)
@@ -72,7 +83,7 @@ func CONFIG_HOST(s *structs.Server, client *structs.Client, rawpacket []byte, li
 	// Read settings
 	config := &structs.HostConfigPacket{}
 	if err := json.Unmarshal(rawpacket, config); err != nil {
-		log.Print("Parsing lobby settings error: ", err)
+		logging.Packet(client, "CONFIG_HOST").Warn("Parsing lobby settings error", "error", err)
 		message.Code(
 			client,
 			"VIOLATION",
@@ -86,7 +97,21 @@ func CONFIG_HOST(s *structs.Server, client *structs.Client, rawpacket []byte, li
 
 	// Validate settings
 	if err := s.PacketValidator.Struct(config); err != nil {
-		log.Print("Validating lobby settings error: ", err)
+		logging.Packet(client, "CONFIG_HOST").Warn("Validating lobby settings error", "error", err)
+		message.Code(
+			client,
+			"VIOLATION",
+			err.Error(),
+			listener,
+			nil,
+		)
+		session.Close(s, client)
+		return
+	}
+
+	// Relay limits that no message can meet are rejected
+	if err := peer.CheckLimits(config.Payload.RelayLimits); err != nil {
+		logging.Packet(client, "CONFIG_HOST").Warn("Validating lobby settings error", "error", err)
 		message.Code(
 			client,
 			"VIOLATION",
@@ -106,7 +131,7 @@ func OpenLobby(s *structs.Server, client *structs.Client, config *structs.HostCo
 	// Create the lobby and add the client to it
 	if manager.DoesLobbyExist(s, config.Payload.LobbyID, client.UGI) {
 
-		log.Printf("Lobby %s in game %s already exists", config.Payload.LobbyID, client.UGI)
+		logging.Packet(client, "CONFIG_HOST").Debug("Lobby already exists", "requested", config.Payload.LobbyID)
 		message.Code(
 			client,
 			"LOBBY_EXISTS",
@@ -117,6 +142,11 @@ func OpenLobby(s *structs.Server, client *structs.Client, config *structs.HostCo
 		return
 	}
 
+	// Relay-only lobbies always use the server relay
+	if config.Payload.Topology == structs.RELAY_TOPOLOGY {
+		config.Payload.UseServerRelay = true
+	}
+
 	// Remove the client from the default lobby
 	manager.RemoveClientFromLobby(s, "default", client.UGI, client)
 
@@ -175,32 +205,38 @@ func OpenLobby(s *structs.Server, client *structs.Client, config *structs.HostCo
 			nil,
 		)*/
 
-		// Spawn a new message relay
-		relay := peer.Spawn(
+		// Start recording before the host's relay is spawned, so that it is part of the recording
+		if config.Payload.Record {
+			if err := peer.StartRecording(s, client.UGI, config.Payload.LobbyID); err != nil {
+				logging.Packet(client, "CONFIG_HOST").Error("Start recording error", "error", err)
+				message.Code(
+					client,
+					"WARNING",
+					"lobby could not be recorded: "+err.Error(),
+					listener,
+					nil,
+				)
+			}
+		}
+
+		// Spawn a new message relay and tell the client to discover it.
+		// The client is sent a RELAY_ERROR if the relay can't be started.
+		peer.Start(
 			s,
 			client.UGI,
 			config.Payload.LobbyID,
 			client,
 		)
 
-		// Store the relay
-		manager.SetRelay(
-			s,
-			client,
-			relay,
-		)
-
-		// Tell the client to discover a new relay connection
-		message.Code(
-			client,
-			"DISCOVER",
-			&structs.NewPeerParams{
-				ID:   "relay",
-				User: "relay",
-			},
-			"",
-			nil,
-		)
+		// Start the lockstep tick loop if the lobby uses tick mode
+		if config.Payload.Tick != nil {
+			peer.StartTicks(
+				s,
+				client.UGI,
+				config.Payload.LobbyID,
+				config.Payload.Tick,
+			)
+		}
 
 		/*// Generate an offer and send it
 		message.Code(
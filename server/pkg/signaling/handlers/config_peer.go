@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"log"
-
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
@@ -71,7 +70,7 @@ func CONFIG_PEER(s *structs.Server, client *structs.Client, rawpacket []byte, li
 	// Read parameters
 	params := &structs.PeerConfigPacket{}
 	if err := json.Unmarshal(rawpacket, params); err != nil {
-		log.Print("Parsing lobby parameters error: ", err)
+		logging.Packet(client, "CONFIG_PEER").Warn("Parsing lobby parameters error", "error", err)
 		message.Code(
 			client,
 			"VIOLATION",
@@ -85,7 +84,7 @@ func CONFIG_PEER(s *structs.Server, client *structs.Client, rawpacket []byte, li
 
 	// Validate parameters
 	if err := s.PacketValidator.Struct(params); err != nil {
-		log.Print("Validating lobby parameters error: ", err)
+		logging.Packet(client, "CONFIG_PEER").Warn("Validating lobby parameters error", "error", err)
 		message.Code(
 			client,
 			"VIOLATION",
@@ -105,7 +104,7 @@ func JoinLobby(s *structs.Server, client *structs.Client, params *structs.PeerCo
 	// Check if the requested lobby exists
 	if !manager.DoesLobbyExist(s, params.Payload.LobbyID, client.UGI) {
 
-		log.Printf("Lobby %s in game %s doesn't exist", params.Payload.LobbyID, client.UGI)
+		logging.Packet(client, "CONFIG_PEER").Debug("Lobby doesn't exist", "requested", params.Payload.LobbyID)
 		message.Code(
 			client,
 			"LOBBY_NOTFOUND",
@@ -121,7 +120,7 @@ func JoinLobby(s *structs.Server, client *structs.Client, params *structs.PeerCo
 
 	// Check if the lobby is currently awaiting peer-based reclaim
 	if settings.ReclaimInProgress {
-		log.Printf("Lobby %s is currently hostless and awaiting peer-based reclaim", params.Payload.LobbyID)
+		logging.Packet(client, "CONFIG_PEER").Debug("Lobby is hostless and awaiting peer-based reclaim", "requested", params.Payload.LobbyID)
 		message.Code(
 			client,
 			"LOBBY_RECLAIM",
@@ -207,28 +206,35 @@ func JoinLobby(s *structs.Server, client *structs.Client, params *structs.PeerCo
 	// Get the current lobby host
 	host, err := manager.GetLobbyHost(s, params.Payload.LobbyID, client.UGI)
 	if err != nil {
-		log.Printf("Get lobby host error: %s", err.Error())
+		logging.Packet(client, "CONFIG_PEER").Error("Get lobby host error", "error", err)
 		return
 	}
 
-	// Tell the host that a new peer has joined
-	message.Code(
-		host,
-		"NEW_PEER",
-		&structs.NewPeerParams{
-			ID:        client.ID,
-			User:      client.Username,
-			PublicKey: client.PublicKey,
-		},
-		"",
-		nil,
-	)
+	// Tell the host that a new peer has joined, unless the lobby only uses the server relay
+	everyone := manager.GetLobbyPeers(s, params.Payload.LobbyID, client.UGI)
+	if manager.ShouldConnect(settings, host, everyone, client, host) {
+		message.Code(
+			host,
+			"NEW_PEER",
+			&structs.NewPeerParams{
+				ID:        client.ID,
+				User:      client.Username,
+				PublicKey: client.PublicKey,
+			},
+			"",
+			nil,
+		)
+	}
 
 	// Notify other peers in the lobby about the new member using the ANTICIPATE opcode.
 	// This is a broadcast that prepares other peers to establish a connection with the new peer.
-	only_peers := manager.GetLobbyPeers(s, params.Payload.LobbyID, client.UGI)
-	only_peers = manager.WithoutPeer(only_peers, client)
-	only_peers = manager.WithoutPeer(only_peers, host)
+	// Only the peers that the lobby's topology connects to the new peer are notified.
+	only_peers := []*structs.Client{}
+	for _, peer := range manager.WithoutPeer(manager.WithoutPeer(everyone, client), host) {
+		if manager.ShouldConnect(settings, host, everyone, client, peer) {
+			only_peers = append(only_peers, peer)
+		}
+	}
 	message.Broadcast(
 		only_peers,
 		&structs.SignalPacket{
@@ -250,25 +256,24 @@ func JoinLobby(s *structs.Server, client *structs.Client, params *structs.PeerCo
 		nil,
 	)
 
-	// Tell the peer to expect a connection from the host
-	message.Code(
-		client,
-		"ANTICIPATE",
-		&structs.NewPeerParams{
-			ID:        host.ID,
-			User:      host.Username,
-			PublicKey: host.PublicKey,
-		},
-		"",
-		nil,
-	)
+	// Tell the peer to expect a connection from the host, unless the lobby only uses the server relay
+	if manager.ShouldConnect(settings, host, everyone, client, host) {
+		message.Code(
+			client,
+			"ANTICIPATE",
+			&structs.NewPeerParams{
+				ID:        host.ID,
+				User:      host.Username,
+				PublicKey: host.PublicKey,
+			},
+			"",
+			nil,
+		)
+	}
 
 	// Notify the new peer about other peers in the lobby using the DISCOVER opcode.
 	// This tells the new peer to make connections with existing peers.
-	existing := manager.GetLobbyPeers(s, params.Payload.LobbyID, client.UGI)
-	existing = manager.WithoutPeer(existing, client)
-	existing = manager.WithoutPeer(existing, host)
-	for _, peer := range existing {
+	for _, peer := range only_peers {
 		message.Send(
 			client,
 			&structs.SignalPacket{
@@ -297,33 +302,15 @@ func JoinLobby(s *structs.Server, client *structs.Client, params *structs.PeerCo
 			nil,
 		)*/
 
-		// Spawn a new message relay
-		relay := peer.Spawn(
+		// Spawn a new message relay and tell the client to discover it.
+		// The client is sent a RELAY_ERROR if the relay can't be started.
+		peer.Start(
 			s,
 			client.UGI,
 			settings.LobbyID,
 			client,
 		)
 
-		// Store the relay
-		manager.SetRelay(
-			s,
-			client,
-			relay,
-		)
-
-		// Tell the client to discover a new relay connection
-		message.Code(
-			client,
-			"DISCOVER",
-			&structs.NewPeerParams{
-				ID:   "relay",
-				User: "relay",
-			},
-			"",
-			nil,
-		)
-
 		/*// Generate an offer and send it
 		message.Code(
 			client,
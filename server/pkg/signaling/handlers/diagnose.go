@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// DIAGNOSE handles the DIAGNOSE opcode, which is used by a client to test its connectivity to
+// the server relay. It doesn't require the client to be in a lobby.
+//
+// The server sends DIAGNOSE_START with the ICE servers the client should use, followed by a
+// MAKE_OFFER of type DIAGNOSTIC_CANDIDATE from "diagnostics". The client answers it, and trickles
+// its candidates, using "diagnostics" as the recipient. Once the test is over, the client is sent
+// a DIAGNOSE_REPORT with a structs.DiagnosticReport payload.
+func DIAGNOSE(s *structs.Server, client *structs.Client, packet *structs.SignalPacket) {
+
+	// Don't start this handler if the client isn't authorized
+	if !client.AmIAuthorized() {
+		message.Code(
+			client,
+			"CONFIG_REQUIRED",
+			nil,
+			packet.Listener,
+			nil,
+		)
+		return
+	}
+
+	if err := peer.Diagnose(s, client, packet.Listener); err != nil {
+		logging.Packet(client, "DIAGNOSE").Warn("Start diagnostics error", "error", err)
+		err := message.Code(
+			client,
+			"WARNING",
+			err.Error(),
+			packet.Listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "DIAGNOSE").Error("Send response error", "response", "WARNING", "error", err)
+		}
+	}
+}
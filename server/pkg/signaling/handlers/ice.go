@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
 
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
@@ -16,6 +16,25 @@ import (
 // PEER_INVALID packet.
 func ICE(s *structs.Server, client *structs.Client, packet *structs.SignalPacket, rawpacket []byte) {
 
+	// Diagnostic test connections don't need a lobby
+	if packet.Recipient == "diagnostics" {
+		reparsed := &structs.RelayInboundIcePacket{}
+		if err := json.Unmarshal(rawpacket, &reparsed); err != nil || reparsed.Payload == nil {
+			logging.Packet(client, "ICE").Warn("Unmarshal diagnostic packet error", "error", err)
+			return
+		}
+		if err := peer.HandleDiagnosticIce(s, client, reparsed.Payload.Contents); err != nil {
+			message.Code(
+				client,
+				"WARNING",
+				err.Error(),
+				packet.Listener,
+				nil,
+			)
+		}
+		return
+	}
+
 	// Require the peer to be in a lobby
 	if !client.AmIInALobby() {
 		err := message.Code(
@@ -26,7 +45,7 @@ func ICE(s *structs.Server, client *structs.Client, packet *structs.SignalPacket
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send CONFIG_REQUIRED response to ICE opcode error: %s", err.Error())
+			logging.Packet(client, "ICE").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
 		}
 		return
 	}
@@ -41,25 +60,44 @@ func ICE(s *structs.Server, client *structs.Client, packet *structs.SignalPacket
 		// Read the raw packet as a relay packet
 		reparsed := &structs.RelayInboundIcePacket{}
 		if err := json.Unmarshal(rawpacket, &reparsed); err != nil {
-			log.Printf("Unmarshal relay ICE packet error: %s", err.Error())
+			logging.Packet(client, "ICE").Warn("Unmarshal relay packet error", "error", err)
 			return
 		}
 
 		relay := manager.GetRelay(s, client)
-
-		// The candidate type cannot be a voice candidate since we're a server, not a person.
-		if reparsed.Payload.Type == structs.VOICE_CANDIDATE {
-			log.Print("Handling ICE for relay peer can't be done: Got a voice candidate!")
+		if relay == nil {
+			logging.Packet(client, "ICE").Warn("Relay doesn't exist")
 			message.Code(
 				client,
-				"WARNING",
-				"voice connections are not supported by the server relay",
+				"RELAY_ERROR",
+				&structs.RelayError{
+					Reason:  "unavailable",
+					Message: "You don't have a relay in this lobby",
+				},
 				packet.Listener,
-				nil,
+				&structs.PeerInfo{
+					ID:   "relay",
+					User: "relay",
+				},
 			)
 			return
 		}
 
+		// Voice candidates belong to the relay's voice connection.
+		if reparsed.Payload.Type == structs.VOICE_CANDIDATE {
+			if err := peer.HandleVoiceIce(relay, reparsed.Payload.Contents); err != nil {
+				logging.Packet(client, "ICE").Warn("Relay voice connection error", "error", err)
+				message.Code(
+					client,
+					"WARNING",
+					err.Error(),
+					packet.Listener,
+					nil,
+				)
+			}
+			return
+		}
+
 		peer.HandleIce(relay, reparsed.Payload.Contents)
 		return
 	}
@@ -67,7 +105,7 @@ func ICE(s *structs.Server, client *structs.Client, packet *structs.SignalPacket
 	// Check if the desired peer exists. If it does, get the peer's connection
 	peer := manager.GetByULID(s, packet.Recipient)
 	if peer == nil {
-		log.Printf("Failed to get ICE peer as it doesn't exist: %s", packet.Recipient)
+		logging.Packet(client, "ICE").Warn("Recipient doesn't exist", "recipient", packet.Recipient)
 		return
 	}
 
@@ -81,7 +119,7 @@ func ICE(s *structs.Server, client *structs.Client, packet *structs.SignalPacket
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send PEER_INVALID response to ICE opcode error: %s", err.Error())
+			logging.Packet(client, "ICE").Error("Send response error", "response", "PEER_INVALID", "error", err)
 		}
 		return
 	}
@@ -98,7 +136,7 @@ func ICE(s *structs.Server, client *structs.Client, packet *structs.SignalPacket
 		},
 	)
 	if err != nil {
-		log.Printf("Relay ICE opcode error: %s", err.Error())
+		logging.Packet(client, "ICE").Error("Relay opcode error", "error", err)
 	}
 
 	// Tell the original client that the ICE candidate was relayed
@@ -110,6 +148,6 @@ func ICE(s *structs.Server, client *structs.Client, packet *structs.SignalPacket
 		nil,
 	)
 	if err != nil {
-		log.Printf("Send RELAY_OK response to ICE opcode error: %s", err.Error())
+		logging.Packet(client, "ICE").Error("Send response error", "response", "RELAY_OK", "error", err)
 	}
 }
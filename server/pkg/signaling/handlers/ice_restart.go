@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// IceRestartTimeout is how long two lobby members have to recover their connection after an ICE
+// restart before they are told that the other one is gone.
+const IceRestartTimeout = 30 * time.Second
+
+// ICE_RESTART handles the ICE_RESTART opcode, which is used by a client whose network changed
+// to recover its connection to another lobby member, or to the server relay, without rejoining
+// the lobby.
+//
+// If the recipient is a peer, the peer is sent an ICE_RESTART packet so it expects a new offer,
+// and the client is sent ACK_ICE_RESTART, after which it should send a MAKE_OFFER with new ICE
+// credentials as usual. If the recipient is "relay", the relay restarts ICE itself using pion's
+// ICE restart and sends the client a new offer.
+//
+// Once the connection recovers, either member sends ICE_RESTART_DONE. If it doesn't recover
+// within IceRestartTimeout, both members are sent PEER_GONE.
+func ICE_RESTART(s *structs.Server, client *structs.Client, packet *structs.SignalPacket) {
+
+	// Require the peer to be in a lobby
+	if !client.AmIInALobby() {
+		err := message.Code(
+			client,
+			"CONFIG_REQUIRED",
+			nil,
+			packet.Listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "ICE_RESTART").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
+		}
+		return
+	}
+
+	// Restart the connection with the relay
+	if packet.Recipient == "relay" {
+		relay := manager.GetRelay(s, client)
+		if relay == nil {
+			message.Code(
+				client,
+				"RELAY_ERROR",
+				&structs.RelayError{
+					Reason:  "unavailable",
+					Message: "You don't have a relay in this lobby",
+				},
+				packet.Listener,
+				&structs.PeerInfo{
+					ID:   "relay",
+					User: "relay",
+				},
+			)
+			return
+		}
+
+		if !begin_restart(s, client, nil, packet.Listener) {
+			return
+		}
+
+		if err := peer.RestartIce(relay); err != nil {
+			manager.FinishIceRestart(s, client.Lobby, client.UGI, client.ID, "relay")
+			message.Code(
+				client,
+				"RELAY_ERROR",
+				&structs.RelayError{
+					Reason:  "negotiation",
+					Message: err.Error(),
+				},
+				packet.Listener,
+				&structs.PeerInfo{
+					ID:   "relay",
+					User: "relay",
+				},
+			)
+			return
+		}
+
+		// Tell the client that the restart has begun
+		err := message.Code(
+			client,
+			"ACK_ICE_RESTART",
+			&structs.PeerInfo{
+				ID:   "relay",
+				User: "relay",
+			},
+			packet.Listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "ICE_RESTART").Error("Send response error", "response", "ACK_ICE_RESTART", "error", err)
+		}
+		return
+	}
+
+	// Check if the desired peer exists and is in the same lobby
+	target := manager.GetByULID(s, packet.Recipient)
+	if target == nil || target == client || !manager.IsClientInLobby(s, client.Lobby, client.UGI, target) {
+		err := message.Code(
+			client,
+			"PEER_INVALID",
+			nil,
+			packet.Listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "ICE_RESTART").Error("Send response error", "response", "PEER_INVALID", "error", err)
+		}
+		return
+	}
+
+	if !begin_restart(s, client, target, packet.Listener) {
+		return
+	}
+
+	// Tell the peer to expect a new offer
+	err := message.Code(
+		target,
+		"ICE_RESTART",
+		nil,
+		"",
+		&structs.PeerInfo{
+			ID:   client.ID,
+			User: client.Username,
+		},
+	)
+	if err != nil {
+		logging.Packet(client, "ICE_RESTART").Error("Relay opcode error", "error", err)
+	}
+
+	// Tell the client to send the new offer
+	err = message.Code(
+		client,
+		"ACK_ICE_RESTART",
+		&structs.PeerInfo{
+			ID:   target.ID,
+			User: target.Username,
+		},
+		packet.Listener,
+		nil,
+	)
+	if err != nil {
+		logging.Packet(client, "ICE_RESTART").Error("Send response error", "response", "ACK_ICE_RESTART", "error", err)
+	}
+}
+
+// ICE_RESTART_DONE handles the ICE_RESTART_DONE opcode, which is used by a lobby member to report
+// that its connection to the recipient recovered after an ICE restart. The other member is sent
+// ICE_RESTART_DONE, and the client is sent RELAY_OK.
+func ICE_RESTART_DONE(s *structs.Server, client *structs.Client, packet *structs.SignalPacket) {
+
+	// Require the peer to be in a lobby
+	if !client.AmIInALobby() {
+		err := message.Code(
+			client,
+			"CONFIG_REQUIRED",
+			nil,
+			packet.Listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "ICE_RESTART_DONE").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
+		}
+		return
+	}
+
+	restart := manager.FinishIceRestart(s, client.Lobby, client.UGI, client.ID, packet.Recipient)
+	if restart == nil {
+		err := message.Code(
+			client,
+			"WARNING",
+			"No ICE restart in progress with this peer",
+			packet.Listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "ICE_RESTART_DONE").Error("Send response error", "response", "WARNING", "error", err)
+		}
+		return
+	}
+	logging.Packet(client, "ICE_RESTART_DONE").Info("ICE restart finished", "recipient", packet.Recipient, "duration", time.Since(restart.Started))
+
+	// Tell the other member that the connection recovered
+	if restart.Target != nil {
+		other := restart.Target
+		if other == client {
+			other = restart.Initiator
+		}
+		err := message.Code(
+			other,
+			"ICE_RESTART_DONE",
+			nil,
+			"",
+			&structs.PeerInfo{
+				ID:   client.ID,
+				User: client.Username,
+			},
+		)
+		if err != nil {
+			logging.Packet(client, "ICE_RESTART_DONE").Error("Relay opcode error", "error", err)
+		}
+	}
+
+	err := message.Code(
+		client,
+		"RELAY_OK",
+		nil,
+		packet.Listener,
+		nil,
+	)
+	if err != nil {
+		logging.Packet(client, "ICE_RESTART_DONE").Error("Send response error", "response", "RELAY_OK", "error", err)
+	}
+}
+
+// begin_restart records an ICE restart between the client and the target, or the client's relay
+// if the target is nil, and starts its timeout. If a restart between them is already in progress,
+// the client is sent ALREADY_RESTARTING and false is returned.
+func begin_restart(s *structs.Server, client *structs.Client, target *structs.Client, listener string) bool {
+	id := "relay"
+	if target != nil {
+		id = target.ID
+	}
+
+	restart := &structs.IceRestart{
+		Initiator: client,
+		Target:    target,
+		Started:   time.Now(),
+	}
+	ugi, lobby := client.UGI, client.Lobby
+	restart.Timer = time.AfterFunc(IceRestartTimeout, func() {
+		expire_restart(s, ugi, lobby, client.ID, id)
+	})
+
+	if !manager.StartIceRestart(s, client.Lobby, client.UGI, client.ID, id, restart) {
+		restart.Timer.Stop()
+		err := message.Code(
+			client,
+			"ALREADY_RESTARTING",
+			nil,
+			listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "ICE_RESTART").Error("Send response error", "response", "ALREADY_RESTARTING", "error", err)
+		}
+		return false
+	}
+
+	logging.Packet(client, "ICE_RESTART").Info("ICE restart started", "recipient", id)
+	return true
+}
+
+// expire_restart gives up on an ICE restart that didn't finish in time. Both members are told
+// that the other one is gone using the PEER_GONE opcode. If the restart was with the relay, the
+// relay is shut down, unless the client is still reaching it over the signaling websocket.
+func expire_restart(s *structs.Server, ugi string, lobby string, a string, b string) {
+	restart := manager.FinishIceRestart(s, lobby, ugi, a, b)
+	if restart == nil {
+		return
+	}
+	logging.Signaling().Info("ICE restart timed out", "ugi", ugi, "lobby", lobby, "initiator", a, "recipient", b)
+
+	// The relay is gone unless the client can still reach it over the websocket
+	if restart.Target == nil {
+		relay := manager.GetRelay(s, restart.Initiator)
+		if relay != nil && peer.IsFallback(relay) {
+			message.Code(
+				restart.Initiator,
+				"RELAY_FALLBACK",
+				nil,
+				lobby,
+				&structs.PeerInfo{
+					ID:   "relay",
+					User: "relay",
+				},
+			)
+			return
+		}
+		message.Code(
+			restart.Initiator,
+			"PEER_GONE",
+			&structs.PeerInfo{
+				ID:   "relay",
+				User: "relay",
+			},
+			"",
+			nil,
+		)
+		manager.DeleteRelay(s, restart.Initiator)
+		return
+	}
+
+	// Tell both members that the other one is gone
+	message.Code(
+		restart.Initiator,
+		"PEER_GONE",
+		&structs.PeerInfo{
+			ID:   restart.Target.ID,
+			User: restart.Target.Username,
+		},
+		"",
+		nil,
+	)
+	message.Code(
+		restart.Target,
+		"PEER_GONE",
+		&structs.PeerInfo{
+			ID:   restart.Initiator.ID,
+			User: restart.Initiator.Username,
+		},
+		"",
+		nil,
+	)
+}
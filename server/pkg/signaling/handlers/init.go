@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"log"
-
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
@@ -25,7 +24,7 @@ func INIT(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send SESSION_EXISTS response to INIT opcode error: %s", err.Error())
+			logging.Packet(client, "INIT").Error("Send response error", "response", "SESSION_EXISTS", "error", err)
 		}
 		return
 	}
@@ -47,7 +46,7 @@ func INIT(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 		nil,
 	)
 	if err != nil {
-		log.Printf("Send response to INIT opcode error: %s", err.Error())
+		logging.Packet(client, "INIT").Error("Send response error", "error", err)
 	}
 
 	// Phi-specific code: Check if the default room exists. If it doesn't, create it and make the client the host. Otherwise, join it.
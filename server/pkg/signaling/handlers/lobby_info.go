@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"fmt"
-	"log"
 
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
@@ -21,7 +21,7 @@ func LOBBY_INFO(s *structs.Server, client *structs.Client, packet *structs.Signa
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send CONFIG_REQUIRED response to LOBBY_INFO opcode error: %s", err.Error())
+			logging.Packet(client, "LOBBY_INFO").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
 		}
 		return
 	}
@@ -44,12 +44,12 @@ func LOBBY_INFO(s *structs.Server, client *structs.Client, packet *structs.Signa
 	}
 
 	// Read lobby settings/state
-	log.Printf("Getting lobby %s settings...", lobby)
+	logging.Packet(client, "LOBBY_INFO").Debug("Getting lobby settings", "requested", lobby)
 	settings := manager.GetLobbySettings(s, lobby, client.UGI)
 
 	// Check if the lobby is currently awaiting peer-based reclaim
 	if settings.ReclaimInProgress {
-		log.Printf("Lobby %s is currently hostless and awaiting peer-based reclaim", lobby)
+		logging.Packet(client, "LOBBY_INFO").Debug("Lobby is hostless and awaiting peer-based reclaim", "requested", lobby)
 		message.Code(
 			client,
 			"LOBBY_RECLAIM",
@@ -61,10 +61,10 @@ func LOBBY_INFO(s *structs.Server, client *structs.Client, packet *structs.Signa
 	}
 
 	// Retrieve the current lobby host
-	log.Printf("Getting lobby %s host...", lobby)
+	logging.Packet(client, "LOBBY_INFO").Debug("Getting lobby host", "requested", lobby)
 	host, err := manager.GetLobbyHost(s, lobby, client.UGI)
 	if err != nil {
-		log.Printf("Get lobby host error: %s", err.Error())
+		logging.Packet(client, "LOBBY_INFO").Error("Get lobby host error", "requested", lobby, "error", err)
 		return
 	}
 	if host == nil {
@@ -72,7 +72,7 @@ func LOBBY_INFO(s *structs.Server, client *structs.Client, packet *structs.Signa
 	}
 
 	// Get a count of all members in the lobby - subtract 1 for the host
-	log.Printf("Getting lobby %s members...", lobby)
+	logging.Packet(client, "LOBBY_INFO").Debug("Getting lobby members", "requested", lobby)
 	members := len(manager.GetLobbyPeers(s, lobby, client.UGI)) - 1
 
 	// Send the reply
@@ -86,6 +86,7 @@ func LOBBY_INFO(s *structs.Server, client *structs.Client, packet *structs.Signa
 			CurrentPeers:      members,
 			PasswordRequired:  settings.Password != "",
 			Reclaimable:       settings.AllowHostReclaim,
+			Topology:          manager.LobbyTopology(settings),
 		},
 		packet.Listener,
 		nil,
@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"log"
-
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
@@ -20,7 +19,7 @@ func LOBBY_LIST(s *structs.Server, client *structs.Client, packet *structs.Signa
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send CONFIG_REQUIRED response to LOBBY_LIST opcode error: %s", err.Error())
+			logging.Packet(client, "LOBBY_LIST").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
 		}
 		return
 	}
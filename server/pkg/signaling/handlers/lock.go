@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"log"
-
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
@@ -25,7 +24,7 @@ func LOCK(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send CONFIG_REQUIRED response to LOCK opcode error: %s", err.Error())
+			logging.Packet(client, "LOCK").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
 		}
 		return
 	}
@@ -40,7 +39,7 @@ func LOCK(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send CONFIG_REQUIRED response to LOCK opcode error: %s", err.Error())
+			logging.Packet(client, "LOCK").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
 		}
 		return
 	}
@@ -58,7 +57,7 @@ func LOCK(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send ALREADY_LOCKED response to LOCK opcode error: %s", err.Error())
+			logging.Packet(client, "LOCK").Error("Send response error", "response", "ALREADY_LOCKED", "error", err)
 		}
 		return
 	}
@@ -76,6 +75,6 @@ func LOCK(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 		nil,
 	)
 	if err != nil {
-		log.Printf("Send ACK_LOCK response to LOCK opcode error: %s", err.Error())
+		logging.Packet(client, "LOCK").Error("Send response error", "response", "ACK_LOCK", "error", err)
 	}
 }
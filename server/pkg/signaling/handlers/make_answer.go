@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"log"
-
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
@@ -16,6 +15,25 @@ import (
 // PEER_INVALID packet.
 func MAKE_ANSWER(s *structs.Server, client *structs.Client, packet *structs.SignalPacket, rawpacket []byte) {
 
+	// Diagnostic test connections don't need a lobby
+	if packet.Recipient == "diagnostics" {
+		reparsed := &structs.RelayCandidatePacket{}
+		if err := json.Unmarshal(rawpacket, &reparsed); err != nil || reparsed.Payload == nil {
+			logging.Packet(client, "MAKE_ANSWER").Warn("Unmarshal diagnostic packet error", "error", err)
+			return
+		}
+		if err := peer.HandleDiagnosticAnswer(s, client, reparsed.Payload.Contents); err != nil {
+			message.Code(
+				client,
+				"WARNING",
+				err.Error(),
+				packet.Listener,
+				nil,
+			)
+		}
+		return
+	}
+
 	// Require the peer to be in a lobby
 	if !client.AmIInALobby() {
 		err := message.Code(
@@ -26,7 +44,7 @@ func MAKE_ANSWER(s *structs.Server, client *structs.Client, packet *structs.Sign
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send CONFIG_REQUIRED response to MAKE_ANSWER opcode error: %s", err.Error())
+			logging.Packet(client, "MAKE_ANSWER").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
 		}
 		return
 	}
@@ -41,33 +59,67 @@ func MAKE_ANSWER(s *structs.Server, client *structs.Client, packet *structs.Sign
 		// Read the raw packet as a relay packet
 		reparsed := &structs.RelayCandidatePacket{}
 		if err := json.Unmarshal(rawpacket, &reparsed); err != nil {
-			log.Printf("Unmarshal relay MAKE_ANSWER packet error: %s", err.Error())
+			logging.Packet(client, "MAKE_ANSWER").Warn("Unmarshal relay packet error", "error", err)
 			return
 		}
 
 		relay := manager.GetRelay(s, client)
-
-		// The candidate type cannot be a voice candidate since we're a server, not a person.
-		if reparsed.Payload.Type == structs.VOICE_CANDIDATE {
-			log.Print("Handling MAKE_ANSWER for relay peer can't be done: Got a voice candidate!")
+		if relay == nil {
+			logging.Packet(client, "MAKE_ANSWER").Warn("Relay doesn't exist")
 			message.Code(
 				client,
-				"WARNING",
-				"voice connections are not supported by the server relay",
+				"RELAY_ERROR",
+				&structs.RelayError{
+					Reason:  "unavailable",
+					Message: "You don't have a relay in this lobby",
+				},
 				packet.Listener,
-				nil,
+				&structs.PeerInfo{
+					ID:   "relay",
+					User: "relay",
+				},
 			)
 			return
 		}
 
-		peer.HandleAnswer(relay, reparsed.Payload.Contents)
+		// Voice answers belong to the relay's voice connection.
+		if reparsed.Payload.Type == structs.VOICE_CANDIDATE {
+			if err := peer.HandleVoiceAnswer(relay, reparsed.Payload.Contents); err != nil {
+				logging.Packet(client, "MAKE_ANSWER").Warn("Relay voice connection error", "error", err)
+				message.Code(
+					client,
+					"WARNING",
+					err.Error(),
+					packet.Listener,
+					nil,
+				)
+			}
+			return
+		}
+
+		if err := peer.HandleAnswer(relay, reparsed.Payload.Contents); err != nil {
+			logging.Packet(client, "MAKE_ANSWER").Warn("Relay error", "error", err)
+			message.Code(
+				client,
+				"RELAY_ERROR",
+				&structs.RelayError{
+					Reason:  "negotiation",
+					Message: err.Error(),
+				},
+				packet.Listener,
+				&structs.PeerInfo{
+					ID:   "relay",
+					User: "relay",
+				},
+			)
+		}
 		return
 	}
 
 	// Check if the desired peer exists. If it does, get the peer's connection
 	peer := manager.GetByULID(s, packet.Recipient)
 	if peer == nil {
-		log.Printf("Failed to get MAKE_ANSWER peer as it doesn't exist: %s", packet.Recipient)
+		logging.Packet(client, "MAKE_ANSWER").Warn("Recipient doesn't exist", "recipient", packet.Recipient)
 		return
 	}
 
@@ -81,7 +133,7 @@ func MAKE_ANSWER(s *structs.Server, client *structs.Client, packet *structs.Sign
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send PEER_INVALID response to MAKE_ANSWER opcode error: %s", err.Error())
+			logging.Packet(client, "MAKE_ANSWER").Error("Send response error", "response", "PEER_INVALID", "error", err)
 		}
 		return
 	}
@@ -98,7 +150,7 @@ func MAKE_ANSWER(s *structs.Server, client *structs.Client, packet *structs.Sign
 		},
 	)
 	if err != nil {
-		log.Printf("Relay MAKE_ANSWER opcode error: %s", err.Error())
+		logging.Packet(client, "MAKE_ANSWER").Error("Relay opcode error", "error", err)
 	}
 
 	// Tell the original client that the answer was relayed
@@ -110,6 +162,6 @@ func MAKE_ANSWER(s *structs.Server, client *structs.Client, packet *structs.Sign
 		nil,
 	)
 	if err != nil {
-		log.Printf("Send RELAY_OK response to MAKE_ANSWER opcode error: %s", err.Error())
+		logging.Packet(client, "MAKE_ANSWER").Error("Send response error", "response", "RELAY_OK", "error", err)
 	}
 }
@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"log"
-
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
@@ -26,7 +25,7 @@ func MAKE_OFFER(s *structs.Server, client *structs.Client, packet *structs.Signa
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send CONFIG_REQUIRED response to MAKE_OFFER opcode error: %s", err.Error())
+			logging.Packet(client, "MAKE_OFFER").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
 		}
 		return
 	}
@@ -41,26 +40,79 @@ func MAKE_OFFER(s *structs.Server, client *structs.Client, packet *structs.Signa
 		// Read the raw packet as a relay packet
 		reparsed := &structs.RelayCandidatePacket{}
 		if err := json.Unmarshal(rawpacket, &reparsed); err != nil {
-			log.Printf("Unmarshal relay MAKE_OFFER packet error: %s", err.Error())
+			logging.Packet(client, "MAKE_OFFER").Warn("Unmarshal relay packet error", "error", err)
 			return
 		}
 
 		relay := manager.GetRelay(s, client)
+		if relay == nil {
+			logging.Packet(client, "MAKE_OFFER").Warn("Relay doesn't exist")
+			message.Code(
+				client,
+				"RELAY_ERROR",
+				&structs.RelayError{
+					Reason:  "unavailable",
+					Message: "You don't have a relay in this lobby",
+				},
+				packet.Listener,
+				&structs.PeerInfo{
+					ID:   "relay",
+					User: "relay",
+				},
+			)
+			return
+		}
 
-		// The candidate type cannot be a voice candidate since we're a server, not a person.
+		// Voice offers are answered by the relay's voice connection, which forwards audio to the rest of the lobby.
 		if reparsed.Payload.Type == structs.VOICE_CANDIDATE {
-			log.Print("Handling MAKE_OFFER for relay peer can't be done: Got a voice candidate!")
+			answer, err := peer.MakeVoiceAnswerFromOffer(relay, reparsed.Payload.Contents)
+			if err != nil {
+				logging.Packet(client, "MAKE_OFFER").Warn("Relay voice connection error", "error", err)
+				message.Code(
+					client,
+					"WARNING",
+					err.Error(),
+					packet.Listener,
+					nil,
+				)
+				return
+			}
+
+			// Send the answer to the peer
 			message.Code(
 				client,
-				"WARNING",
-				"voice connections are not supported by the server relay",
-				packet.Listener,
-				nil,
+				"MAKE_ANSWER",
+				&structs.RelayCandidate{
+					Type:     structs.VOICE_CANDIDATE,
+					Contents: answer,
+				},
+				"",
+				&structs.PeerInfo{
+					ID:   "relay",
+					User: "relay",
+				},
 			)
 			return
 		}
 
-		answer := peer.MakeAnswerFromOffer(relay, reparsed.Payload.Contents)
+		answer, err := peer.MakeAnswerFromOffer(relay, reparsed.Payload.Contents)
+		if err != nil {
+			logging.Packet(client, "MAKE_OFFER").Warn("Relay error", "error", err)
+			message.Code(
+				client,
+				"RELAY_ERROR",
+				&structs.RelayError{
+					Reason:  "negotiation",
+					Message: err.Error(),
+				},
+				packet.Listener,
+				&structs.PeerInfo{
+					ID:   "relay",
+					User: "relay",
+				},
+			)
+			return
+		}
 
 		// Send the answer to the peer
 		message.Code(
@@ -82,7 +134,7 @@ func MAKE_OFFER(s *structs.Server, client *structs.Client, packet *structs.Signa
 	// Check if the desired peer exists. If it does, get the peer's connection
 	peer := manager.GetByULID(s, packet.Recipient)
 	if peer == nil {
-		log.Printf("Failed to get MAKE_OFFER peer as it doesn't exist: %s", packet.Recipient)
+		logging.Packet(client, "MAKE_OFFER").Warn("Recipient doesn't exist", "recipient", packet.Recipient)
 		return
 	}
 
@@ -96,7 +148,7 @@ func MAKE_OFFER(s *structs.Server, client *structs.Client, packet *structs.Signa
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send PEER_INVALID response to MAKE_OFFER opcode error: %s", err.Error())
+			logging.Packet(client, "MAKE_OFFER").Error("Send response error", "response", "PEER_INVALID", "error", err)
 		}
 		return
 	}
@@ -113,7 +165,7 @@ func MAKE_OFFER(s *structs.Server, client *structs.Client, packet *structs.Signa
 		},
 	)
 	if err != nil {
-		log.Printf("Relay MAKE_OFFER opcode error: %s", err.Error())
+		logging.Packet(client, "MAKE_OFFER").Error("Relay opcode error", "error", err)
 	}
 
 	// Tell the original client that the offer was relayed
@@ -125,6 +177,6 @@ func MAKE_OFFER(s *structs.Server, client *structs.Client, packet *structs.Signa
 		nil,
 	)
 	if err != nil {
-		log.Printf("Send RELAY_OK response to MAKE_OFFER opcode error: %s", err.Error())
+		logging.Packet(client, "MAKE_OFFER").Error("Send response error", "response", "RELAY_OK", "error", err)
 	}
 }
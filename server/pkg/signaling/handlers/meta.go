@@ -1,10 +1,10 @@
 package handlers
 
 import (
-	"log"
 	"runtime"
 
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/constants"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
 )
@@ -30,6 +30,6 @@ func META(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 		nil,
 	)
 	if err != nil {
-		log.Printf("Send ACK_META response to META opcode error: %s", err.Error())
+		logging.Packet(client, "META").Error("Send response error", "response", "ACK_META", "error", err)
 	}
 }
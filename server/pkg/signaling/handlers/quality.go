@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/goccy/go-json"
+)
+
+// QUALITY_REPORT handles the QUALITY_REPORT opcode, which is used by lobby members to periodically
+// report the round trip time and packet loss of their connections to other members, and to the
+// server relay using "relay" as the peer ID. Each report replaces the client's previous one.
+//
+// The reports are aggregated into the lobby's connectivity matrix, which is used to pick the best
+// connected peer as the new host during automated host reclaim. If the lobby was created with
+// suggest_migration, the host is sent MIGRATE_HOST when another member is much better connected.
+func QUALITY_REPORT(s *structs.Server, client *structs.Client, rawpacket []byte, listener string) {
+
+	// Require the peer to be in a lobby
+	if !client.AmIInALobby() {
+		err := message.Code(
+			client,
+			"CONFIG_REQUIRED",
+			nil,
+			listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "QUALITY_REPORT").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
+		}
+		return
+	}
+
+	// Read the raw packet as a quality report
+	reparsed := &structs.QualityReportPacket{}
+	if err := json.Unmarshal(rawpacket, reparsed); err != nil {
+		logging.Packet(client, "QUALITY_REPORT").Warn("Unmarshal packet error", "error", err)
+		message.Code(
+			client,
+			"WARNING",
+			err.Error(),
+			listener,
+			nil,
+		)
+		return
+	}
+	if err := s.PacketValidator.Struct(reparsed); err != nil {
+		message.Code(
+			client,
+			"WARNING",
+			err.Error(),
+			listener,
+			nil,
+		)
+		return
+	}
+
+	manager.SetQualitySamples(s, client.Lobby, client.UGI, client, reparsed.Payload)
+
+	// Suggest a better connected host, if the lobby wants suggestions
+	settings := manager.GetLobbySettings(s, client.Lobby, client.UGI)
+	if settings != nil && settings.SuggestMigration {
+		suggest_migration(s, client.Lobby, client.UGI)
+	}
+
+	// Reports are sent often, so only acknowledge them if the client is listening
+	if listener == "" {
+		return
+	}
+	err := message.Code(
+		client,
+		"ACK_QUALITY_REPORT",
+		nil,
+		listener,
+		nil,
+	)
+	if err != nil {
+		logging.Packet(client, "QUALITY_REPORT").Error("Send response error", "response", "ACK_QUALITY_REPORT", "error", err)
+	}
+}
+
+// QUALITY_MATRIX handles the QUALITY_MATRIX opcode, which returns the lobby's connectivity matrix
+// as a structs.ConnectivityMatrix, built from the fresh QUALITY_REPORT samples of its members.
+func QUALITY_MATRIX(s *structs.Server, client *structs.Client, packet *structs.SignalPacket) {
+
+	// Require the peer to be in a lobby
+	if !client.AmIInALobby() {
+		err := message.Code(
+			client,
+			"CONFIG_REQUIRED",
+			nil,
+			packet.Listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "QUALITY_MATRIX").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
+		}
+		return
+	}
+
+	matrix := manager.GetConnectivityMatrix(s, client.Lobby, client.UGI)
+	if matrix == nil {
+		err := message.Code(
+			client,
+			"LOBBY_NOTFOUND",
+			nil,
+			packet.Listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "QUALITY_MATRIX").Error("Send response error", "response", "LOBBY_NOTFOUND", "error", err)
+		}
+		return
+	}
+
+	err := message.Code(
+		client,
+		"QUALITY_MATRIX",
+		matrix,
+		packet.Listener,
+		nil,
+	)
+	if err != nil {
+		logging.Packet(client, "QUALITY_MATRIX").Error("Send response error", "response", "QUALITY_MATRIX", "error", err)
+	}
+}
+
+// suggest_migration sends the host of a lobby a MIGRATE_HOST suggestion if another member is
+// much better connected. Suggestions are rate limited by manager.MigrationCooldown.
+func suggest_migration(s *structs.Server, lobby string, ugi string) {
+	best, score, hostscore := manager.SuggestHostMigration(s, lobby, ugi)
+	if best == nil {
+		return
+	}
+	host, err := manager.GetLobbyHost(s, lobby, ugi)
+	if err != nil || host == nil {
+		return
+	}
+	logging.Client(host).Info("Suggesting host migration", "candidate", best.ID, "cost", score, "host_cost", hostscore)
+	err = message.Code(
+		host,
+		"MIGRATE_HOST",
+		&structs.MigrateHostParams{
+			ID:        best.ID,
+			User:      best.Username,
+			Score:     score,
+			HostScore: hostscore,
+		},
+		"",
+		nil,
+	)
+	if err != nil {
+		logging.Client(host).Error("Send MIGRATE_HOST suggestion error", "error", err)
+	}
+}
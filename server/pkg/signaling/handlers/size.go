@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"log"
-
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/session"
@@ -21,7 +20,7 @@ func SIZE(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send CONFIG_REQUIRED response to SIZE opcode error: %s", err.Error())
+			logging.Packet(client, "SIZE").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
 		}
 		return
 	}
@@ -36,7 +35,7 @@ func SIZE(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send CONFIG_REQUIRED response to SIZE opcode error: %s", err.Error())
+			logging.Packet(client, "SIZE").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
 		}
 		return
 	}
@@ -55,7 +54,7 @@ func SIZE(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send VIOLATION response to SIZE opcode error: %s", err.Error())
+			logging.Packet(client, "SIZE").Error("Send response error", "response", "VIOLATION", "error", err)
 		}
 		session.Close(s, client)
 		return
@@ -65,7 +64,7 @@ func SIZE(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 	settings := manager.GetLobbySettings(s, client.Lobby, client.UGI)
 
 	// Get a count of all members in the lobby - subtract 1 for the host
-	log.Printf("Getting lobby %s members...", client.Lobby)
+	logging.Packet(client, "SIZE").Debug("Getting lobby members")
 	members := len(manager.GetLobbyPeers(s, client.Lobby, client.UGI)) - 1
 
 	// Don't allow the lobby to be resized smaller than the current number of members - Ignore if setting to zero, which means no limit.
@@ -78,7 +77,7 @@ func SIZE(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send WARNING response to SIZE opcode error: %s", err.Error())
+			logging.Packet(client, "SIZE").Error("Send response error", "response", "WARNING", "error", err)
 		}
 		return
 	}
@@ -96,6 +95,6 @@ func SIZE(s *structs.Server, client *structs.Client, packet *structs.SignalPacke
 		nil,
 	)
 	if err != nil {
-		log.Printf("Send ACK_SIZE response to SIZE opcode error: %s", err.Error())
+		logging.Packet(client, "SIZE").Error("Send response error", "response", "ACK_SIZE", "error", err)
 	}
 }
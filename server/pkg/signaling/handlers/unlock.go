@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"log"
-
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
@@ -25,7 +24,7 @@ func UNLOCK(s *structs.Server, client *structs.Client, packet *structs.SignalPac
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send CONFIG_REQUIRED response to UNLOCK opcode error: %s", err.Error())
+			logging.Packet(client, "UNLOCK").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
 		}
 		return
 	}
@@ -40,7 +39,7 @@ func UNLOCK(s *structs.Server, client *structs.Client, packet *structs.SignalPac
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send CONFIG_REQUIRED response to UNLOCK opcode error: %s", err.Error())
+			logging.Packet(client, "UNLOCK").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
 		}
 		return
 	}
@@ -58,7 +57,7 @@ func UNLOCK(s *structs.Server, client *structs.Client, packet *structs.SignalPac
 			nil,
 		)
 		if err != nil {
-			log.Printf("Send ALREADY_UNLOCKED response to UNLOCK opcode error: %s", err.Error())
+			logging.Packet(client, "UNLOCK").Error("Send response error", "response", "ALREADY_UNLOCKED", "error", err)
 		}
 		return
 	}
@@ -76,6 +75,6 @@ func UNLOCK(s *structs.Server, client *structs.Client, packet *structs.SignalPac
 		nil,
 	)
 	if err != nil {
-		log.Printf("Send ACK_LOCK response to UNLOCK opcode error: %s", err.Error())
+		logging.Packet(client, "UNLOCK").Error("Send response error", "response", "ACK_LOCK", "error", err)
 	}
 }
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"github.com/goccy/go-json"
+)
+
+// WS_RELAY handles the WS_RELAY opcode, which is used by clients that can't connect to the
+// server relay over WebRTC to exchange relay packets over the signaling websocket instead.
+//
+// The packet payload is a structs.RelayInboundSocketFrame, which contains the relay packet
+// and the data channel it belongs to. The packet is handled by the client's relay as if it
+// arrived over WebRTC, and the relay sends everything back to the client using WS_RELAY
+// packets from then on, so peers on either transport can talk to each other.
+func WS_RELAY(s *structs.Server, client *structs.Client, rawpacket []byte, listener string) {
+
+	// Require the peer to be in a lobby
+	if !client.AmIInALobby() {
+		err := message.Code(
+			client,
+			"CONFIG_REQUIRED",
+			nil,
+			listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "WS_RELAY").Error("Send response error", "response", "CONFIG_REQUIRED", "error", err)
+		}
+		return
+	}
+
+	// Require the lobby to use the server relay
+	relay := manager.GetRelay(s, client)
+	if relay == nil {
+		err := message.Code(
+			client,
+			"WARNING",
+			"Server relay is not enabled in this lobby",
+			listener,
+			nil,
+		)
+		if err != nil {
+			logging.Packet(client, "WS_RELAY").Error("Send response error", "response", "WARNING", "error", err)
+		}
+		return
+	}
+
+	// Read the raw packet as a relay packet
+	reparsed := &structs.RelayInboundSocketPacket{}
+	if err := json.Unmarshal(rawpacket, reparsed); err != nil {
+		logging.Packet(client, "WS_RELAY").Warn("Unmarshal packet error", "error", err)
+		message.Code(
+			client,
+			"WARNING",
+			err.Error(),
+			listener,
+			nil,
+		)
+		return
+	}
+	if err := s.PacketValidator.Struct(reparsed); err != nil {
+		message.Code(
+			client,
+			"WARNING",
+			err.Error(),
+			listener,
+			nil,
+		)
+		return
+	}
+
+	// Hand the packet to the relay
+	if err := peer.ReceiveSocket(relay, reparsed.Payload.Channel, reparsed.Payload.Packet); err != nil {
+		message.Code(
+			client,
+			"WARNING",
+			err.Error(),
+			listener,
+			nil,
+		)
+	}
+}
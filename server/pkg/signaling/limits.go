@@ -0,0 +1,160 @@
+package signaling
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/session"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	"golang.org/x/time/rate"
+)
+
+// DefaultOpcodeLimits are the rate limits of each opcode, unless the server is configured otherwise.
+// Opcodes that aren't listed share the "*" limit.
+var DefaultOpcodeLimits = map[string]structs.OpcodeLimit{
+	"*":              {Rate: 20, Burst: 40},
+	"KEEPALIVE":      {Rate: 2, Burst: 5},
+	"INIT":           {Rate: 0.2, Burst: 3},
+	"CONFIG_HOST":    {Rate: 0.5, Burst: 3},
+	"CONFIG_PEER":    {Rate: 0.5, Burst: 3},
+	"LOBBY_LIST":     {Rate: 1, Burst: 5},
+	"LOBBY_INFO":     {Rate: 2, Burst: 10},
+	"MAKE_OFFER":     {Rate: 10, Burst: 30},
+	"MAKE_ANSWER":    {Rate: 10, Burst: 30},
+	"ICE":            {Rate: 50, Burst: 200},
+	"DIAGNOSE":       {Rate: 0.1, Burst: 2},
+	"QUALITY_REPORT": {Rate: 2, Burst: 5},
+	"WS_RELAY":       {}, // limited by the relay itself
+}
+
+// DefaultMaxFrameSize is the largest websocket message a client may send, unless the server is
+// configured otherwise. It leaves room for a WS_RELAY packet carrying the largest relay message.
+const DefaultMaxFrameSize = 2 * peer.MaximumMessageSize
+
+// DefaultMaximumRateViolations is how many rate limited packets a client may send within
+// RateViolationWindow before it is disconnected, unless the server is configured otherwise.
+const DefaultMaximumRateViolations = 50
+
+// RateViolationWindow is how long a client has to stay within its rate limits before its
+// violations are forgiven.
+const RateViolationWindow = 10 * time.Second
+
+// DefaultSettings returns the server's settings before it is configured.
+func DefaultSettings(turnonly bool) *structs.Settings {
+	return &structs.Settings{
+		TURNOnly:     turnonly,
+		Limits:       &structs.ConnectionLimits{},
+		PacketLimits: DefaultSignalingLimits(),
+		DrainTimeout: session.DefaultDrainTimeout,
+
+		RecordingMaxSize:     peer.DefaultRecordingMaxSize,
+		RecordingMaxDuration: peer.DefaultRecordingMaxDuration,
+	}
+}
+
+// DefaultSignalingLimits returns a copy of the default signaling limits.
+func DefaultSignalingLimits() *structs.SignalingLimits {
+	return &structs.SignalingLimits{
+		Opcodes:           maps.Clone(DefaultOpcodeLimits),
+		MaxFrameSize:      DefaultMaxFrameSize,
+		MaximumViolations: DefaultMaximumRateViolations,
+	}
+}
+
+// ParseOpcodeLimits parses opcode rate limits written as "OPCODE=rate/burst" pairs separated by
+// commas, such as "LOBBY_LIST=1/5,ICE=50/200". A rate of 0 leaves the opcode unlimited.
+func ParseOpcodeLimits(text string) (map[string]structs.OpcodeLimit, error) {
+	parsed := map[string]structs.OpcodeLimit{}
+	for _, pair := range strings.Split(text, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		opcode, limit, found := strings.Cut(pair, "=")
+		opcode = strings.TrimSpace(opcode)
+		messages, burst, slash := strings.Cut(limit, "/")
+		if !found || !slash || opcode == "" {
+			return nil, fmt.Errorf("invalid opcode limit %q, expected OPCODE=rate/burst", pair)
+		}
+		r, err := strconv.ParseFloat(strings.TrimSpace(messages), 64)
+		if err != nil || r < 0 {
+			return nil, fmt.Errorf("invalid rate in opcode limit %q", pair)
+		}
+		b, err := strconv.Atoi(strings.TrimSpace(burst))
+		if err != nil || b < 1 {
+			return nil, fmt.Errorf("invalid burst in opcode limit %q", pair)
+		}
+		parsed[opcode] = structs.OpcodeLimit{Rate: r, Burst: b}
+	}
+	return parsed, nil
+}
+
+// throttle checks a packet against its opcode's rate limit. If the packet is over the limit,
+// the client is sent RATE_LIMITED and the packet is not allowed. Clients that keep exceeding
+// their limits are no longer tolerated, and should be disconnected. It must only be called
+// from the client's read loop.
+func throttle(s *structs.Server, client *structs.Client, packet *structs.SignalPacket) (allowed bool, tolerated bool) {
+	limiter := opcode_limiter(s, client, packet.Opcode)
+	if limiter == nil {
+		return true, true
+	}
+	now := time.Now()
+	reservation := limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return true, true
+	}
+	reservation.CancelAt(now)
+
+	// Record the violation
+	if now.Sub(client.LastViolation) > RateViolationWindow {
+		client.Violations = 0
+	}
+	client.Violations++
+	client.LastViolation = now
+	maximum := s.Settings.Load().PacketLimits.MaximumViolations
+
+	logging.Packet(client, packet.Opcode).Debug("Packet rate limited", "violations", client.Violations, "retry_after", delay)
+	message.Code(
+		client,
+		"RATE_LIMITED",
+		&structs.RateLimited{
+			Opcode:     packet.Opcode,
+			RetryAfter: int(delay.Milliseconds()) + 1,
+			Violations: client.Violations,
+			Maximum:    maximum,
+		},
+		packet.Listener,
+		nil,
+	)
+	return false, maximum <= 0 || client.Violations <= maximum
+}
+
+// opcode_limiter returns the client's token bucket for an opcode, creating it the first time
+// the opcode is sent. Opcodes without their own limit share the "*" bucket. It returns nil if
+// the opcode is unlimited.
+func opcode_limiter(s *structs.Server, client *structs.Client, opcode string) *rate.Limiter {
+	opcodes := s.Settings.Load().PacketLimits.Opcodes
+	limit, exists := opcodes[opcode]
+	if !exists {
+		opcode = "*"
+		limit = opcodes[opcode]
+	}
+	if limit.Rate <= 0 {
+		return nil
+	}
+	if client.Limiters == nil {
+		client.Limiters = make(map[string]*rate.Limiter)
+	}
+	limiter, exists := client.Limiters[opcode]
+	if !exists {
+		limiter = rate.NewLimiter(rate.Limit(limit.Rate), max(limit.Burst, 1))
+		client.Limiters[opcode] = limiter
+	}
+	return limiter
+}
@@ -1,8 +1,8 @@
 package message
 
 import (
-	"log"
-
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/metrics"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
 	"github.com/goccy/go-json"
 	"github.com/gofiber/contrib/websocket"
@@ -10,7 +10,11 @@ import (
 
 func Send(client *structs.Client, message interface{}) error {
 	if client == nil {
-		log.Printf("Got a nil client when sending message: %v", message)
+		logging.Signaling().Warn("Got a nil client when sending message", "opcode", opcode(message))
+		return nil
+	}
+	if client.Conn == nil {
+		logging.Client(client).Warn("Got a client without a connection when sending message", "opcode", opcode(message))
 		return nil
 	}
 
@@ -20,6 +24,11 @@ func Send(client *structs.Client, message interface{}) error {
 		return err
 	}
 
+	// Count the message
+	if code := opcode(message); code != "" {
+		metrics.Sent(code, len(bytes))
+	}
+
 	// Send the message
 	client.Mux.Lock()
 	defer client.Mux.Unlock()
@@ -35,3 +44,11 @@ func Broadcast(clients []*structs.Client, message interface{}) {
 		Send(client, message)
 	}
 }
+
+// opcode returns the opcode of a signaling packet, or an empty string if the message isn't one.
+func opcode(message interface{}) string {
+	if packet, ok := message.(*structs.SignalPacket); ok {
+		return packet.Opcode
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package origin
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cache is a least recently used cache of origin decisions.
+type cache struct {
+	mux     sync.Mutex
+	size    int
+	order   *list.List               // most recently used first
+	entries map[string]*list.Element // values are *decision
+}
+
+// decision is a cached origin decision.
+type decision struct {
+	key     string
+	allowed bool
+}
+
+func new_cache(size int) *cache {
+	return &cache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// get returns a cached decision, and whether it was found.
+func (c *cache) get(key string) (bool, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	element, exists := c.entries[key]
+	if !exists {
+		return false, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*decision).allowed, true
+}
+
+// put caches a decision, evicting the least recently used decision if the cache is full.
+func (c *cache) put(key string, allowed bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if element, exists := c.entries[key]; exists {
+		element.Value.(*decision).allowed = allowed
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&decision{key: key, allowed: allowed})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*decision).key)
+	}
+}
@@ -1,36 +1,172 @@
 package origin
 
 import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
 	"regexp"
 	"strings"
+
+	"github.com/goccy/go-json"
 )
 
-// Compile the allowed hosts into regex patterns.
-func CompilePatterns(allowedHosts []string) []*regexp.Regexp {
-	var patterns []*regexp.Regexp
-	for _, host := range allowedHosts {
+// DefaultCacheSize is how many origin decisions a policy remembers, unless it sets its own size.
+const DefaultCacheSize = 1024
+
+// Config is an origin policy, as written in a policy file or sent to the admin API.
+//
+// Entries of Origins and Games are either "*", which allows every origin, or a host with an
+// optional scheme and port, such as "example.com", "https://example.com" or "http://localhost:8080".
+// Hosts may use "*" as a wildcard, such as "https://*.example.com". Entries without a scheme
+// match any scheme, and entries without a port match any port.
+type Config struct {
+	Origins      []string            `json:"origins"`              // origins that may connect to every game
+	Games        map[string][]string `json:"games,omitempty"`      // origins of each game, keyed by UGI, which replace Origins for that game
+	AllowMissing bool                `json:"allow_missing"`        // allows requests without an Origin header, made by native clients
+	CacheSize    int                 `json:"cache_size,omitempty"` // decisions to remember, defaults to DefaultCacheSize
+}
+
+// Policy is a compiled Config, which decides whether an origin may connect. Its decisions are
+// cached, so a policy is replaced rather than changed, which also clears the cache.
+type Policy struct {
+	Config  Config
+	origins []*rule
+	games   map[string][]*rule
+	cache   *cache
+}
 
-		// Convert wildcard '*' to regex pattern.
-		// Escape other special regex characters.
-		pattern := "^" + regexp.QuoteMeta(host) + "$"
-		pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+// rule is a compiled entry of a Config.
+type rule struct {
+	scheme string         // empty matches any scheme
+	host   *regexp.Regexp // nil matches any origin
+	port   string         // empty matches any port
+}
 
-		// Compile the pattern and add it to the list.
-		regex, err := regexp.Compile(pattern)
-		if err == nil {
-			patterns = append(patterns, regex)
+// Compile compiles an origin policy, or returns an error if any of its entries is invalid.
+func Compile(config Config) (*Policy, error) {
+	policy := &Policy{
+		Config: config,
+		games:  make(map[string][]*rule, len(config.Games)),
+	}
+	var err error
+	if policy.origins, err = compile_rules(config.Origins); err != nil {
+		return nil, err
+	}
+	for ugi, origins := range config.Games {
+		if policy.games[ugi], err = compile_rules(origins); err != nil {
+			return nil, fmt.Errorf("game %s: %w", ugi, err)
 		}
 	}
-	return patterns
+	size := config.CacheSize
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	policy.cache = new_cache(size)
+	return policy, nil
 }
 
-// Check if a given URL matches any of the allowed host patterns.
-func IsAllowed(host string, origins []*regexp.Regexp) bool {
-	// Test the host against each compiled regex pattern.
-	for _, pattern := range origins {
-		if pattern.MatchString(host) {
+// Allowed checks whether an origin may connect to a game. An empty UGI uses the policy's
+// Origins. It also returns whether the decision came from the cache.
+func (p *Policy) Allowed(origin string, ugi string) (allowed bool, cached bool) {
+	key := ugi + "\x00" + origin
+	if allowed, cached := p.cache.get(key); cached {
+		return allowed, true
+	}
+	allowed = p.decide(origin, ugi)
+	p.cache.put(key, allowed)
+	return allowed, false
+}
+
+// decide checks an origin against the rules of a game, or the policy's Origins if the game
+// doesn't have its own.
+func (p *Policy) decide(origin string, ugi string) bool {
+	if origin == "" {
+		return p.Config.AllowMissing
+	}
+	rules, exists := p.games[ugi]
+	if !exists || ugi == "" {
+		rules = p.origins
+	}
+
+	// Origins that aren't URLs, such as "null", are only matched by "*"
+	parsed, err := url.Parse(origin)
+	valid := err == nil && parsed.Scheme != "" && parsed.Host != ""
+	for _, rule := range rules {
+		if rule.host == nil {
+			return true
+		}
+		if !valid {
+			continue
+		}
+		if rule.scheme != "" && !strings.EqualFold(rule.scheme, parsed.Scheme) {
+			continue
+		}
+		if rule.port != "" && rule.port != parsed.Port() {
+			continue
+		}
+		if rule.host.MatchString(strings.ToLower(parsed.Hostname())) {
 			return true
 		}
 	}
 	return false
 }
+
+// compile_rules compiles the entries of a Config.
+func compile_rules(entries []string) ([]*rule, error) {
+	rules := make([]*rule, 0, len(entries))
+	for _, entry := range entries {
+		rule, err := compile_rule(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// compile_rule compiles an entry of a Config.
+func compile_rule(entry string) (*rule, error) {
+	if entry == "*" {
+		return &rule{}, nil
+	}
+	compiled := &rule{}
+	if scheme, rest, found := strings.Cut(entry, "://"); found {
+		compiled.scheme = strings.ToLower(scheme)
+		entry = rest
+	}
+	if host, port, err := net.SplitHostPort(entry); err == nil {
+		if port == "" || strings.Trim(port, "0123456789") != "" {
+			return nil, fmt.Errorf("invalid port in origin %q", entry)
+		}
+		entry, compiled.port = host, port
+	}
+	entry = strings.TrimSuffix(strings.TrimPrefix(entry, "["), "]")
+	if entry == "" || strings.ContainsAny(entry, "/?#@") {
+		return nil, fmt.Errorf("invalid host in origin %q", entry)
+	}
+
+	// Convert wildcard '*' to regex pattern.
+	// Escape other special regex characters.
+	pattern := "^" + regexp.QuoteMeta(strings.ToLower(entry)) + "$"
+	pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+	host, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compiled.host = host
+	return compiled, nil
+}
+
+// Load reads and compiles an origin policy from a JSON file.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := Config{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return Compile(config)
+}
@@ -0,0 +1,133 @@
+package session
+
+import (
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// DefaultDrainTimeout is how long clients are given to leave when the server drains, unless
+// the server is configured otherwise.
+const DefaultDrainTimeout = 30 * time.Second
+
+// HandlerGrace is how long Drain waits past its deadline for the packets being handled to finish.
+const HandlerGrace = 2 * time.Second
+
+// DrainInterval is how often Drain checks whether every client has left.
+const DrainInterval = 100 * time.Millisecond
+
+// Drain empties the server ahead of a shutdown or on request of an administrator. New sessions
+// and lobbies are refused from now on, and every client is sent SERVER_SHUTDOWN with the given
+// reason, a reconnect hint and the deadline. Clients that are still connected at the deadline
+// are kicked, once the packets being handled have finished and every relay has been shut down.
+//
+// Drain blocks until the server is empty or the deadline has passed. CancelDrain before then
+// cancels the drain. Otherwise, the server keeps refusing new sessions and lobbies until
+// CancelDrain is called. A drain that starts while another one is running supersedes it, so
+// that only the newest drain, and its deadline, kicks the clients that are left.
+func Drain(s *structs.Server, reason string, deadline time.Time) {
+	s.DrainLock.Lock()
+	drain := start_drain(s)
+	s.DrainLock.Unlock()
+	drain_clients(s, drain, reason, deadline)
+}
+
+// StartDrain drains the server in the background like Drain, unless it is already draining.
+// It returns false if it was, so that administrators can't start overlapping drains.
+func StartDrain(s *structs.Server, reason string, deadline time.Time) bool {
+	s.DrainLock.Lock()
+	defer s.DrainLock.Unlock()
+	if s.Draining.Load() {
+		return false
+	}
+	go drain_clients(s, start_drain(s), reason, deadline)
+	return true
+}
+
+// start_drain makes the server refuse new sessions and lobbies, and returns the number of the
+// new drain. The caller must hold DrainLock.
+func start_drain(s *structs.Server) uint64 {
+	s.Draining.Store(true)
+	return s.Drains.Add(1)
+}
+
+// drain_clients sends SERVER_SHUTDOWN to every client, waits for them to leave, and kicks the
+// ones that are left at the deadline, unless the drain is cancelled or superseded first.
+func drain_clients(s *structs.Server, drain uint64, reason string, deadline time.Time) {
+	clients := manager.GetAllSessions(s)
+	logger := logging.Signaling().With("reason", reason, "deadline", deadline)
+	logger.Warn("Server is draining, new sessions and lobbies are refused", "sessions", len(clients))
+
+	// Give clients until the deadline to leave
+	retry := time.Until(deadline)
+	if retry < 0 {
+		retry = 0
+	}
+	message.Broadcast(
+		clients,
+		&structs.SignalPacket{
+			Opcode: "SERVER_SHUTDOWN",
+			Payload: &structs.ShutdownParams{
+				Reason:     reason,
+				Reconnect:  s.Settings.Load().ReconnectURL,
+				RetryAfter: int(retry / time.Millisecond),
+				Deadline:   deadline,
+			},
+		},
+	)
+
+	// Leave the remaining clients connected if draining was cancelled, or to a newer drain
+	stopped := func() bool {
+		if s.Drains.Load() != drain {
+			logger.Info("Server drain superseded by a newer one")
+			return true
+		}
+		if !s.Draining.Load() {
+			logger.Info("Server drain cancelled")
+			return true
+		}
+		return false
+	}
+	for len(manager.GetAllSessions(s)) > 0 && time.Now().Before(deadline) {
+		time.Sleep(DrainInterval)
+		if stopped() {
+			return
+		}
+	}
+
+	// Let packets that are being handled finish, so that they don't race the kicks
+	for s.Inflight.Load() > 0 && time.Now().Before(deadline.Add(HandlerGrace)) {
+		time.Sleep(DrainInterval)
+		if stopped() {
+			return
+		}
+	}
+
+	// Shut down every relay, then disconnect the clients that are left, unless the drain was
+	// cancelled or superseded while it waited
+	s.DrainLock.Lock()
+	defer s.DrainLock.Unlock()
+	if stopped() {
+		return
+	}
+	for _, relay := range manager.GetAllRelays(s) {
+		manager.DeleteRelay(s, relay.Peer)
+	}
+	remaining := manager.GetAllSessions(s)
+	for _, client := range remaining {
+		Kick(s, client, "The server is shutting down")
+	}
+	logger.Info("Server drained", "kicked", len(remaining))
+}
+
+// CancelDrain stops the server from draining, so that it accepts new sessions and lobbies again.
+// A drain that is running leaves the remaining clients connected, unless it has already started
+// kicking them. It returns false if the server wasn't draining.
+func CancelDrain(s *structs.Server) bool {
+	s.DrainLock.Lock()
+	defer s.DrainLock.Unlock()
+	return s.Draining.Swap(false)
+}
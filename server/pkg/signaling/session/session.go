@@ -1,9 +1,11 @@
 package session
 
 import (
-	"log"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
@@ -23,7 +25,7 @@ func Open(s *structs.Server, conn *websocket.Conn) *structs.Client {
 		ID:             ulid.Make().String(),
 		Username:       "",
 		Session:        s.WebsocketConnCounter,
-		UGI:            "", // Stub from original server code that I don't feel like refactoring out
+		UGI:            "", // set below to the game that the upgrader checked the client's origin for
 		Mode:           0,
 		Mux:            &sync.RWMutex{},
 		Metadata:       make(map[string]any),
@@ -33,13 +35,23 @@ func Open(s *structs.Server, conn *websocket.Conn) *structs.Client {
 	// Increment counter
 	s.WebsocketConnCounter++
 
+	// Take over the session that the upgrader admitted for the client's address
+	if address, ok := conn.Locals("address").(string); ok {
+		client.Address = address
+	}
+
+	// Bind the client to its game, which can't be changed for the rest of the session
+	if ugi, ok := conn.Locals("ugi").(string); ok {
+		client.UGI = ugi
+	}
+
 	// Add entry with ULID as key and values
 	manager.CreateSession(s, client)
 
 	// Add client entry to games
-	manager.AddClientToGame(s, "", client)
+	manager.AddClientToGame(s, client.UGI, client)
 
-	log.Printf("Created new session for peer %s (websocket ID %d)", client.ID, client.Session)
+	logging.Client(client).Info("Created new session")
 	return client
 }
 
@@ -52,7 +64,7 @@ func Open(s *structs.Server, conn *websocket.Conn) *structs.Client {
 // and closes client connections. Logs the closure of the session.
 func Close(s *structs.Server, client *structs.Client) {
 	if client == nil {
-		log.Printf("Warning: Attempted to close nil client")
+		logging.Signaling().Warn("Attempted to close nil client")
 		return
 	}
 
@@ -63,6 +75,9 @@ func Close(s *structs.Server, client *structs.Client) {
 
 	// Clear session entry
 	manager.DeleteSession(s, client)
+	if client.Address != "" {
+		manager.RemoveAddressSession(s, client.Address)
+	}
 
 	// Close the connection handler.
 	if err := client.Conn.Close(); err != nil {
@@ -73,7 +88,7 @@ func Close(s *structs.Server, client *structs.Client) {
 		panic(err)
 	}
 
-	log.Printf("Closed session for peer %s (websocket ID %d)", client.ID, client.Session)
+	logging.Client(client).Info("Closed session")
 }
 
 // PrepareToChangeModesOrDisconnect handles a client leaving their current
@@ -88,8 +103,8 @@ func PrepareToChangeModesOrDisconnect(s *structs.Server, client *structs.Client)
 	if client.AmIPeer() {
 
 		// notify the host and members of the lobby that the peer is leaving
-		members := manager.GetLobbyPeers(s, client.Lobby, client.UGI)
-		members = manager.WithoutPeer(members, client)
+		everyone := slices.Clone(manager.GetLobbyPeers(s, client.Lobby, client.UGI))
+		members := manager.WithoutPeer(everyone, client)
 		message.Broadcast(
 			members,
 			&structs.SignalPacket{
@@ -102,6 +117,13 @@ func PrepareToChangeModesOrDisconnect(s *structs.Server, client *structs.Client)
 		)
 
 		leave_lobby(s, client)
+
+		// Connect the members that the lobby's topology connects now that the peer is gone
+		settings := manager.GetLobbySettings(s, client.Lobby, client.UGI)
+		host, err := manager.GetLobbyHost(s, client.Lobby, client.UGI)
+		if settings != nil && err == nil {
+			rewire(settings, host, everyone, host, members)
+		}
 	}
 
 	// Check if host
@@ -127,6 +149,10 @@ func PrepareToChangeModesOrDisconnect(s *structs.Server, client *structs.Client)
 		}
 	}
 
+	// Shut down the client's relay, if it has one. Peers and hosts that hand the lobby over
+	// would otherwise leave their relay behind.
+	manager.DeleteRelay(s, client)
+
 	// Clear the current mode and disassociate from lobbies
 	client.ClearMode()
 	client.ClearLobby()
@@ -204,17 +230,18 @@ func LeaveLobbyWithPeerBasedReclaim(s *structs.Server, client *structs.Client, s
 // with automated host reclaim. It first removes the current host, then checks
 // for remaining peers in the lobby. If no peers remain, it checks if a server-side
 // relay is used and deletes it if necessary, then destroys the lobby. If peers
-// are present, it assigns the first peer in the list as the new host and broadcasts
-// a HOST_RECLAIM message to inform all remaining peers of the new host. Finally,
+// are present, it assigns the best connected peer, according to the lobby's
+// QUALITY_REPORT samples, as the new host and broadcasts a HOST_RECLAIM message
+// to inform all remaining peers of the new host. Finally,
 // it ensures the client is removed from the lobby.
 func LeaveLobbyWithAutomatedReclaim(s *structs.Server, client *structs.Client, settings *structs.LobbySettings) {
 
 	// First, remove the current host
 	manager.RemoveLobbyHost(s, client.Lobby, client.UGI, client)
 
-	// Next, get all the current peers in the lobby, exclude the current host, and make the first one the new host
-	peers := manager.GetLobbyPeers(s, client.Lobby, client.UGI)
-	peers = manager.WithoutPeer(peers, client)
+	// Next, get all the current peers in the lobby, and exclude the current host
+	everyone := slices.Clone(manager.GetLobbyPeers(s, client.Lobby, client.UGI))
+	peers := manager.WithoutPeer(everyone, client)
 
 	// If there are no peers, close the lobby.
 	if len(peers) == 0 {
@@ -229,22 +256,26 @@ func LeaveLobbyWithAutomatedReclaim(s *structs.Server, client *structs.Client, s
 
 	} else {
 
-		// Re-assign the new host
-		manager.SetLobbyHost(s, client.Lobby, client.UGI, peers[0])
+		// Re-assign the new host to the best connected peer
+		host := manager.BestConnectedPeer(s, client.Lobby, client.UGI, peers)
+		manager.SetLobbyHost(s, client.Lobby, client.UGI, host)
 
 		// Tell all peers about the new host using the HOST_RECLAIM opcode.
 		// This opcode is used to inform all clients of the new host.
 		// The specific peer that is the new host will need to update their local state to reflect this as well.
-		peers[0].SetHostMode()
+		host.SetHostMode()
 		message.Broadcast(
 			peers,
 			&structs.SignalPacket{
 				Opcode: "HOST_RECLAIM",
 				Payload: &structs.PeerInfo{
-					ID:   peers[0].ID,
-					User: peers[0].Username},
+					ID:   host.ID,
+					User: host.Username},
 			},
 		)
+
+		// Connect the new host to the members that the lobby's topology connects it to
+		rewire(settings, client, everyone, host, peers)
 	}
 
 	leave_lobby(s, client)
@@ -290,3 +321,52 @@ func LeaveAndDestroyLobby(s *structs.Server, client *structs.Client, settings *s
 
 	leave_lobby(s, client)
 }
+
+// KickTimeout is how long a kicked client has to acknowledge the closing handshake before its
+// connection is dropped.
+const KickTimeout = 5 * time.Second
+
+// Kick disconnects a client on behalf of the server. The client is sent the KICKED opcode with
+// the reason, followed by a websocket close frame. The session is cleaned up by its handler once
+// the connection closes, like any other disconnect, so Kick is safe to call from any goroutine.
+func Kick(s *structs.Server, client *structs.Client, reason string) {
+	message.Code(
+		client,
+		"KICKED",
+		reason,
+		"",
+		nil,
+	)
+
+	client.Mux.Lock()
+	defer client.Mux.Unlock()
+	deadline := time.Now().Add(KickTimeout)
+	client.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason), deadline)
+
+	// Drop clients that don't finish the closing handshake in time
+	client.Conn.SetReadDeadline(deadline)
+	logging.Client(client).Info("Kicked session", "reason", reason)
+}
+
+// CloseLobby closes a lobby in a game on behalf of the server, whether or not it has a host.
+// Every member is sent the LOBBY_CLOSE opcode and leaves the lobby, but stays connected so
+// that it can host or join another lobby. Their relays are shut down.
+func CloseLobby(s *structs.Server, ugi string, lobby string) {
+	members := slices.Clone(manager.GetLobbyPeers(s, lobby, ugi))
+
+	message.Broadcast(
+		members,
+		&structs.SignalPacket{
+			Opcode: "LOBBY_CLOSE",
+		},
+	)
+
+	for _, member := range members {
+		manager.DeleteRelay(s, member)
+		manager.RemoveClientFromLobby(s, lobby, ugi, member)
+		member.ClearMode()
+		member.ClearLobby()
+	}
+	manager.DestroyLobby(s, ugi, lobby)
+	logging.Signaling().Info("Closed lobby", "ugi", ugi, "lobby", lobby, "members", len(members))
+}
@@ -0,0 +1,65 @@
+package session
+
+import (
+	"slices"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+)
+
+// rewire connects the members of a lobby that its topology didn't connect before a member left
+// or the host changed, but does now. For example, a star lobby's new host must connect to every
+// peer, and a peer may move into the mesh of a hybrid lobby when a mesh member leaves.
+//
+// Connections are made the same way as when joining a lobby: the host is sent NEW_PEER, and
+// otherwise the member that joined later is sent DISCOVER. The other member is sent ANTICIPATE.
+func rewire(settings *structs.LobbySettings, oldhost *structs.Client, before []*structs.Client, newhost *structs.Client, after []*structs.Client) {
+	for i, a := range after {
+		for _, b := range after[i+1:] {
+			if !manager.ShouldConnect(settings, newhost, after, a, b) {
+				continue
+			}
+			if slices.Contains(before, a) && slices.Contains(before, b) && manager.ShouldConnect(settings, oldhost, before, a, b) {
+				continue
+			}
+
+			// The host makes the offer, otherwise the member that joined later does
+			offerer, answerer := b, a
+			if a == newhost {
+				offerer, answerer = a, b
+			}
+			connect(offerer, answerer, offerer == newhost)
+		}
+	}
+}
+
+// connect tells one lobby member to make a connection to another, and the other member to expect it.
+func connect(offerer *structs.Client, answerer *structs.Client, host bool) {
+	opcode := "DISCOVER"
+	if host {
+		opcode = "NEW_PEER"
+	}
+	message.Code(
+		offerer,
+		opcode,
+		&structs.NewPeerParams{
+			ID:        answerer.ID,
+			User:      answerer.Username,
+			PublicKey: answerer.PublicKey,
+		},
+		"",
+		nil,
+	)
+	message.Code(
+		answerer,
+		"ANTICIPATE",
+		&structs.NewPeerParams{
+			ID:        offerer.ID,
+			User:      offerer.Username,
+			PublicKey: offerer.PublicKey,
+		},
+		"",
+		nil,
+	)
+}
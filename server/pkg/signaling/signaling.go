@@ -1,14 +1,23 @@
 package signaling
 
 import (
-	"log"
+	"errors"
+	"math"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/logging"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/metrics"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/peer"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/address"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/handlers"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/message"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/origin"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/session"
 	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	fastws "github.com/fasthttp/websocket"
 	"github.com/go-playground/validator/v10"
 	"github.com/goccy/go-json"
 	"github.com/gofiber/contrib/websocket"
@@ -18,45 +27,72 @@ import (
 
 type Server structs.Server
 
-func Initialize(allowedorigins []string, turnonly bool) *Server {
+// MaximumUGILength is the longest game ID, given by the ugi query parameter, that a client may connect with.
+const MaximumUGILength = 128
+
+func Initialize(allowedorigins []string, turnonly bool, recordings string) *Server {
 	s := &Server{
-		AuthorizedOriginsStorage: origin.CompilePatterns(allowedorigins),
-		Mux:                      &sync.RWMutex{},
-		TURNOnly:                 turnonly,
-		Games:                    &structs.GameStore{Mutex: sync.RWMutex{}, Games: make(map[string]*structs.Game)},
-		Sessions:                 &structs.SessionStore{Mutex: sync.RWMutex{}, Sessions: make(map[string]*structs.Session)},
-		Relays:                   make(map[*structs.Client]*structs.Relay),
-		RelayLock:                &sync.RWMutex{},
-		PacketValidator:          validator.New(validator.WithRequiredStructEnabled()),
-		WebsocketConnCounter:     0,
+		Mux:                  &sync.RWMutex{},
+		Games:                &structs.GameStore{Mutex: sync.RWMutex{}, Games: make(map[string]*structs.Game)},
+		Sessions:             &structs.SessionStore{Mutex: sync.RWMutex{}, Sessions: make(map[string]*structs.Session)},
+		Relays:               make(map[*structs.Client]*structs.Relay),
+		RelayLock:            &sync.RWMutex{},
+		PacketValidator:      validator.New(validator.WithRequiredStructEnabled()),
+		WebsocketConnCounter: 0,
+		Diagnostics:          make(map[*structs.Client]*structs.Diagnostic),
+		DiagnosticsLock:      &sync.RWMutex{},
+		Addresses:            &structs.AddressStore{Addresses: make(map[string]*structs.AddressState)},
+		Stopped:              make(chan struct{}),
 	}
 
+	settings := DefaultSettings(turnonly)
+	settings.RecordingsDir = recordings
+	s.Settings.Store(settings)
+
+	// Native clients don't send an Origin header, so they are allowed unless the policy is replaced
+	policy, err := origin.Compile(origin.Config{Origins: allowedorigins, AllowMissing: true})
+	if err != nil {
+		panic(err)
+	}
+	s.Origins.Store(policy)
+	s.OriginBase.Store(policy)
+
 	if turnonly {
-		log.Print("TURN only mode enabled. Candidates that specify STUN will be ignored, and only TURN candidates will be relayed.")
+		logging.Signaling().Info("TURN only mode enabled. Candidates that specify STUN will be ignored, and only TURN candidates will be relayed.")
 	}
 
+	if recordings != "" {
+		logging.Signaling().Info("Relay recording enabled. Lobbies that request it will be recorded.", "directory", recordings)
+	}
+
+	// Collect the server's metrics
+	metrics.Register((*structs.Server)(s))
+
+	// Supervise the server's relays
+	go peer.Supervise((*structs.Server)(s), s.Stopped)
+
 	return s
 }
 
-// AuthorizedOrigins implements the CheckOrigin method of the websocket.Upgrader.
-// This checks if the incoming request's origin is allowed to connect to the server.
-// The server will log if the origin is permitted or rejected.
+// AuthorizedOrigins checks if the incoming request's origin is allowed to connect to the server,
+// and to the game given by its ugi query parameter, according to the server's origin policy.
+// Decisions are cached by the policy, so the server only logs if an origin is permitted or
+// rejected the first time it is checked.
 func (s *Server) AuthorizedOrigins(r *fasthttp.Request) bool {
-	log.Printf("Origin: %s, Host: %s", r.Header.Peek("Origin"), r.Host())
-
-	// Check if the origin is allowed
-	result := origin.IsAllowed(string(r.Header.Peek("Origin")), s.AuthorizedOriginsStorage)
+	requested := string(r.Header.Peek("Origin"))
+	ugi := string(r.URI().QueryArgs().Peek("ugi"))
+	result, cached := s.Origins.Load().Allowed(requested, ugi)
+	if cached {
+		return result
+	}
 
 	// Logging
+	logger := logging.Signaling().With("origin", requested, "ugi", ugi, "host", string(r.Host()))
 	if result {
-		log.Print("Origin permitted to connect")
+		logger.Debug("Origin permitted to connect")
 	} else {
-		log.Print("Origin was rejected during connect")
+		logger.Info("Origin was rejected during connect")
 	}
-
-	// TODO: cache the result to speed up future checks
-
-	// Return the result
 	return result
 }
 
@@ -64,20 +100,67 @@ func (s *Server) AuthorizedOrigins(r *fasthttp.Request) bool {
 // sets a local variable to true. If the client did not request a websocket
 // upgrade, this middleware will return ErrUpgradeRequired. If the client
 // is not allowed to connect, this middleware will return ErrForbidden. If
-// the client does not provide a UGI, this middleware will return ErrBadRequest.
+// the client's UGI is longer than MaximumUGILength, this middleware will return ErrBadRequest.
+// While the server is draining or full, it returns ErrServiceUnavailable.
+// If the client's IP address has too many sessions or connects too often, it
+// returns ErrTooManyRequests. Otherwise a session is admitted for the address,
+// which is released if the upgrade fails, or by the session once it closes.
 func (s *Server) Upgrader(c *fiber.Ctx) error {
+	if s.Draining.Load() {
+		return fiber.ErrServiceUnavailable
+	}
+
+	// Check the client's address against the allow and deny lists
+	limits := s.Settings.Load().Limits
+	forwarded := []string{}
+	for _, header := range c.Request().Header.PeekAll(fiber.HeaderXForwardedFor) {
+		forwarded = append(forwarded, string(header))
+	}
+	ip := address.Resolve(c.Context().RemoteIP(), forwarded, limits.TrustedProxies)
+	if address.Contains(ip, limits.Deny) || (len(limits.Allow) > 0 && !address.Contains(ip, limits.Allow)) {
+		logging.Signaling().Info("Address was rejected during connect", "ip", ip.String())
+		return fiber.ErrForbidden
+	}
+
 	if !s.AuthorizedOrigins(c.Request()) {
 		return fiber.ErrForbidden
 	}
+	ugi := c.Query("ugi")
+	if len(ugi) > MaximumUGILength {
+		return fiber.ErrBadRequest
+	}
 
 	// IsWebSocketUpgrade returns true if the client
 	// requested upgrade to the WebSocket protocol.
-	if websocket.IsWebSocketUpgrade(c) {
-		c.Locals("allowed", true)
-		return c.Next()
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
 	}
 
-	return fiber.ErrUpgradeRequired
+	// Enforce the connection limits
+	retry, err := manager.AdmitAddress((*structs.Server)(s), ip.String())
+	switch err {
+	case nil:
+	case manager.ErrServerFull:
+		logging.Signaling().Warn("Connection rejected", "ip", ip.String(), "error", err)
+		return fiber.ErrServiceUnavailable
+	case manager.ErrAddressThrottle:
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		fallthrough
+	default:
+		logging.Signaling().Info("Connection rejected", "ip", ip.String(), "error", err)
+		return fiber.ErrTooManyRequests
+	}
+
+	// The admitted session is handed over to the websocket handler, so release it if the upgrade fails
+	// The session joins the game its origin was checked for, so that it can't use another game's lobbies
+	c.Locals("allowed", true)
+	c.Locals("address", ip.String())
+	c.Locals("ugi", ugi)
+	if err := c.Next(); err != nil || c.Response().StatusCode() != fiber.StatusSwitchingProtocols {
+		manager.RemoveAddressSession((*structs.Server)(s), ip.String())
+		return err
+	}
+	return nil
 }
 
 // Handler is an HTTP handler that handles WebSocket connections and relays messages.
@@ -94,13 +177,19 @@ func (srv *Server) Handler(conn *websocket.Conn) {
 
 	// Handle messages and close handler when disconnected
 	defer session.Close(s, client)
+	frame := s.Settings.Load().PacketLimits.MaxFrameSize
+	if frame > 0 {
+		conn.SetReadLimit(frame)
+	}
 	for {
 
 		// Read packet
 		_, rawpacket, err := conn.ReadMessage()
 		if err != nil {
-			if !(websocket.IsCloseError(err) || websocket.IsUnexpectedCloseError(err)) {
-				log.Fatalf("WebSocket unhandled receive error: %s\n", err)
+			if errors.Is(err, fastws.ErrReadLimit) {
+				logging.Client(client).Info("Disconnecting client that sent an oversized frame", "limit", frame)
+			} else if !(websocket.IsCloseError(err) || websocket.IsUnexpectedCloseError(err)) {
+				logging.Client(client).Error("WebSocket unhandled receive error", "error", err)
 			}
 			return
 		}
@@ -108,6 +197,7 @@ func (srv *Server) Handler(conn *websocket.Conn) {
 		// Decode packet
 		var packet *structs.SignalPacket
 		if err := json.Unmarshal(rawpacket, &packet); err != nil {
+			metrics.Violated()
 			conn.WriteJSON(&structs.SignalPacket{
 				Opcode:  "VIOLATION",
 				Payload: "Packet decoding error",
@@ -117,6 +207,7 @@ func (srv *Server) Handler(conn *websocket.Conn) {
 
 		// Validate the packet
 		if err := s.PacketValidator.Struct(packet); err != nil {
+			metrics.Violated()
 			conn.WriteJSON(&structs.SignalPacket{
 				Opcode:  "VIOLATION",
 				Payload: err.Error(),
@@ -124,12 +215,39 @@ func (srv *Server) Handler(conn *websocket.Conn) {
 			return
 		}
 
-		// Allow for concurrent packet processing
+		// Enforce the opcode's rate limit
+		if allowed, tolerated := throttle(s, client, packet); !allowed {
+			if tolerated {
+				continue
+			}
+			logging.Packet(client, packet.Opcode).Info("Disconnecting client that kept exceeding its rate limits")
+			message.Code(
+				client,
+				"VIOLATION",
+				"Rate limits exceeded too often",
+				packet.Listener,
+				nil,
+			)
+			return
+		}
+
+		// Allow for concurrent packet processing. Handlers are counted so that draining can wait for them.
+		s.Inflight.Add(1)
 		go execute_packet(s, client, packet, rawpacket)
 	}
 }
 
 func execute_packet(s *structs.Server, client *structs.Client, packet *structs.SignalPacket, rawpacket []byte) {
+	// Count the packet and time its handler. Unknown opcodes are counted together.
+	opcode := packet.Opcode
+	started := time.Now()
+	defer func() {
+		s.Inflight.Add(-1)
+		metrics.Received(opcode, len(rawpacket))
+		metrics.Handled(opcode, started)
+		logging.Packet(client, opcode).Debug("Packet handled", "duration", time.Since(started))
+	}()
+
 	// Handle opcodes accordingly.
 	switch packet.Opcode {
 
@@ -171,6 +289,26 @@ func execute_packet(s *structs.Server, client *structs.Client, packet *structs.S
 	case "ICE":
 		handlers.ICE(s, client, packet, rawpacket)
 
+	// Coordinates an ICE restart with another lobby member or the relay.
+	case "ICE_RESTART":
+		handlers.ICE_RESTART(s, client, packet)
+
+	// Reports that a connection recovered after an ICE restart.
+	case "ICE_RESTART_DONE":
+		handlers.ICE_RESTART_DONE(s, client, packet)
+
+	// Tests the client's connectivity to the server relay.
+	case "DIAGNOSE":
+		handlers.DIAGNOSE(s, client, packet)
+
+	// Reports the client's connection quality to the other lobby members.
+	case "QUALITY_REPORT":
+		handlers.QUALITY_REPORT(s, client, rawpacket, packet.Listener)
+
+	// Provides the lobby's connectivity matrix.
+	case "QUALITY_MATRIX":
+		handlers.QUALITY_MATRIX(s, client, packet)
+
 	// Provides a list of all open lobbies to join.
 	case "LOBBY_LIST":
 		handlers.LOBBY_LIST(s, client, packet)
@@ -191,11 +329,16 @@ func execute_packet(s *structs.Server, client *structs.Client, packet *structs.S
 	case "SIZE":
 		handlers.SIZE(s, client, packet)
 
+	// Exchanges relay packets over the websocket when WebRTC to the relay fails.
+	case "WS_RELAY":
+		handlers.WS_RELAY(s, client, rawpacket, packet.Listener)
+
 	case "TRANSITION_ACK":
-		log.Print("Transition ACK received")
+		logging.Packet(client, "TRANSITION_ACK").Debug("Transition ACK received")
 		client.TransitionDone <- true
 
 	default:
+		opcode = "unknown"
 		message.Code(
 			client,
 			"VIOLATION",
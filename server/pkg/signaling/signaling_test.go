@@ -0,0 +1,157 @@
+package signaling
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/manager"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/origin"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/structs"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newApp returns a server that admits one session per address, and an app that serves it.
+func newApp(t *testing.T) (*Server, *fiber.App) {
+	t.Helper()
+	s := &Server{
+		Mux:             &sync.RWMutex{},
+		Games:           &structs.GameStore{Games: make(map[string]*structs.Game)},
+		Sessions:        &structs.SessionStore{Sessions: make(map[string]*structs.Session)},
+		Relays:          make(map[*structs.Client]*structs.Relay),
+		RelayLock:       &sync.RWMutex{},
+		PacketValidator: validator.New(validator.WithRequiredStructEnabled()),
+		Diagnostics:     make(map[*structs.Client]*structs.Diagnostic),
+		DiagnosticsLock: &sync.RWMutex{},
+		Addresses:       &structs.AddressStore{Addresses: make(map[string]*structs.AddressState)},
+		Stopped:         make(chan struct{}),
+	}
+	settings := DefaultSettings(false)
+	settings.Limits = &structs.ConnectionLimits{MaxSessionsPerIP: 1}
+	s.Settings.Store(settings)
+	policy, err := origin.Compile(origin.Config{Origins: []string{"*"}, AllowMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Origins.Store(policy)
+
+	app := fiber.New()
+	app.Use("/", s.Upgrader)
+	app.Get("/", websocket.New(s.Handler))
+	t.Cleanup(func() { app.Shutdown() })
+	return s, app
+}
+
+// sessions returns the number of sessions admitted for every address.
+func sessions(s *Server) int {
+	s.Addresses.Mutex.Lock()
+	defer s.Addresses.Mutex.Unlock()
+	return s.Addresses.Sessions
+}
+
+func TestFailedUpgradeReleasesSession(t *testing.T) {
+	s, app := newApp(t)
+
+	// Ask for an upgrade without a websocket key, which fails after the address is admitted
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(fiber.MethodGet, "/?ugi=game", nil)
+		req.Header.Set(fiber.HeaderConnection, "Upgrade")
+		req.Header.Set(fiber.HeaderUpgrade, "websocket")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != fiber.StatusUpgradeRequired {
+			t.Fatalf("the failed upgrade got %d, want %d", resp.StatusCode, fiber.StatusUpgradeRequired)
+		}
+		if count := sessions(s); count != 0 {
+			t.Fatalf("%d sessions are admitted after the upgrade failed, want 0", count)
+		}
+	}
+}
+
+func TestClosedSessionReleasesAddress(t *testing.T) {
+	s, app := newApp(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+	url := "ws://" + listener.Addr().String() + "/?ugi=game"
+
+	conn, _, err := fastws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, resp, err := fastws.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatal("a second session was admitted for an address with a limit of one")
+	}
+
+	conn.Close()
+	for deadline := time.Now().Add(5 * time.Second); sessions(s) != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the closed session wasn't released")
+		}
+	}
+	conn, _, err = fastws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("the address couldn't connect again after its session closed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestSessionsJoinTheirCheckedGame(t *testing.T) {
+	s, app := newApp(t)
+	settings := *s.Settings.Load()
+	settings.Limits = &structs.ConnectionLimits{}
+	s.Settings.Store(&settings)
+	policy, err := origin.Compile(origin.Config{Origins: []string{"*"}, Games: map[string][]string{"private": {"https://private.example.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Origins.Store(policy)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+	base := "ws://" + listener.Addr().String() + "/"
+
+	dial := func(query string, from string) (*fastws.Conn, int) {
+		conn, resp, err := fastws.DefaultDialer.Dial(base+query, http.Header{"Origin": {from}})
+		if err != nil {
+			return nil, resp.StatusCode
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn, resp.StatusCode
+	}
+	if _, status := dial("?ugi=private", "https://elsewhere.example.com"); status != fiber.StatusForbidden {
+		t.Fatalf("an origin outside the game's allowlist got %d, want %d", status, fiber.StatusForbidden)
+	}
+	if _, status := dial("?ugi="+strings.Repeat("x", MaximumUGILength+1), "https://elsewhere.example.com"); status != fiber.StatusBadRequest {
+		t.Fatalf("an oversized game ID got %d, want %d", status, fiber.StatusBadRequest)
+	}
+	dial("", "https://elsewhere.example.com")
+	dial("?ugi=private", "https://private.example.com")
+
+	games := map[string]int{}
+	for deadline := time.Now().Add(5 * time.Second); len(manager.GetAllSessions((*structs.Server)(s))) != 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the websocket connections didn't open sessions")
+		}
+	}
+	for _, client := range manager.GetAllSessions((*structs.Server)(s)) {
+		games[client.UGI]++
+	}
+	if games["private"] != 1 || games[""] != 1 {
+		t.Fatalf("sessions joined the games %v, want one in the private game and one in the default game", games)
+	}
+}
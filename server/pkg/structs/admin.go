@@ -0,0 +1,52 @@
+package structs
+
+import "time"
+
+// AdminGame is a game as shown by the admin API.
+type AdminGame struct {
+	UGI      string `json:"ugi"`
+	Sessions int    `json:"sessions"`
+	Lobbies  int    `json:"lobbies"`
+	Members  int    `json:"members"`
+}
+
+// AdminLobby is a lobby as shown by the admin API. The lobby's password is never shown.
+type AdminLobby struct {
+	ID       string         `json:"id"`
+	UGI      string         `json:"ugi"`
+	Settings *LobbySettings `json:"settings"`
+	Host     *PeerInfo      `json:"host"` // nil while the lobby awaits peer-based reclaim
+	Members  []*PeerInfo    `json:"members"`
+}
+
+// AdminSession is a connected client as shown by the admin API.
+type AdminSession struct {
+	ID      string `json:"id"`
+	User    string `json:"user"`
+	Session uint64 `json:"session"`
+	UGI     string `json:"ugi"`
+	Lobby   string `json:"lobby,omitempty"`
+	Mode    string `json:"mode"` // "none", "host" or "peer"
+	Address string `json:"address,omitempty"`
+}
+
+// AdminRelay is a server relay as shown by the admin API.
+type AdminRelay struct {
+	RelayStats
+	State     string    `json:"state"` // state of the relay's WebRTC peer connection
+	Connected bool      `json:"connected"`
+	Fallback  bool      `json:"fallback"`
+	Restarts  int       `json:"restarts"`
+	Started   time.Time `json:"started"`
+	Failed    time.Time `json:"failed,omitempty"`
+	Channels  []string  `json:"channels"`
+	Voice     bool      `json:"voice"`
+}
+
+// Announcement is the request body of the admin API's announce action, and the payload of the
+// ANNOUNCEMENT signaling opcode.
+type Announcement struct {
+	Message string `json:"message" validate:"required,max=1024" label:"message"`
+	UGI     string `json:"ugi,omitempty" validate:"omitempty,max=128" label:"ugi"`     // only used with lobby
+	Lobby   string `json:"lobby,omitempty" validate:"omitempty,max=128" label:"lobby"` // only announce to this lobby's members
+}
@@ -2,8 +2,10 @@ package structs
 
 import (
 	"sync"
+	"time"
 
 	"github.com/gofiber/contrib/websocket"
+	"golang.org/x/time/rate"
 )
 
 type Client struct {
@@ -21,6 +23,10 @@ type Client struct {
 	PublicKey                 string
 	TransitionDone            chan bool
 	InitialTransitionOverride bool
+	Address                   string                   // IP address of the client, from X-Forwarded-For if it came through a trusted proxy
+	Limiters                  map[string]*rate.Limiter // rate limits of each opcode, only used by the client's read loop
+	Violations                int                      // packets recently dropped for exceeding their rate limits
+	LastViolation             time.Time
 }
 
 func (c *Client) ClearMode() {
@@ -0,0 +1,48 @@
+package structs
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ConnectionLimits configures which connections the websocket upgrader accepts, on top of the
+// server's MaxSessions.
+type ConnectionLimits struct {
+	MaxSessionsPerIP int          // sessions an IP address may have at once, unlimited if 0
+	Rate             float64      // new connections per second from an IP address, unlimited if 0
+	Burst            int          // new connections an IP address may make at once
+	Allow            []*net.IPNet // only these networks may connect, unless empty
+	Deny             []*net.IPNet // these networks may never connect, even if they are allowed
+	TrustedProxies   []*net.IPNet // X-Forwarded-For is only read from requests made by these networks
+}
+
+// AddressStore tracks the connections of every IP address that recently connected to the server.
+type AddressStore struct {
+	Mutex     sync.Mutex
+	Addresses map[string]*AddressState // keyed by IP address
+	Sessions  int                      // sessions admitted for every address, counted against the server's session limit
+	Swept     time.Time                // when idle addresses were last forgotten
+}
+
+// AddressState tracks the connections of an IP address.
+type AddressState struct {
+	Sessions int
+	Limiter  *rate.Limiter // nil if the connection rate is unlimited
+	Seen     time.Time     // when the address last connected or disconnected
+}
+
+// SignalingLimits configures how much signaling traffic a client may send.
+type SignalingLimits struct {
+	Opcodes           map[string]OpcodeLimit // keyed by opcode, "*" applies to opcodes that aren't listed
+	MaxFrameSize      int64                  // largest websocket message a client may send, unlimited if 0
+	MaximumViolations int                    // rate limited packets tolerated before the client is disconnected
+}
+
+// OpcodeLimit is the rate limit of a signaling opcode, for each client.
+type OpcodeLimit struct {
+	Rate  float64 `json:"rate"`  // packets per second, unlimited if 0
+	Burst int     `json:"burst"` // packets that may be sent at once
+}
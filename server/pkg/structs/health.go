@@ -0,0 +1,21 @@
+package structs
+
+// Health statuses of the server and its components.
+const (
+	HEALTH_OK          = "ok"
+	HEALTH_UNAVAILABLE = "unavailable"
+	HEALTH_DISABLED    = "disabled" // the component isn't enabled, which doesn't affect readiness
+)
+
+// HealthReport is the response of the health and readiness endpoints.
+type HealthReport struct {
+	Status     string                      `json:"status"`
+	Version    string                      `json:"version"`
+	Components map[string]*ComponentHealth `json:"components,omitempty"`
+}
+
+// ComponentHealth is the status of one of the components checked by the readiness endpoint.
+type ComponentHealth struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
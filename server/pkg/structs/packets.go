@@ -1,6 +1,10 @@
 package structs
 
-import "github.com/pion/webrtc/v4"
+import (
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
 
 // Declare the packet format for signaling.
 type SignalPacket struct {
@@ -31,15 +35,47 @@ type HostConfigPacket struct {
 }
 
 type LobbySettings struct {
-	LobbyID             string `json:"lobby_id" label:"lobby_id" validate:"required"`
-	UseServerRelay      bool   `json:"use_server_relay" validate:"boolean" label:"use_server_relay"`
-	AllowHostReclaim    bool   `json:"allow_host_reclaim" validate:"boolean" label:"allow_host_reclaim"`
-	AllowPeersToReclaim bool   `json:"allow_peers_to_claim_host" validate:"boolean" label:"allow_peers_to_claim_host"`
-	MaximumPeers        int    `json:"max_peers" validate:"min=0" label:"max_peers"`
-	Password            string `json:"password" validate:"omitempty,omitnil,max=128" label:"password"`
-	Locked              bool   `json:"locked" validate:"boolean" label:"locked"`
-	PublicKey           string `json:"pubkey,omitempty" validate:"omitempty,omitnil" label:"pubkey"`
-	ReclaimInProgress   bool   `json:"reclaim_in_progress,omitempty" validate:"omitempty,omitnil"` // This is an internal flag, not to be used by clients.
+	LobbyID             string        `json:"lobby_id" label:"lobby_id" validate:"required"`
+	UseServerRelay      bool          `json:"use_server_relay" validate:"boolean" label:"use_server_relay"`
+	AllowHostReclaim    bool          `json:"allow_host_reclaim" validate:"boolean" label:"allow_host_reclaim"`
+	AllowPeersToReclaim bool          `json:"allow_peers_to_claim_host" validate:"boolean" label:"allow_peers_to_claim_host"`
+	MaximumPeers        int           `json:"max_peers" validate:"min=0" label:"max_peers"`
+	Password            string        `json:"password" validate:"omitempty,omitnil,max=128" label:"password"`
+	Locked              bool          `json:"locked" validate:"boolean" label:"locked"`
+	PublicKey           string        `json:"pubkey,omitempty" validate:"omitempty,omitnil" label:"pubkey"`
+	ReclaimInProgress   bool          `json:"reclaim_in_progress,omitempty" validate:"omitempty,omitnil"`                            // This is an internal flag, not to be used by clients.
+	RelayLimits         *RelayLimits  `json:"relay_limits,omitempty" validate:"omitempty" label:"relay_limits"`                      // Server defaults are used if not set.
+	Tick                *TickSettings `json:"tick,omitempty" validate:"omitempty" label:"tick"`                                      // Enables lockstep tick mode in the server relay.
+	Record              bool          `json:"record,omitempty" validate:"boolean" label:"record"`                                    // Records the server relay's traffic, if the server has a recordings directory.
+	SuggestMigration    bool          `json:"suggest_migration,omitempty" validate:"boolean" label:"suggest_migration"`              // Sends the host MIGRATE_HOST when a peer is much better connected.
+	Topology            string        `json:"topology,omitempty" validate:"omitempty,oneof=mesh star relay hybrid" label:"topology"` // Which members connect to each other, defaults to mesh.
+	MeshSize            int           `json:"mesh_size,omitempty" validate:"min=0,max=256" label:"mesh_size"`                        // Members in the mesh of a hybrid lobby, defaults to 8.
+}
+
+// Lobby topologies decide which lobby members connect to each other over WebRTC.
+const (
+	MESH_TOPOLOGY   = "mesh"   // Every member connects to every other member
+	STAR_TOPOLOGY   = "star"   // Peers only connect to the host
+	RELAY_TOPOLOGY  = "relay"  // Members only connect to the server relay
+	HYBRID_TOPOLOGY = "hybrid" // The first mesh_size members form a mesh, the others only connect to the host
+)
+
+// TickSettings configures a lobby's lockstep tick loop.
+type TickSettings struct {
+	Rate     int    `json:"rate" validate:"min=1,max=120" label:"rate"`                                   // Frames per second
+	Policy   string `json:"policy,omitempty" validate:"omitempty,oneof=skip repeat stall" label:"policy"` // How to handle peers that didn't report in time, defaults to skip
+	MaxStall int    `json:"max_stall" validate:"min=0" label:"max_stall"`                                 // Frames the stall policy waits for slow peers before skipping them
+	Window   int    `json:"window" validate:"min=0,max=1024" label:"window"`                              // Frames buffered for late joiners
+}
+
+// RelayLimits configures the per-peer rate limits of each data channel in a lobby's server relay.
+// Zero values fall back to the server defaults.
+type RelayLimits struct {
+	MessagesPerSecond float64 `json:"messages_per_second" validate:"min=0" label:"messages_per_second"`
+	MessageBurst      int     `json:"message_burst" validate:"min=0" label:"message_burst"`
+	BytesPerSecond    float64 `json:"bytes_per_second" validate:"min=0" label:"bytes_per_second"`
+	ByteBurst         int     `json:"byte_burst" validate:"min=0" label:"byte_burst"`
+	MaximumViolations int     `json:"max_violations" validate:"min=0" label:"max_violations"` // Dropped messages tolerated before the peer is disconnected from the relay.
 }
 
 // Declare the packet format for the CONFIG_PEER signaling command.
@@ -86,6 +122,7 @@ type LobbyInfo struct {
 	CurrentPeers      int    `json:"current_peers"`
 	PasswordRequired  bool   `json:"password_required"`
 	Reclaimable       bool   `json:"reclaimable"`
+	Topology          string `json:"topology"`
 }
 
 // Declare the packet format for webrtc relay.
@@ -95,12 +132,156 @@ type RelayPacket struct {
 	Origin    *PeerInfo `json:"origin,omitempty" validate:"omitempty,omitnil" label:"origin"`            // Relay -> Peer, identifies client that sent the message
 	Recipient string    `json:"recipient,omitempty" validate:"omitempty,omitnil" label:"recipient"`      // Peer -> Relay, identifies client that should receive the message
 	Channel   string    `json:"channel,omitempty" validate:"omitempty,omitnil" label:"channel"`          // Used to specify what channel the relayed packet belongs to
+	Topic     string    `json:"topic,omitempty" validate:"omitempty,omitnil" label:"topic"`              // Used to deliver G_* broadcasts only to subscribed peers, defaults to the channel
+	ID        string    `json:"id,omitempty" validate:"omitempty,omitnil" label:"id"`                    // Identifies an RPC call and its reply
+	Frame     uint64    `json:"frame,omitempty" validate:"omitempty" label:"frame"`                      // Identifies the lockstep frame of an INPUT or TICK
+	Timeout   int       `json:"timeout,omitempty" validate:"omitempty,min=0" label:"timeout"`            // Peer -> Relay, milliseconds to wait for an RPC reply
+}
+
+// Declare the payload format for the FRAG relay opcode, which carries one piece of a message
+// that is too large to be sent as a single data channel message.
+type RelayFragment struct {
+	ID    string `json:"id" validate:"required" label:"id"`
+	Index int    `json:"index" validate:"min=0,ltfield=Total" label:"index"`
+	Total int    `json:"total" validate:"min=1" label:"total"`
+	Data  []byte `json:"data" validate:"required" label:"data"`
+}
+
+type RelayFragmentPacket struct {
+	Opcode  string         `json:"opcode" validate:"required" label:"opcode"`
+	Payload *RelayFragment `json:"payload" validate:"required" label:"payload"`
+	Channel string         `json:"channel,omitempty" validate:"omitempty,omitnil" label:"channel"`
+}
+
+// Declare the payload format for the THROTTLED relay opcode.
+type Throttled struct {
+	Channel    string `json:"channel"`
+	Limit      string `json:"limit"`      // "messages" or "bytes"
+	Violations int    `json:"violations"` // Dropped messages so far
+	Maximum    int    `json:"max_violations"`
+}
+
+// Declare the payload format for the RELAY_STATS relay opcode.
+type RelayStats struct {
+	ID          string `json:"id"`
+	User        string `json:"user"`
+	MessagesIn  uint64 `json:"messages_in"`
+	MessagesOut uint64 `json:"messages_out"`
+	BytesIn     uint64 `json:"bytes_in"`
+	BytesOut    uint64 `json:"bytes_out"`
+	Throttled   uint64 `json:"throttled"`
+}
+
+// Declare the payload format for the TICK relay opcode.
+type TickBundle struct {
+	Frame   uint64         `json:"frame"`
+	Inputs  map[string]any `json:"inputs"`            // keyed by peer ID
+	Missing []string       `json:"missing,omitempty"` // peers that didn't report in time
+}
+
+// Declare the payload format for RPC_ERROR replies generated by the relay.
+type RPCError struct {
+	Reason  string `json:"reason"` // "timeout", "unreachable", "gone", or "invalid"
+	Message string `json:"message"`
+}
+
+// Declare the payload format for the RELAY_ERROR signaling opcode, sent when the server relay fails.
+type RelayError struct {
+	Reason     string `json:"reason"` // "spawn", "negotiation", "panic", "timeout", or "unavailable"
+	Message    string `json:"message"`
+	Restarting bool   `json:"restarting"` // The server is restarting the relay, expect another DISCOVER or MAKE_OFFER
+}
+
+// Declare the payload format for the QUALITY_REPORT signaling opcode, which carries a client's
+// connection stats for one of the peers it is connected to.
+type QualitySample struct {
+	Peer string    `json:"peer" validate:"required,max=64" label:"peer"` // ID of the peer, or "relay"
+	RTT  float64   `json:"rtt" validate:"min=0" label:"rtt"`             // Round trip time in milliseconds
+	Loss float64   `json:"loss" validate:"min=0,max=1" label:"loss"`     // Fraction of packets lost
+	Time time.Time `json:"time,omitempty"`                               // Set by the server when the report arrives
+}
+
+type QualityReportPacket struct {
+	Opcode   string           `json:"opcode" validate:"required" label:"opcode"`                         // Required for protocol compliance
+	Payload  []*QualitySample `json:"payload" validate:"required,max=256,dive,required" label:"payload"` // Required for protocol compliance
+	Listener string           `json:"listener,omitempty" validate:"omitempty,omitnil" label:"listener"`  // For clients to listen to server replies
+}
+
+// Declare the payload format for the QUALITY_MATRIX signaling opcode.
+type ConnectivityMatrix struct {
+	Samples map[string]map[string]*QualitySample `json:"samples"` // The latest sample each member reported for each peer
+	Scores  map[string]float64                   `json:"scores"`  // Aggregate connection cost of each member with reports, lower is better
+}
+
+// Declare the payload format for the MIGRATE_HOST signaling opcode, which suggests a better connected host.
+type MigrateHostParams struct {
+	ID        string  `json:"id"`
+	User      string  `json:"user"`
+	Score     float64 `json:"score"`      // Aggregate connection cost of the suggested peer
+	HostScore float64 `json:"host_score"` // Aggregate connection cost of the current host
+}
+
+// Declare the payload format for the DIAGNOSE_START signaling opcode, which tells the client how
+// to configure its test connection before the server sends its offer.
+type DiagnoseStart struct {
+	ICEServers []webrtc.ICEServer `json:"ice_servers"` // Include these so that the server can observe the client's NAT
+	Timeout    int                `json:"timeout"`     // Milliseconds until the test gives up
+}
+
+// Declare the payload format for the DIAGNOSE_REPORT signaling opcode.
+type DiagnosticReport struct {
+	Connected       bool     `json:"connected"`                  // The test data channel opened
+	LocalCandidate  string   `json:"local_candidate,omitempty"`  // Type of the server's candidate in the selected pair: host, srflx, prflx or relay
+	RemoteCandidate string   `json:"remote_candidate,omitempty"` // Type of the client's candidate in the selected pair
+	Gathered        []string `json:"gathered"`                   // Candidate types the client offered
+	Succeeded       []string `json:"succeeded"`                  // Candidate types of the client that passed connectivity checks
+	TURNRequired    bool     `json:"turn_required"`              // Only pairs using a TURN relay candidate connected
+	RTT             float64  `json:"rtt_ms"`                     // Round trip time of the selected pair
+	NAT             string   `json:"nat"`                        // Mapping behaviour observed by the STUN responder: endpoint-independent, endpoint-dependent or unknown
+	MappedAddress   string   `json:"mapped_address,omitempty"`   // The client's public address, as seen by the server
+	Duration        float64  `json:"duration_ms"`                // How long the test took
+	Error           string   `json:"error,omitempty"`
+}
+
+// Declare the payload format for the NEW_CHAN relay opcode, which describes a data channel
+// that was opened with the relay.
+type RelayChannelInfo struct {
+	Label             string  `json:"label"`
+	Ordered           bool    `json:"ordered"`
+	MaxRetransmits    *uint16 `json:"max_retransmits,omitempty"`
+	MaxPacketLifeTime *uint16 `json:"max_packet_lifetime,omitempty"`
+	Protocol          string  `json:"protocol,omitempty"`
+}
+
+// Declare the payload format for the G_VAR and G_LIST relay opcodes.
+// If Lock is set, only the peer that set the value (or the lobby host) may change it
+// until it is set again with Lock cleared.
+type SharedVariable struct {
+	Name  string `json:"name" validate:"required" label:"name"`
+	Value any    `json:"value"`
+	Lock  *bool  `json:"lock,omitempty"`
+}
+
+// SharedValue is a stored global variable or list.
+type SharedValue struct {
+	Value  any    `json:"value"`
+	Owner  string `json:"owner"` // ID of the peer that last set the value
+	Locked bool   `json:"locked"`
+}
+
+// Declare the payload format for the STATE_SYNC relay opcode.
+type StateSnapshot struct {
+	Vars  map[string]SharedValue `json:"vars"`
+	Lists map[string]SharedValue `json:"lists"`
 }
 
 // As per CL5 spec, there are two kinds of candidates - data and voice.
 var DATA_CANDIDATE uint8 = 0
 var VOICE_CANDIDATE uint8 = 1
 
+// Test connections made by the DIAGNOSE opcode use their own candidate type.
+var DIAGNOSTIC_CANDIDATE uint8 = 2
+
 // Declare the packet format for handling relay candidate data.
 type RelayCandidate struct {
 	Type     uint8                      `json:"type" validate:"required" label:"type"`
@@ -138,3 +319,40 @@ type RelayOutboundIcePacket struct {
 	Origin    *PeerInfo         `json:"origin,omitempty" validate:"omitempty,omitnil" label:"origin"`            // Relay -> Peer, identifies client that sent the message
 	Recipient string            `json:"recipient,omitempty" validate:"omitempty,omitnil" label:"recipient"`      // Peer -> Relay, identifies client that should receive the message
 }
+
+// Declare the packet format for relay packets sent over the signaling websocket, used by
+// clients that can't connect to the relay over WebRTC.
+type RelayInboundSocketFrame struct {
+	Channel string       `json:"channel,omitempty" validate:"omitempty,max=128" label:"channel"` // The data channel the packet belongs to, defaults to "default"
+	Packet  *RelayPacket `json:"packet" validate:"required" label:"packet"`
+}
+
+type RelayInboundSocketPacket struct {
+	Opcode   string                   `json:"opcode" validate:"required" label:"opcode"`                        // Required for protocol compliance
+	Payload  *RelayInboundSocketFrame `json:"payload" validate:"required" label:"payload"`                      // Required for protocol compliance
+	Listener string                   `json:"listener,omitempty" validate:"omitempty,omitnil" label:"listener"` // For clients to listen to server replies
+}
+
+// Declare the payload format for relay packets sent to clients over the signaling websocket.
+type RelayOutboundSocketFrame struct {
+	Channel string `json:"channel"`
+	Packet  any    `json:"packet"`
+}
+
+// Declare the payload format for the SERVER_SHUTDOWN signaling opcode, which is sent to every
+// client when the server starts draining.
+type ShutdownParams struct {
+	Reason     string    `json:"reason"`              // "shutdown" when the server is exiting, or "drain"
+	Reconnect  string    `json:"reconnect,omitempty"` // URL to reconnect to, or empty to reconnect to the same address
+	RetryAfter int       `json:"retry_after"`         // Milliseconds to wait before reconnecting
+	Deadline   time.Time `json:"deadline"`            // When remaining clients are disconnected
+}
+
+// Declare the payload format for the RATE_LIMITED signaling opcode, which is sent in reply to
+// packets that were dropped for exceeding their opcode's rate limit.
+type RateLimited struct {
+	Opcode     string `json:"opcode"`
+	RetryAfter int    `json:"retry_after"` // Milliseconds until the opcode may be sent again
+	Violations int    `json:"violations"`  // Dropped packets so far
+	Maximum    int    `json:"max_violations"`
+}
@@ -0,0 +1,76 @@
+package structs
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// ErrRecordingLimit is returned by the write that stops a recording at its size or duration limit.
+var ErrRecordingLimit = errors.New("recording reached its size or duration limit")
+
+// Recorder writes a lobby's relay traffic and membership events to an append-only file,
+// one JSON encoded RecordEntry per line.
+type Recorder struct {
+	Mux         sync.Mutex
+	File        *os.File
+	Path        string
+	Started     time.Time
+	Closed      bool
+	Size        int64         // bytes written so far
+	MaxSize     int64         // bytes the recording may grow to, unlimited if 0
+	MaxDuration time.Duration // how long the recording may run, unlimited if 0
+}
+
+// RecordEntry is a single event in a relay recording.
+type RecordEntry struct {
+	Time     time.Duration     `json:"time"`  // nanoseconds since the recording started
+	Event    string            `json:"event"` // "start", "open", "packet", "join", or "leave"
+	Origin   *PeerInfo         `json:"origin,omitempty"`
+	Settings *LobbySettings    `json:"settings,omitempty"` // the lobby's settings, only set on the "start" event
+	Channel  string            `json:"channel,omitempty"`
+	Info     *RelayChannelInfo `json:"info,omitempty"` // settings of a data channel the peer opened, only set on the "open" event
+	Opcode   string            `json:"opcode,omitempty"`
+	Packet   *RelayPacket      `json:"packet,omitempty"`
+}
+
+// Write appends an entry to the recording, stamping it with the time since the recording started.
+// Writes to a closed recorder are ignored. If the entry would take the recording past its size or
+// duration limit, a "stop" entry is written instead, the recording is closed and ErrRecordingLimit
+// is returned.
+func (r *Recorder) Write(entry *RecordEntry) error {
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+	if r.Closed {
+		return nil
+	}
+	entry.Time = time.Since(r.Started)
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if (r.MaxSize > 0 && r.Size+int64(len(bytes))+1 > r.MaxSize) || (r.MaxDuration > 0 && entry.Time > r.MaxDuration) {
+		stop, _ := json.Marshal(&RecordEntry{Time: entry.Time, Event: "stop"})
+		r.File.Write(append(stop, '\n'))
+		r.Closed = true
+		r.File.Close()
+		return ErrRecordingLimit
+	}
+	written, err := r.File.Write(append(bytes, '\n'))
+	r.Size += int64(written)
+	return err
+}
+
+// Close closes the recording. It is safe to call more than once.
+func (r *Recorder) Close() error {
+	r.Mux.Lock()
+	defer r.Mux.Unlock()
+	if r.Closed {
+		return nil
+	}
+	r.Closed = true
+	return r.File.Close()
+}
@@ -1,7 +1,12 @@
 package structs
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/pion/webrtc/v4"
+	"golang.org/x/time/rate"
 )
 
 type Relay struct {
@@ -12,6 +17,77 @@ type Relay struct {
 	Peer             *Client
 	Lobby            string // lobby id
 	Running          bool
-	RequestShutdown  chan bool // used to shutdown the relay.
-	ShutdownComplete chan bool // used to wait for shutdown to complete.
+	RequestShutdown  chan bool                  // used to shutdown the relay.
+	ShutdownComplete chan bool                  // closed once the shutdown completes.
+	Voice            *VoiceRelay                // nil until the peer negotiates a voice connection with the relay.
+	Mux              *sync.RWMutex              // protects the relay's channels, calls, fragments, limiters, topics and voice state
+	Calls            map[string]*RelayCall      // RPC calls made by the peer that are awaiting a reply, keyed by request ID
+	Encodings        map[string]string          // wire encoding of each data channel, JSON unless the peer chose binary framing
+	Fragments        map[string]*RelayFragments // partially received fragmented messages, keyed by channel and message ID
+	Limiters         map[string]*RelayLimiter   // rate limits of each data channel
+	Violations       int                        // messages recently dropped for exceeding the lobby's limits
+	LastViolation    time.Time
+	Counters         RelayCounters
+	LobbyCounters    *RelayCounters  // the lobby's traffic, which outlives the relay
+	Topics           map[string]bool // topics the peer wants G_* broadcasts for, "*" subscribes to everything
+	Recorder         *Recorder       // the lobby's recorder, nil unless the lobby is being recorded
+	Fallback         bool            // relay packets are exchanged over the signaling websocket instead of WebRTC
+	Started          time.Time       // when the relay was spawned
+	Connected        bool            // the peer connection has connected at least once
+	Failed           time.Time       // when the peer connection last failed or disconnected, zero while it is healthy
+	Restarts         int             // times the supervisor has restarted the relay or its ICE session
+}
+
+// RelayLimiter holds the token buckets that limit the message rate and bandwidth of a data channel.
+type RelayLimiter struct {
+	Messages          *rate.Limiter
+	Bytes             *rate.Limiter
+	MaximumViolations int
+}
+
+// RelayCounters tracks the traffic of a relay.
+type RelayCounters struct {
+	MessagesIn  atomic.Uint64
+	MessagesOut atomic.Uint64
+	BytesIn     atomic.Uint64
+	BytesOut    atomic.Uint64
+	Throttled   atomic.Uint64
+}
+
+// Add adds the traffic of other counters to these counters.
+func (c *RelayCounters) Add(other *RelayCounters) {
+	c.MessagesIn.Add(other.MessagesIn.Load())
+	c.MessagesOut.Add(other.MessagesOut.Load())
+	c.BytesIn.Add(other.BytesIn.Load())
+	c.BytesOut.Add(other.BytesOut.Load())
+	c.Throttled.Add(other.Throttled.Load())
+}
+
+// RelayFragments is a fragmented message that the relay is reassembling.
+type RelayFragments struct {
+	Parts    [][]byte
+	Received int
+	Size     int
+	Started  time.Time
+}
+
+// RelayCall is an RPC call made through the relay that is awaiting a reply.
+type RelayCall struct {
+	ID      string
+	Callee  string // ID of the peer that was called
+	Channel string
+	Timer   *time.Timer
+}
+
+// VoiceRelay holds the state of a relay's voice connection. The relay acts as a
+// selective forwarding unit: audio published by the peer is forwarded to the
+// voice connections of the other relays in the lobby.
+type VoiceRelay struct {
+	Mux                  *sync.Mutex
+	Conn                 *webrtc.PeerConnection
+	Track                *webrtc.TrackLocalStaticRTP  // audio published by the peer, nil until the peer sends a track
+	Senders              map[string]*webrtc.RTPSender // audio forwarded to the peer, keyed by the ID of the publishing peer
+	Unsubscribed         map[string]bool              // peers that the peer does not want to hear
+	Muted                bool
+	PendingRenegotiation bool // set if a renegotiation was requested while an offer was in flight
 }
@@ -1,22 +1,68 @@
 package structs
 
 import (
-	"regexp"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/signaling/origin"
+	"github.com/MikeDev101/cloudlink-phi/server/pkg/stun"
 	"github.com/go-playground/validator/v10"
+	"github.com/pion/webrtc/v4"
 )
 
 type Server struct {
-	AuthorizedOriginsStorage []*regexp.Regexp
-	Mux                      *sync.RWMutex
-	Games                    *GameStore
-	Sessions                 *SessionStore
-	TURNOnly                 bool
-	Relays                   map[*Client]*Relay
-	RelayLock                *sync.RWMutex
-	PacketValidator          *validator.Validate
-	WebsocketConnCounter     uint64
+	Settings             atomic.Pointer[Settings]      // replaced as a whole to reload the configuration
+	Origins              atomic.Pointer[origin.Policy] // replaced to reload the origin policy
+	OriginBase           atomic.Pointer[origin.Policy] // policy of the flags, policy file or admin API, which the configuration file's origins apply on top of
+	OriginPolicyFile     string                        // file the origin policy is reloaded from, if any
+	Mux                  *sync.RWMutex
+	Games                *GameStore
+	Sessions             *SessionStore
+	Relays               map[*Client]*Relay
+	RelayLock            *sync.RWMutex
+	PacketValidator      *validator.Validate
+	WebsocketConnCounter uint64
+	STUN                 *stun.Responder // embedded STUN responder used by DIAGNOSE, NAT behaviour is not reported if nil
+	Diagnostics          map[*Client]*Diagnostic
+	DiagnosticsLock      *sync.RWMutex
+	Draining             atomic.Bool   // new sessions and lobbies are refused while the server drains
+	Drains               atomic.Uint64 // drains that have started, so that a drain knows when a newer one superseded it
+	DrainLock            sync.Mutex    // held while a drain starts, is cancelled, or kicks the clients that are left
+	Inflight             atomic.Int64  // signaling packets that are being handled
+	Addresses            *AddressStore
+	Reload               func() error  // reloads the configuration file, nil if the server has none
+	Stopped              chan struct{} // closed when the server shuts down, which stops its background tasks
+	Traffic              RelayCounters // relay traffic of games that have been removed
+}
+
+// Settings are the parts of the server's configuration that can be reloaded while it runs.
+// They are replaced as a whole, so loaded settings must never be changed. New values apply to
+// new sessions, lobbies and relays, while existing ones keep the values they started with.
+type Settings struct {
+	TURNOnly     bool
+	ICEServers   []webrtc.ICEServer // used by relay peer connections, the relay's defaults are used if nil
+	MaxSessions  int                // sessions the server accepts at once, unlimited if 0
+	Limits       *ConnectionLimits
+	PacketLimits *SignalingLimits
+	DrainTimeout time.Duration // how long clients are given to leave when the server drains
+	ReconnectURL string        // sent to clients when the server drains, empty to reconnect to the same address
+
+	RecordingsDir        string        // directory that relay recordings are written to, recording is disabled if empty
+	RecordingMaxSize     int64         // bytes a recording may grow to before it is stopped, unlimited if 0
+	RecordingMaxDuration time.Duration // how long a recording may run before it is stopped, unlimited if 0
+
+	// The embedded STUN responder is started with these settings, so changing them takes a restart
+	STUNHost    string // public host name that clients reach the responder with, the responder is disabled if empty
+	STUNPort    int    // UDP port of the responder
+	STUNAltPort int    // second UDP port, used to classify NAT behaviour
+}
+
+// Diagnostic holds a test connection between the server and a client, made by the DIAGNOSE opcode.
+type Diagnostic struct {
+	Conn    *webrtc.PeerConnection
+	Opened  chan bool // closed once the test data channel opens
+	Started time.Time
 }
 
 type Lobby struct {
@@ -24,12 +70,60 @@ type Lobby struct {
 	Host     *Client
 	Settings *LobbySettings
 	Clients  []*Client
+	Store    *SharedStore                         // global variables and lists set through the server relay
+	Ticker   *TickLoop                            // nil unless the lobby uses lockstep tick mode
+	Recorder *Recorder                            // nil unless the lobby's relay traffic is being recorded
+	Restarts map[string]*IceRestart               // ICE restarts in progress, keyed by the IDs of both members
+	Quality  map[string]map[string]*QualitySample // the latest QUALITY_REPORT samples, keyed by reporter and peer ID
+	Migrated time.Time                            // when a MIGRATE_HOST suggestion was last sent
+	Traffic  *RelayCounters                       // traffic of every relay that has been in the lobby
+}
+
+// IceRestart tracks an ICE restart between two lobby members, or between a member and the server relay.
+type IceRestart struct {
+	Initiator *Client
+	Target    *Client // nil if the target is the server relay
+	Started   time.Time
+	Timer     *time.Timer // times the restart out
+}
+
+// TickLoop holds the state of a lobby's lockstep tick loop.
+type TickLoop struct {
+	Mux      sync.Mutex
+	Settings *TickSettings
+	Frame    uint64
+	Inputs   map[string]any // inputs for the frame being collected, keyed by peer ID
+	Last     map[string]any // last input of each peer, used by the repeat policy
+	Stalled  int            // deadlines the current frame has been stalled for
+	History  []*TickBundle  // the most recent frames, for late joiners
+	Ready    chan bool      // signalled when every peer has reported
+	Stop     chan bool
+	Stopped  bool
+}
+
+// Halt stops the tick loop. It is safe to call more than once.
+func (t *TickLoop) Halt() {
+	t.Mux.Lock()
+	defer t.Mux.Unlock()
+	if !t.Stopped {
+		t.Stopped = true
+		close(t.Stop)
+	}
+}
+
+// SharedStore holds the current value of every global variable and list that
+// peers have set through the server relay, so that late joiners can catch up.
+type SharedStore struct {
+	Mutex sync.RWMutex
+	Vars  map[string]*SharedValue
+	Lists map[string]*SharedValue
 }
 
 type Game struct {
 	Mutex   sync.RWMutex
 	Lobbies map[string]*Lobby
 	Clients []*Client
+	Traffic RelayCounters // relay traffic of the game's lobbies that have been destroyed
 }
 
 type Session struct {
@@ -49,3 +143,10 @@ type GameStore struct {
 	Mutex sync.RWMutex
 	Games map[string]*Game
 }
+
+// GameStats is a snapshot of a game's sessions and lobbies, used by the metrics endpoint.
+type GameStats struct {
+	Sessions int // clients connected to the game
+	Lobbies  int // lobbies in the game, excluding the default lobby
+	Members  int // clients in the game's lobbies, including hosts
+}
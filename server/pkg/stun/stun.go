@@ -0,0 +1,250 @@
+// Package stun implements a minimal STUN responder, used to observe the NAT behaviour of clients
+// running connectivity diagnostics.
+package stun
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/stun/v3"
+)
+
+// ObservationLifetime is how long the responder remembers the address it observed for a request.
+const ObservationLifetime = 2 * time.Minute
+
+// Source addresses of UDP requests are easily spoofed, so the responder's memory is bounded.
+const (
+	MaximumObservations = 16    // observations remembered per IP address, the oldest are forgotten first
+	MaximumAddresses    = 65536 // IP addresses remembered at once, requests from others aren't recorded
+	PruneInterval       = 30 * time.Second
+)
+
+// NAT behaviours reported by Classify.
+const (
+	MappingUnknown             = "unknown"
+	MappingEndpointIndependent = "endpoint-independent"
+	MappingEndpointDependent   = "endpoint-dependent"
+)
+
+// Observation is the address a binding request was observed from.
+type Observation struct {
+	Port   int          // the responder port that received the request
+	Mapped *net.UDPAddr // the source address of the request, as seen by the responder
+	Time   time.Time
+}
+
+// Responder answers STUN binding requests on two or more UDP ports and remembers the source
+// address of every request, so that it can tell whether a client's NAT maps its address
+// differently depending on the destination.
+type Responder struct {
+	URLs  []string // STUN URLs that clients should use to reach the responder
+	conns []net.PacketConn
+	mux   sync.Mutex
+	seen  map[string][]*Observation // observations keyed by source IP
+	done  chan struct{}             // closed to stop pruning
+	once  sync.Once
+}
+
+// Listen starts a responder on each of the given UDP addresses. Clients reach it through the
+// given public host name. At least two addresses are needed to classify NAT behaviour.
+func Listen(host string, addrs ...string) (*Responder, error) {
+	r := &Responder{
+		seen: make(map[string][]*Observation),
+		done: make(chan struct{}),
+	}
+	for _, addr := range addrs {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		r.conns = append(r.conns, conn)
+		r.URLs = append(r.URLs, fmt.Sprintf("stun:%s", net.JoinHostPort(host, strconv.Itoa(port))))
+		go r.serve(conn, port)
+	}
+	go r.pruneEvery(PruneInterval)
+	slog.Info("STUN responder listening", "urls", r.URLs)
+	return r, nil
+}
+
+// serve answers binding requests received on a connection until it is closed.
+func (r *Responder) serve(conn net.PacketConn, port int) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		source, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		// Only answer binding requests
+		request := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+		if err := request.Decode(); err != nil || request.Type != stun.BindingRequest {
+			continue
+		}
+		r.observe(port, source)
+
+		response, err := stun.Build(
+			stun.NewTransactionIDSetter(request.TransactionID),
+			stun.BindingSuccess,
+			&stun.XORMappedAddress{IP: source.IP, Port: source.Port},
+			stun.Fingerprint,
+		)
+		if err != nil {
+			slog.Warn("STUN responder error", "error", err)
+			continue
+		}
+		conn.WriteTo(response.Raw, addr)
+	}
+}
+
+// observe records the source address of a binding request. Only the latest MaximumObservations
+// of an address are kept, and new addresses aren't recorded while MaximumAddresses are remembered.
+func (r *Responder) observe(port int, source *net.UDPAddr) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	key := source.IP.String()
+	observations, exists := r.seen[key]
+	if !exists && len(r.seen) >= MaximumAddresses {
+		return
+	}
+	if len(observations) >= MaximumObservations {
+		observations = append(observations[:0], observations[len(observations)-MaximumObservations+1:]...)
+	}
+	r.seen[key] = append(observations, &Observation{Port: port, Mapped: source, Time: time.Now()})
+}
+
+// pruneEvery forgets stale observations at every interval, until the responder is closed.
+func (r *Responder) pruneEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			r.prune(now)
+		}
+	}
+}
+
+// prune forgets observations older than ObservationLifetime.
+func (r *Responder) prune(now time.Time) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for ip, observations := range r.seen {
+		fresh := observations[:0]
+		for _, observation := range observations {
+			if now.Sub(observation.Time) < ObservationLifetime {
+				fresh = append(fresh, observation)
+			}
+		}
+		if len(fresh) == 0 {
+			delete(r.seen, ip)
+		} else {
+			r.seen[ip] = fresh
+		}
+	}
+}
+
+// Observed returns the observations of binding requests from an IP address since the given time.
+func (r *Responder) Observed(ip string, since time.Time) []*Observation {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	observations := []*Observation{}
+	for _, observation := range r.seen[ip] {
+		if !observation.Time.Before(since) && time.Since(observation.Time) < ObservationLifetime {
+			observations = append(observations, observation)
+		}
+	}
+	return observations
+}
+
+// Close stops the responder.
+func (r *Responder) Close() error {
+	r.once.Do(func() { close(r.done) })
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// Classify reports the NAT mapping behaviour shown by a client's observations. If requests to
+// different responder ports were seen from the same mapped port, the mapping is endpoint
+// independent, otherwise it depends on the destination (a symmetric NAT). It returns
+// MappingUnknown unless requests reached at least two responder ports.
+func Classify(observations []*Observation) string {
+	mapped := make(map[int]map[int]bool) // responder port -> mapped ports
+	for _, observation := range observations {
+		if mapped[observation.Port] == nil {
+			mapped[observation.Port] = make(map[int]bool)
+		}
+		mapped[observation.Port][observation.Mapped.Port] = true
+	}
+	if len(mapped) < 2 {
+		return MappingUnknown
+	}
+
+	// Look for a mapped port that was used to reach every responder port
+	counts := make(map[int]int)
+	for _, ports := range mapped {
+		for port := range ports {
+			counts[port]++
+		}
+	}
+	for _, count := range counts {
+		if count == len(mapped) {
+			return MappingEndpointIndependent
+		}
+	}
+	return MappingEndpointDependent
+}
+
+// Check sends a binding request to each of the responder's ports over the loopback interface,
+// and returns an error if any of them doesn't answer within the timeout.
+func (r *Responder) Check(timeout time.Duration) error {
+	for _, listener := range r.conns {
+		port := listener.LocalAddr().(*net.UDPAddr).Port
+		if err := check(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}, timeout); err != nil {
+			return fmt.Errorf("port %d: %w", port, err)
+		}
+	}
+	return nil
+}
+
+// check sends a binding request to an address and waits for a successful response.
+func check(addr *net.UDPAddr, timeout time.Duration) error {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	request, err := stun.Build(stun.TransactionID, stun.BindingRequest, stun.Fingerprint)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(request.Raw); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		response := &stun.Message{Raw: buf[:n]}
+		if response.Decode() == nil && response.Type == stun.BindingSuccess && response.TransactionID == request.TransactionID {
+			return nil
+		}
+	}
+}
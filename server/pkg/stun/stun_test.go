@@ -0,0 +1,65 @@
+package stun
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newResponder() *Responder {
+	return &Responder{seen: make(map[string][]*Observation), done: make(chan struct{})}
+}
+
+func TestObservationsAreBounded(t *testing.T) {
+	r := newResponder()
+	for port := 1; port <= MaximumObservations*2; port++ {
+		r.observe(3478, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: port})
+	}
+	observations := r.Observed("198.51.100.1", time.Time{})
+	if len(observations) != MaximumObservations {
+		t.Fatalf("%d observations are remembered, want %d", len(observations), MaximumObservations)
+	}
+	if last := observations[len(observations)-1].Mapped.Port; last != MaximumObservations*2 {
+		t.Fatalf("the latest observation is from port %d, want %d", last, MaximumObservations*2)
+	}
+
+	for i := 0; len(r.seen) < MaximumAddresses; i++ {
+		r.observe(3478, &net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 1})
+	}
+	r.observe(3478, &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 1})
+	if len(r.Observed("203.0.113.1", time.Time{})) != 0 || len(r.seen) != MaximumAddresses {
+		t.Fatal("an address was recorded while the responder remembered the maximum number of addresses")
+	}
+	r.observe(3479, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 1})
+	if len(r.Observed("198.51.100.1", time.Time{})) != MaximumObservations {
+		t.Fatal("an address that was already remembered stopped being recorded")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	r := newResponder()
+	r.observe(3478, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 1})
+	r.prune(time.Now())
+	if len(r.seen) != 1 {
+		t.Fatal("a fresh observation was pruned")
+	}
+	r.prune(time.Now().Add(ObservationLifetime))
+	if len(r.seen) != 0 {
+		t.Fatal("a stale observation wasn't pruned")
+	}
+}
+
+func TestResponderAnswers(t *testing.T) {
+	r, err := Listen("127.0.0.1", "127.0.0.1:0", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	since := time.Now()
+	if err := r.Check(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if observations := r.Observed("127.0.0.1", since); len(observations) != 2 {
+		t.Fatalf("%d requests were observed, want 2", len(observations))
+	}
+}